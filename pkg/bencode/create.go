@@ -0,0 +1,385 @@
+package bencode
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anivanovic/gotit/pkg/merkle"
+)
+
+// MetaVersion selects metadata of created torrent.
+type MetaVersion int
+
+const (
+	// MetaV1 torrents have SHA-1 piece hashes.
+	MetaV1 MetaVersion = iota
+	// MetaV2 torrents have per-file SHA-256 merkle trees (BEP 52).
+	MetaV2
+	// MetaHybrid torrents have both v1 and v2 metadata.
+	MetaHybrid
+)
+
+const (
+	minPieceLength = merkle.BlockSize
+	maxPieceLength = 16 * 1024 * 1024
+	// targetPieces is number of pieces automatic piece length aims for.
+	targetPieces = 1500
+)
+
+var (
+	ErrEmptyTorrent = errors.New("bencode: torrent has no data")
+	// ErrPrivateNoTrackers is returned for private torrents without
+	// trackers, as trackers are their only source of peers (BEP 27).
+	ErrPrivateNoTrackers = errors.New("bencode: private torrent has no trackers")
+)
+
+// CreateOptions configures torrent created with Create.
+type CreateOptions struct {
+	// PieceLength must be power of two not less than 16 KiB. It is
+	// selected from content size when zero.
+	PieceLength int64
+	// AnnounceList holds tracker tiers. First tracker of the first tier
+	// is used as announce.
+	AnnounceList [][]string
+	// WebSeeds are written as url-list (BEP 19).
+	WebSeeds  []string
+	Comment   string
+	CreatedBy string
+	Private   bool
+	Version   MetaVersion
+	// Workers is number of goroutines hashing pieces. Number of CPUs
+	// is used when zero.
+	Workers int
+}
+
+func hasTracker(announceList [][]string) bool {
+	for _, tier := range announceList {
+		for _, tr := range tier {
+			if tr != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// createFile is file of created torrent.
+type createFile struct {
+	osPath string
+	path   []string
+	length int64
+	attr   string
+}
+
+// createPiece is piece data sent to hashing workers.
+type createPiece struct {
+	index int
+	data  []byte
+	// file and filePiece locate v2 piece in file piece layer.
+	file      int
+	filePiece int
+	// dataLength is length of file data in v2 piece, followed by padding.
+	dataLength int
+}
+
+// Create hashes file or directory at path and returns its torrent
+// metainfo, ready to be written with Marshal.
+func Create(path string, opts CreateOptions) (*Metainfo, error) {
+	if opts.Private && !hasTracker(opts.AnnounceList) {
+		return nil, ErrPrivateNoTrackers
+	}
+
+	path = filepath.Clean(path)
+	files, isDir, err := createFiles(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var total int64
+	for _, f := range files {
+		total += f.length
+	}
+	if total == 0 {
+		return nil, ErrEmptyTorrent
+	}
+
+	pieceLength := opts.PieceLength
+	if pieceLength == 0 {
+		pieceLength = autoPieceLength(total)
+	}
+	if pieceLength < minPieceLength || pieceLength != int64(merkle.NextPowerOfTwo(int(pieceLength))) {
+		return nil, fmt.Errorf("bencode: invalid piece length %d", pieceLength)
+	}
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	h := &hasher{
+		files:       files,
+		pieceLength: int(pieceLength),
+		v1:          opts.Version != MetaV2,
+		v2:          opts.Version != MetaV1,
+	}
+	if err := h.hash(workers); err != nil {
+		return nil, err
+	}
+
+	m := &Metainfo{
+		AnnounceList: opts.AnnounceList,
+		UrlList:      opts.WebSeeds,
+		Comment:      opts.Comment,
+		CreatedBy:    opts.CreatedBy,
+		CreationDate: time.Now().Unix(),
+	}
+	if len(opts.AnnounceList) != 0 && len(opts.AnnounceList[0]) != 0 {
+		m.Announce = opts.AnnounceList[0][0]
+	}
+	if len(opts.AnnounceList) == 1 && len(opts.AnnounceList[0]) == 1 {
+		// single tracker is set only as announce
+		m.AnnounceList = nil
+	}
+
+	m.Info.Name = filepath.Base(path)
+	m.Info.PieceLength = pieceLength
+	if opts.Private {
+		m.Info.Private = 1
+	}
+	if h.v1 {
+		m.Info.Pieces = string(h.sha1)
+		if isDir {
+			m.Info.Files = h.v1Files()
+		} else {
+			m.Info.Length = total
+		}
+	}
+	if h.v2 {
+		m.Info.MetaVersion = 2
+		m.Info.FileTree, m.PieceLayers = h.fileTree()
+	}
+
+	info, err := Marshal(m.Info)
+	if err != nil {
+		return nil, err
+	}
+	m.InfoDictRaw = info
+
+	return m, nil
+}
+
+// createFiles returns file at path or files in directory at path in
+// lexical order.
+func createFiles(path string) ([]createFile, bool, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, false, err
+	}
+	if !stat.IsDir() {
+		return []createFile{{osPath: path, path: []string{stat.Name()}, length: stat.Size()}}, false, nil
+	}
+
+	var files []createFile
+	err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(path, p)
+		if err != nil {
+			return err
+		}
+		file := createFile{
+			osPath: p,
+			path:   strings.Split(filepath.ToSlash(rel), "/"),
+			length: info.Size(),
+		}
+		if info.Mode()&0o111 != 0 {
+			file.attr = string(AttrExecutable)
+		}
+		files = append(files, file)
+		return nil
+	})
+	return files, true, err
+}
+
+// autoPieceLength selects power of two piece length which splits content
+// into about targetPieces pieces.
+func autoPieceLength(total int64) int64 {
+	length := int64(merkle.NextPowerOfTwo(int(total / targetPieces)))
+	return min(max(length, minPieceLength), maxPieceLength)
+}
+
+// hasher computes v1 and v2 hashes of torrent files.
+type hasher struct {
+	files       []createFile
+	pieceLength int
+	v1, v2      bool
+
+	sha1   []byte
+	layers [][][]byte
+}
+
+func (h *hasher) hash(workers int) error {
+	var total int64
+	pieces := 0
+	h.layers = make([][][]byte, len(h.files))
+	for i, f := range h.files {
+		total += f.length
+		num := int((f.length + int64(h.pieceLength) - 1) / int64(h.pieceLength))
+		if h.v2 && num > 0 {
+			h.layers[i] = make([][]byte, num)
+		}
+		pieces += num
+	}
+	if !h.v2 {
+		// v1 pieces span files
+		pieces = int((total + int64(h.pieceLength) - 1) / int64(h.pieceLength))
+	}
+	if h.v1 {
+		h.sha1 = make([]byte, pieces*sha1.Size)
+	}
+
+	// pieces are hashed into distinct slots of sha1 and layers, so
+	// workers need no locking
+	jobs := make(chan createPiece, workers*2)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range jobs {
+				h.hashPiece(p)
+			}
+		}()
+	}
+
+	err := h.readPieces(jobs)
+	close(jobs)
+	wg.Wait()
+	return err
+}
+
+func (h *hasher) hashPiece(p createPiece) {
+	if h.v1 {
+		hash := sha1.Sum(p.data)
+		copy(h.sha1[p.index*sha1.Size:], hash[:])
+	}
+	if h.v2 {
+		data := p.data[:p.dataLength]
+		if h.files[p.file].length <= int64(h.pieceLength) {
+			h.layers[p.file][p.filePiece] = merkle.FileRoot(data)
+		} else {
+			h.layers[p.file][p.filePiece] = merkle.PieceRoot(data, h.pieceLength/merkle.BlockSize)
+		}
+	}
+}
+
+// readPieces reads torrent pieces and sends them to jobs channel. Files
+// of v1 torrents are read as single stream, while v2 and hybrid torrent
+// files start at piece boundary, with zero padding in between.
+func (h *hasher) readPieces(jobs chan<- createPiece) error {
+	index := 0
+	piece := make([]byte, 0, h.pieceLength)
+	for i, f := range h.files {
+		file, err := os.Open(f.osPath)
+		if err != nil {
+			return err
+		}
+
+		filePiece := 0
+		var read int64
+		for read < f.length {
+			end := int(min(int64(h.pieceLength), int64(len(piece))+f.length-read))
+			n, err := io.ReadFull(file, piece[len(piece):end])
+			read += int64(n)
+			piece = piece[:len(piece)+n]
+			if err != nil {
+				_ = file.Close()
+				return fmt.Errorf("read %s: %w", f.osPath, err)
+			}
+
+			if len(piece) < h.pieceLength && !h.v2 {
+				// v1 piece continues in the next file
+				break
+			}
+
+			dataLength := len(piece)
+			if h.v1 && h.v2 && i != len(h.files)-1 {
+				// hybrid torrent pieces are padded with pad files
+				piece = piece[:h.pieceLength]
+			}
+			jobs <- createPiece{index: index, data: piece, file: i, filePiece: filePiece, dataLength: dataLength}
+			index++
+			filePiece++
+			piece = make([]byte, 0, h.pieceLength)
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+	}
+
+	if len(piece) > 0 {
+		jobs <- createPiece{index: index, data: piece}
+	}
+	return nil
+}
+
+// v1Files returns info dictionary files. Hybrid torrent files are
+// followed by pad files aligning next file to piece boundary (BEP 47).
+func (h *hasher) v1Files() []TorrentFile {
+	files := make([]TorrentFile, 0, len(h.files))
+	for i, f := range h.files {
+		files = append(files, TorrentFile{Path: f.path, Length: int(f.length), Attr: f.attr})
+
+		pad := (h.pieceLength - int(f.length%int64(h.pieceLength))) % h.pieceLength
+		if h.v2 && pad != 0 && i != len(h.files)-1 {
+			files = append(files, TorrentFile{
+				Path:   []string{".pad", strconv.Itoa(pad)},
+				Length: pad,
+				Attr:   string(AttrPad),
+			})
+		}
+	}
+	return files
+}
+
+// fileTree returns v2 file tree and piece layers of files bigger than
+// piece length.
+func (h *hasher) fileTree() (FileTree, map[string][]byte) {
+	tree := make(FileTree, 0, len(h.files))
+	layers := make(map[string][]byte)
+	for i, f := range h.files {
+		file := FileTreeFile{Path: f.path, Length: f.length, Attr: f.attr}
+		switch layer := h.layers[i]; {
+		case len(layer) == 1:
+			file.PiecesRoot = layer[0]
+		case len(layer) > 1:
+			file.PiecesRoot = merkle.LayerRoot(layer, h.pieceLength/merkle.BlockSize)
+			var hashes []byte
+			for _, hash := range layer {
+				hashes = append(hashes, hash...)
+			}
+			layers[string(file.PiecesRoot)] = hashes
+		}
+		tree = append(tree, file)
+	}
+	return tree, layers
+}
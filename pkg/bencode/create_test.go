@@ -0,0 +1,195 @@
+package bencode_test
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anivanovic/gotit/pkg/bencode"
+	"github.com/anivanovic/gotit/pkg/merkle"
+)
+
+const createPieceLength = 32 * 1024
+
+// createTestDir writes files a (40000 bytes), dir/b (100 bytes) and
+// dir/empty into temporary directory.
+func createTestDir(t *testing.T) (string, [][]byte) {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "content")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "dir"), 0o755))
+
+	data := [][]byte{
+		bytes.Repeat([]byte{1}, 40000),
+		bytes.Repeat([]byte{2}, 100),
+		{},
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a"), data[0], 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "dir", "b"), data[1], 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "dir", "empty"), data[2], 0o644))
+	return dir, data
+}
+
+func sha1Pieces(data []byte, pieceLength int) string {
+	var pieces []byte
+	for begin := 0; begin < len(data); begin += pieceLength {
+		hash := sha1.Sum(data[begin:min(begin+pieceLength, len(data))])
+		pieces = append(pieces, hash[:]...)
+	}
+	return string(pieces)
+}
+
+// roundTrip marshals and unmarshals metainfo as written to torrent file.
+func roundTrip(t *testing.T, m *bencode.Metainfo) *bencode.Metainfo {
+	t.Helper()
+	data, err := bencode.Marshal(m)
+	require.NoError(t, err)
+
+	got := &bencode.Metainfo{}
+	require.NoError(t, bencode.Unmarshal(data, got))
+	assert.Equal(t, m.Hash(), got.Hash())
+	return got
+}
+
+func TestCreate_SingleFile(t *testing.T) {
+	data := bytes.Repeat([]byte("gotit"), 20000)
+	path := filepath.Join(t.TempDir(), "file.txt")
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+
+	m, err := bencode.Create(path, bencode.CreateOptions{
+		PieceLength:  createPieceLength,
+		AnnounceList: [][]string{{"udp://a"}, {"udp://b", "udp://c"}},
+		WebSeeds:     []string{"http://seed/file.txt"},
+		Comment:      "comment",
+		CreatedBy:    "gotit",
+		Private:      true,
+		Workers:      3,
+	})
+	require.NoError(t, err)
+
+	got := roundTrip(t, m)
+	assert.Equal(t, "udp://a", got.Announce)
+	assert.Equal(t, [][]string{{"udp://a"}, {"udp://b", "udp://c"}}, got.AnnounceList)
+	assert.Equal(t, []string{"http://seed/file.txt"}, got.UrlList)
+	assert.Equal(t, "comment", got.Comment)
+	assert.Equal(t, "gotit", got.CreatedBy)
+	assert.True(t, got.IsPrivate())
+	assert.Contains(t, got.String(), "\t\tprivate: true\n")
+	assert.Equal(t, "file.txt", got.Info.Name)
+	assert.Equal(t, int64(len(data)), got.Info.Length)
+	assert.Equal(t, sha1Pieces(data, createPieceLength), got.Info.Pieces)
+	assert.False(t, got.IsV2())
+}
+
+func TestCreate_Directory(t *testing.T) {
+	dir, data := createTestDir(t)
+
+	m, err := bencode.Create(dir, bencode.CreateOptions{PieceLength: createPieceLength})
+	require.NoError(t, err)
+
+	got := roundTrip(t, m)
+	assert.Equal(t, "content", got.Info.Name)
+	assert.Equal(t, []bencode.TorrentFile{
+		{Path: []string{"a"}, Length: 40000},
+		{Path: []string{"dir", "b"}, Length: 100},
+		{Path: []string{"dir", "empty"}, Length: 0},
+	}, got.Info.Files)
+	// v1 pieces span files
+	assert.Equal(t, sha1Pieces(bytes.Join(data, nil), createPieceLength), got.Info.Pieces)
+}
+
+func TestCreate_Trackerless(t *testing.T) {
+	dir, _ := createTestDir(t)
+
+	m, err := bencode.Create(dir, bencode.CreateOptions{
+		PieceLength: createPieceLength,
+		WebSeeds:    []string{"http://seed/"},
+	})
+	require.NoError(t, err)
+
+	data, err := bencode.Marshal(m)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "8:announce")
+
+	got := &bencode.Metainfo{}
+	require.NoError(t, bencode.Unmarshal(data, got))
+	assert.Empty(t, got.Announce)
+	assert.Empty(t, got.AnnounceList)
+	assert.Equal(t, []string{"http://seed/"}, got.UrlList)
+	assert.Equal(t, m.Hash(), got.Hash())
+}
+
+func TestCreate_PrivateNoTrackers(t *testing.T) {
+	dir, _ := createTestDir(t)
+
+	_, err := bencode.Create(dir, bencode.CreateOptions{Private: true})
+	assert.ErrorIs(t, err, bencode.ErrPrivateNoTrackers)
+
+	_, err = bencode.Create(dir, bencode.CreateOptions{Private: true, AnnounceList: [][]string{{""}}})
+	assert.ErrorIs(t, err, bencode.ErrPrivateNoTrackers)
+}
+
+func TestCreate_V2(t *testing.T) {
+	dir, data := createTestDir(t)
+
+	m, err := bencode.Create(dir, bencode.CreateOptions{PieceLength: createPieceLength, Version: bencode.MetaV2})
+	require.NoError(t, err)
+
+	got := roundTrip(t, m)
+	assert.True(t, got.IsV2())
+	assert.False(t, got.IsHybrid())
+	assert.Empty(t, got.Info.Files)
+	assert.Equal(t, bencode.FileTree{
+		{Path: []string{"a"}, Length: 40000, PiecesRoot: merkle.FileRoot(data[0])},
+		{Path: []string{"dir", "b"}, Length: 100, PiecesRoot: merkle.FileRoot(data[1])},
+		{Path: []string{"dir", "empty"}, Length: 0},
+	}, got.Info.FileTree)
+	assert.Equal(t, map[string][]byte{
+		string(merkle.FileRoot(data[0])): bytes.Join(merkle.PieceLayer(data[0], createPieceLength/merkle.BlockSize), nil),
+	}, got.PieceLayers)
+}
+
+func TestCreate_Hybrid(t *testing.T) {
+	dir, data := createTestDir(t)
+
+	m, err := bencode.Create(dir, bencode.CreateOptions{PieceLength: createPieceLength, Version: bencode.MetaHybrid})
+	require.NoError(t, err)
+
+	got := roundTrip(t, m)
+	assert.True(t, got.IsHybrid())
+	assert.Equal(t, []bencode.TorrentFile{
+		{Path: []string{"a"}, Length: 40000},
+		{Path: []string{".pad", "25536"}, Length: 25536, Attr: "p"},
+		{Path: []string{"dir", "b"}, Length: 100},
+		{Path: []string{".pad", "32668"}, Length: 32668, Attr: "p"},
+		{Path: []string{"dir", "empty"}, Length: 0},
+	}, got.Info.Files)
+
+	padded := bytes.Join([][]byte{data[0], make([]byte, 25536), data[1], make([]byte, 32668)}, nil)
+	assert.Equal(t, sha1Pieces(padded, createPieceLength), got.Info.Pieces)
+	assert.Len(t, got.Info.FileTree, 3)
+}
+
+func TestCreate_PieceLength(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	require.NoError(t, f.Truncate(100*1024*1024))
+	require.NoError(t, f.Close())
+
+	m, err := bencode.Create(path, bencode.CreateOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(128*1024), m.Info.PieceLength)
+
+	_, err = bencode.Create(path, bencode.CreateOptions{PieceLength: 20000})
+	assert.ErrorContains(t, err, "invalid piece length 20000")
+}
+
+func TestCreate_Empty(t *testing.T) {
+	_, err := bencode.Create(t.TempDir(), bencode.CreateOptions{})
+	assert.ErrorIs(t, err, bencode.ErrEmptyTorrent)
+}
@@ -0,0 +1,242 @@
+package bencode
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrMaxStringLength = errors.New("bencode: maximum string length exceeded")
+	ErrMaxBytes        = errors.New("bencode: maximum input size exceeded")
+	ErrKeyNotString    = errors.New("bencode: dictionary key must be string")
+)
+
+// maxLengthDigits is the longest accepted string length prefix.
+const maxLengthDigits = 19
+
+// Decoder reads and decodes bencode values from an input stream one
+// at a time. Values are checked against configured limits while being
+// read, so that decoding of untrusted input never buffers more than
+// allowed.
+type Decoder struct {
+	r *bufio.Reader
+
+	maxDepth        int
+	maxStringLength int64
+	maxBytes        int64
+	strict          bool
+
+	read int64
+}
+
+// NewDecoder returns a new decoder reading from r. Decoder nesting is
+// limited to DefaultMaxDepth, while string length and total input size
+// are not limited.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{
+		r:        bufio.NewReader(r),
+		maxDepth: DefaultMaxDepth,
+	}
+}
+
+// SetMaxDepth limits nesting of lists and dictionaries. Zero disables
+// the limit.
+func (d *Decoder) SetMaxDepth(depth int) {
+	d.maxDepth = depth
+}
+
+// SetMaxStringLength limits length of single string element. Zero
+// disables the limit.
+func (d *Decoder) SetMaxStringLength(length int64) {
+	d.maxStringLength = length
+}
+
+// SetMaxBytes limits total number of bytes decoder reads from the input
+// stream. Zero disables the limit.
+func (d *Decoder) SetMaxBytes(n int64) {
+	d.maxBytes = n
+}
+
+// SetStrict makes decoder accept only values in canonical bencode form,
+// as ParseStrict does.
+func (d *Decoder) SetStrict(strict bool) {
+	d.strict = strict
+}
+
+// InputOffset returns number of bytes consumed by the decoded values.
+func (d *Decoder) InputOffset() int64 {
+	return d.read
+}
+
+// Decode reads next bencode value from the input and stores it in the
+// value pointed to by v. Target can be *Bencode, in which case parsed
+// bencode element is stored, or any target accepted by Unmarshal.
+// Decode returns io.EOF when there are no more values in the input.
+func (d *Decoder) Decode(v interface{}) error {
+	if _, err := d.r.Peek(1); err == io.EOF {
+		return io.EOF
+	}
+
+	buf := &bytes.Buffer{}
+	if err := d.readValue(buf, 0); err != nil {
+		var syntaxErr *SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return err
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return d.syntaxError(d.read, err)
+	}
+
+	sc := newScanner(buf.Bytes())
+	sc.maxDepth = d.maxDepth
+	sc.strict = d.strict
+	sc.offset = d.read - int64(buf.Len())
+	ben, err := sc.Parse()
+	if err != nil {
+		return err
+	}
+
+	if target, ok := v.(*Bencode); ok {
+		*target = ben
+		return nil
+	}
+	return processTarget(v, ben, buf.Bytes()[:sc.current], "")
+}
+
+// readValue copies single bencode value from the input to buf.
+func (d *Decoder) readValue(buf *bytes.Buffer, depth int) error {
+	c, err := d.readByte(buf)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case c == 'i':
+		return d.readInt(buf)
+	case c == 'l' || c == 'd':
+		depth++
+		if d.maxDepth > 0 && depth > d.maxDepth {
+			return ErrMaxDepth
+		}
+		return d.readContainer(buf, c == 'd', depth)
+	case c >= '0' && c <= '9':
+		return d.readString(buf, c)
+	default:
+		return d.syntaxError(d.read-1, fmt.Errorf("%w %q", ErrInvalidChar, c))
+	}
+}
+
+func (d *Decoder) readInt(buf *bytes.Buffer) error {
+	for {
+		c, err := d.readByte(buf)
+		if err != nil {
+			return err
+		}
+		if c == 'e' {
+			return nil
+		}
+		if (c < '0' || c > '9') && c != '-' {
+			return ErrElementEnd
+		}
+	}
+}
+
+func (d *Decoder) readContainer(buf *bytes.Buffer, dict bool, depth int) error {
+	for {
+		next, err := d.r.Peek(1)
+		if err != nil {
+			return err
+		}
+		if next[0] == 'e' {
+			_, err := d.readByte(buf)
+			return err
+		}
+
+		if dict {
+			c, err := d.readByte(buf)
+			if err != nil {
+				return err
+			}
+			if c < '0' || c > '9' {
+				return d.syntaxError(d.read-1, ErrKeyNotString)
+			}
+			if err := d.readString(buf, c); err != nil {
+				return err
+			}
+		}
+
+		if err := d.readValue(buf, depth); err != nil {
+			return err
+		}
+	}
+}
+
+// readString reads rest of the string element which length prefix
+// starts with digit first.
+func (d *Decoder) readString(buf *bytes.Buffer, first byte) error {
+	digits := []byte{first}
+	for {
+		c, err := d.readByte(buf)
+		if err != nil {
+			return err
+		}
+		if c == ':' {
+			break
+		}
+		if c < '0' || c > '9' {
+			return ErrColonMissing
+		}
+		if len(digits) == maxLengthDigits {
+			return ErrStringLength
+		}
+		digits = append(digits, c)
+	}
+
+	length, err := strconv.ParseInt(string(digits), 10, 64)
+	if err != nil {
+		return ErrStringLength
+	}
+	if d.maxStringLength > 0 && length > d.maxStringLength {
+		return ErrMaxStringLength
+	}
+	if d.maxBytes > 0 && d.read+length > d.maxBytes {
+		return ErrMaxBytes
+	}
+
+	n, err := io.CopyN(buf, d.r, length)
+	d.read += n
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// syntaxError returns SyntaxError wrapping err found at input offset.
+func (d *Decoder) syntaxError(offset int64, err error) error {
+	return &SyntaxError{
+		Offset: offset,
+		Msg:    strings.TrimPrefix(err.Error(), "bencode: "),
+		err:    err,
+	}
+}
+
+func (d *Decoder) readByte(buf *bytes.Buffer) (byte, error) {
+	if d.maxBytes > 0 && d.read >= d.maxBytes {
+		return 0, ErrMaxBytes
+	}
+
+	c, err := d.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	d.read++
+	buf.WriteByte(c)
+	return c, nil
+}
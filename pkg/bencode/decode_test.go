@@ -0,0 +1,148 @@
+package bencode_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anivanovic/gotit/pkg/bencode"
+)
+
+func TestDecoder_DecodeStream(t *testing.T) {
+	t.Parallel()
+
+	dec := bencode.NewDecoder(strings.NewReader("i1e4:spamli2ee"))
+
+	var ben bencode.Bencode
+	require.NoError(t, dec.Decode(&ben))
+	assert.Equal(t, bencode.IntElement(1), ben)
+	assert.Equal(t, int64(3), dec.InputOffset())
+
+	require.NoError(t, dec.Decode(&ben))
+	assert.Equal(t, bencode.StringElement("spam"), ben)
+
+	require.NoError(t, dec.Decode(&ben))
+	assert.Equal(t, "li2ee", ben.Encode())
+	assert.Equal(t, int64(14), dec.InputOffset())
+
+	assert.Equal(t, io.EOF, dec.Decode(&ben))
+}
+
+func TestDecoder_DecodeStruct(t *testing.T) {
+	t.Parallel()
+
+	data := readTorrentFile(t, "tears-of-steel.torrent")
+	metainfo := bencode.Metainfo{}
+	require.NoError(t, bencode.NewDecoder(bytes.NewReader(data)).Decode(&metainfo))
+
+	expected := bencode.Metainfo{}
+	require.NoError(t, bencode.Unmarshal(data, &expected))
+	assert.Equal(t, expected, metainfo)
+}
+
+func TestDecoder_Limits(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		data   string
+		setup  func(d *bencode.Decoder)
+		target error
+	}{
+		{
+			name:   "max depth",
+			data:   "lllleeee",
+			setup:  func(d *bencode.Decoder) { d.SetMaxDepth(3) },
+			target: bencode.ErrMaxDepth,
+		},
+		{
+			name:   "default max depth",
+			data:   strings.Repeat("l", bencode.DefaultMaxDepth+1),
+			setup:  func(d *bencode.Decoder) {},
+			target: bencode.ErrMaxDepth,
+		},
+		{
+			name:   "max string length",
+			data:   "d3:key10:0123456789e",
+			setup:  func(d *bencode.Decoder) { d.SetMaxStringLength(5) },
+			target: bencode.ErrMaxStringLength,
+		},
+		{
+			name:   "max bytes on string",
+			data:   "100000:abc",
+			setup:  func(d *bencode.Decoder) { d.SetMaxBytes(50) },
+			target: bencode.ErrMaxBytes,
+		},
+		{
+			name:   "max bytes on integer",
+			data:   "i12345678e",
+			setup:  func(d *bencode.Decoder) { d.SetMaxBytes(5) },
+			target: bencode.ErrMaxBytes,
+		},
+		{
+			name:   "truncated input",
+			data:   "l4:spa",
+			setup:  func(d *bencode.Decoder) {},
+			target: io.ErrUnexpectedEOF,
+		},
+		{
+			name:   "unterminated list",
+			data:   "li1e",
+			setup:  func(d *bencode.Decoder) {},
+			target: io.ErrUnexpectedEOF,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			dec := bencode.NewDecoder(strings.NewReader(tt.data))
+			tt.setup(dec)
+
+			var ben bencode.Bencode
+			err := dec.Decode(&ben)
+			assert.True(t, errors.Is(err, tt.target), "got error %v, expected %v", err, tt.target)
+			var syntaxErr *bencode.SyntaxError
+			assert.ErrorAs(t, err, &syntaxErr)
+		})
+	}
+}
+
+func TestDecoder_InvalidInput(t *testing.T) {
+	t.Parallel()
+
+	for _, data := range []string{"x", "di1ei2ee", "i1xe", "3x:abc"} {
+		var ben bencode.Bencode
+		err := bencode.NewDecoder(strings.NewReader(data)).Decode(&ben)
+		assert.Error(t, err, data)
+	}
+}
+
+func TestDecoder_LimitsDisabled(t *testing.T) {
+	t.Parallel()
+
+	data := strings.Repeat("l", 1000) + strings.Repeat("e", 1000)
+	dec := bencode.NewDecoder(strings.NewReader(data))
+	dec.SetMaxDepth(0)
+
+	var ben bencode.Bencode
+	assert.NoError(t, dec.Decode(&ben))
+}
+
+func TestDecoder_Strict(t *testing.T) {
+	t.Parallel()
+
+	dec := bencode.NewDecoder(strings.NewReader("i1ed1:bi1e1:ai2ee"))
+	dec.SetStrict(true)
+
+	var ben bencode.Bencode
+	require.NoError(t, dec.Decode(&ben))
+	err := dec.Decode(&ben)
+	assert.ErrorIs(t, err, bencode.ErrNonCanonical)
+	assert.ErrorContains(t, err, "at offset 10")
+}
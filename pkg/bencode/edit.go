@@ -0,0 +1,71 @@
+package bencode
+
+import (
+	"errors"
+)
+
+// MetainfoEdit holds new values of torrent fields outside of info
+// dictionary. Nil fields are left unchanged, while empty values remove
+// the field from torrent. Removing announce-list removes announce as
+// well, unless new announce is set.
+type MetainfoEdit struct {
+	Announce     *string
+	AnnounceList *[][]string
+	UrlList      *[]string
+	Comment      *string
+	CreatedBy    *string
+}
+
+// EditMetainfo applies edit to encoded torrent file. Info dictionary and
+// fields not in edit are copied byte for byte, so info-hash of the torrent
+// does not change.
+func EditMetainfo(data []byte, edit MetainfoEdit) ([]byte, error) {
+	m := Metainfo{}
+	if err := Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	ben, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	dict, ok := dictValue(ben)
+	if !ok {
+		return nil, errors.New("bencode: torrent is not a dictionary")
+	}
+
+	values := make(map[string]interface{}, dict.Len())
+	dict.Range(func(k string, v Bencode) bool {
+		values[k] = RawMessage(dict.rawValue(k))
+		return true
+	})
+	values["info"] = RawMessage(m.InfoDictRaw)
+
+	set := func(key string, value interface{}, empty bool) {
+		if empty {
+			delete(values, key)
+		} else {
+			values[key] = value
+		}
+	}
+	if edit.Announce != nil {
+		set("announce", *edit.Announce, *edit.Announce == "")
+	}
+	if edit.AnnounceList != nil {
+		empty := len(*edit.AnnounceList) == 0
+		set("announce-list", *edit.AnnounceList, empty)
+		if empty && edit.Announce == nil {
+			delete(values, "announce")
+		}
+	}
+	if edit.UrlList != nil {
+		set("url-list", *edit.UrlList, len(*edit.UrlList) == 0)
+	}
+	if edit.Comment != nil {
+		set("comment", *edit.Comment, *edit.Comment == "")
+	}
+	if edit.CreatedBy != nil {
+		set("created by", *edit.CreatedBy, *edit.CreatedBy == "")
+	}
+
+	return Marshal(values)
+}
@@ -0,0 +1,112 @@
+package bencode_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anivanovic/gotit/pkg/bencode"
+)
+
+func TestEditMetainfo(t *testing.T) {
+	data := readTorrentFile(t, "tears-of-steel.torrent")
+	before := &bencode.Metainfo{}
+	require.NoError(t, bencode.Unmarshal(data, before))
+
+	announce := "http://tracker.example.com/announce"
+	announceList := [][]string{{announce}, {"udp://backup.example.com:6969"}}
+	urlList := []string{"http://seed.example.com/"}
+	comment := "edited"
+	createdBy := ""
+	edited, err := bencode.EditMetainfo(data, bencode.MetainfoEdit{
+		Announce:     &announce,
+		AnnounceList: &announceList,
+		UrlList:      &urlList,
+		Comment:      &comment,
+		CreatedBy:    &createdBy,
+	})
+	require.NoError(t, err)
+
+	after := &bencode.Metainfo{}
+	require.NoError(t, bencode.Unmarshal(edited, after))
+	assert.Equal(t, before.InfoDictRaw, after.InfoDictRaw)
+	assert.Equal(t, before.Hash(), after.Hash())
+	assert.Equal(t, announce, after.Announce)
+	assert.Equal(t, announceList, after.AnnounceList)
+	assert.Equal(t, urlList, after.UrlList)
+	assert.Equal(t, comment, after.Comment)
+	assert.Empty(t, after.CreatedBy)
+	assert.Equal(t, before.CreationDate, after.CreationDate)
+}
+
+func TestEditMetainfo_KeepsUnknownFields(t *testing.T) {
+	// info keys are not sorted, so re-encoding info would change the hash
+	data := []byte("d8:announce3:old5:nodesl4:nodee4:infod4:name1:a6:lengthi1e12:piece lengthi16384e6:pieces20:aaaaaaaaaaaaaaaaaaaaee")
+
+	announce := "new"
+	edited, err := bencode.EditMetainfo(data, bencode.MetainfoEdit{Announce: &announce})
+	require.NoError(t, err)
+	assert.Equal(t, "d8:announce3:new4:infod4:name1:a6:lengthi1e12:piece lengthi16384e6:pieces20:aaaaaaaaaaaaaaaaaaaae5:nodesl4:nodeee", string(edited))
+}
+
+func TestEditMetainfo_RemoveAnnounce(t *testing.T) {
+	data := readTorrentFile(t, "tears-of-steel.torrent")
+
+	announce := ""
+	edited, err := bencode.EditMetainfo(data, bencode.MetainfoEdit{Announce: &announce})
+	require.NoError(t, err)
+	assert.NotContains(t, string(edited), "8:announce")
+
+	// trackerless torrent can be read and edited again
+	m := &bencode.Metainfo{}
+	require.NoError(t, bencode.Unmarshal(edited, m))
+	assert.Empty(t, m.Announce)
+	assert.NotEmpty(t, m.AnnounceList)
+
+	comment := "trackerless"
+	edited, err = bencode.EditMetainfo(edited, bencode.MetainfoEdit{Comment: &comment})
+	require.NoError(t, err)
+	require.NoError(t, bencode.Unmarshal(edited, m))
+	assert.Equal(t, comment, m.Comment)
+}
+
+func TestEditMetainfo_RemoveTrackers(t *testing.T) {
+	data := readTorrentFile(t, "tears-of-steel.torrent")
+	before := &bencode.Metainfo{}
+	require.NoError(t, bencode.Unmarshal(data, before))
+	require.NotEmpty(t, before.Announce)
+
+	edited, err := bencode.EditMetainfo(data, bencode.MetainfoEdit{AnnounceList: &[][]string{}})
+	require.NoError(t, err)
+	assert.NotContains(t, string(edited), "8:announce")
+	assert.NotContains(t, string(edited), "13:announce-list")
+
+	after := &bencode.Metainfo{}
+	require.NoError(t, bencode.Unmarshal(edited, after))
+	assert.Empty(t, after.Announce)
+	assert.Empty(t, after.AnnounceList)
+	assert.Equal(t, before.Hash(), after.Hash())
+
+	// announce set with trackers removal is kept
+	announce := "udp://tracker"
+	edited, err = bencode.EditMetainfo(data, bencode.MetainfoEdit{Announce: &announce, AnnounceList: &[][]string{}})
+	require.NoError(t, err)
+	require.NoError(t, bencode.Unmarshal(edited, after))
+	assert.Equal(t, announce, after.Announce)
+	assert.Empty(t, after.AnnounceList)
+}
+
+func TestEditMetainfo_KeepsNonCanonicalFields(t *testing.T) {
+	data := []byte("d8:announce3:old13:creation datei01e4:infod6:lengthi1e4:name1:a12:piece lengthi16384e6:pieces20:aaaaaaaaaaaaaaaaaaaae5:nodesl04:nodeee")
+
+	comment := "edited"
+	edited, err := bencode.EditMetainfo(data, bencode.MetainfoEdit{Comment: &comment})
+	require.NoError(t, err)
+	assert.Equal(t, "d8:announce3:old7:comment6:edited13:creation datei01e4:infod6:lengthi1e4:name1:a12:piece lengthi16384e6:pieces20:aaaaaaaaaaaaaaaaaaaae5:nodesl04:nodeee", string(edited))
+}
+
+func TestEditMetainfo_NotTorrent(t *testing.T) {
+	_, err := bencode.EditMetainfo([]byte("le"), bencode.MetainfoEdit{})
+	assert.Error(t, err)
+}
@@ -1,6 +1,8 @@
 package bencode
 
 import (
+	"math/big"
+	"sort"
 	"strconv"
 )
 
@@ -11,18 +13,35 @@ type (
 		Raw() []byte
 	}
 
-	IntElement    int
+	IntElement int64
+	// BigIntElement holds integer values which do not fit into int64.
+	BigIntElement struct {
+		value *big.Int
+	}
 	StringElement string
 	ListElement   struct {
 		Value []Bencode
 		raw   []byte
+		// spans locate elements in raw
+		spans []span
 	}
 	DictElement struct {
 		value map[string]Bencode
-		raw   []byte
+		// keys hold dictionary keys in insertion order. When not set
+		// keys are iterated in sorted order.
+		keys []string
+		raw  []byte
+		// spans locate values in raw
+		spans map[string]span
 	}
 )
 
+// span is start and end offset of parsed element in raw bytes of its
+// container.
+type span struct {
+	start, end int
+}
+
 func (bencode StringElement) String() string {
 	return string(bencode)
 }
@@ -36,7 +55,7 @@ func (bencode StringElement) Raw() []byte {
 }
 
 func (bencode IntElement) String() string {
-	return strconv.Itoa(int(bencode))
+	return strconv.FormatInt(int64(bencode), 10)
 }
 
 func (bencode IntElement) Encode() string {
@@ -47,8 +66,25 @@ func (bencode IntElement) Raw() []byte {
 	return []byte(bencode.String())
 }
 
+func (bencode BigIntElement) String() string {
+	return bencode.value.String()
+}
+
+func (bencode BigIntElement) Encode() string {
+	return "i" + bencode.String() + "e"
+}
+
+func (bencode BigIntElement) Raw() []byte {
+	return []byte(bencode.String())
+}
+
+// Int returns copy of the integer value.
+func (bencode BigIntElement) Int() *big.Int {
+	return new(big.Int).Set(bencode.value)
+}
+
 func (bencode ListElement) String() string {
-	return prettyPrint(bencode, "")
+	return prettyPrint(bencode, "", BinaryHex)
 }
 
 func (bencode ListElement) Encode() string {
@@ -66,19 +102,30 @@ func (bencode ListElement) Raw() []byte {
 	return bencode.raw
 }
 
+// rawValue returns input bytes of element i, or its canonical encoding
+// when list was not parsed.
+func (bencode ListElement) rawValue(i int) []byte {
+	if i < len(bencode.spans) {
+		sp := bencode.spans[i]
+		return bencode.raw[sp.start:sp.end]
+	}
+	return encoded(bencode.Value[i])
+}
+
 func (bencode DictElement) String() string {
-	return prettyPrint(bencode, "")
+	return prettyPrint(bencode, "", BinaryHex)
 }
 
 func (bencode DictElement) Encode() string {
 	encoded := "d"
-	for k, v := range bencode.value {
+	bencode.Range(func(k string, v Bencode) bool {
 		if v == nil {
-			continue
+			return true
 		}
 		encoded += StringElement(k).Encode()
 		encoded += v.Encode()
-	}
+		return true
+	})
 
 	return encoded + "e"
 }
@@ -87,22 +134,77 @@ func (bencode DictElement) Value(key string) Bencode {
 	return bencode.value[key]
 }
 
+// Keys returns dictionary keys in the order they were added to the
+// dictionary, which for parsed dictionaries is the order of the input.
+func (bencode DictElement) Keys() []string {
+	if bencode.keys != nil {
+		keys := make([]string, len(bencode.keys))
+		copy(keys, bencode.keys)
+		return keys
+	}
+
+	keys := make([]string, 0, len(bencode.value))
+	for k := range bencode.value {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Range calls fn for each dictionary entry in key order. Iteration
+// stops when fn returns false.
+func (bencode DictElement) Range(fn func(k string, v Bencode) bool) {
+	for _, k := range bencode.Keys() {
+		if !fn(k, bencode.value[k]) {
+			return
+		}
+	}
+}
+
+func (bencode DictElement) Len() int {
+	return len(bencode.value)
+}
+
 func (bencode DictElement) Raw() []byte {
 	return bencode.raw
 }
 
-func prettyPrint(bencode Bencode, tabs string) string {
+// rawValue returns input bytes of the value for key, or its canonical
+// encoding when dictionary was not parsed.
+func (bencode DictElement) rawValue(key string) []byte {
+	if sp, ok := bencode.spans[key]; ok {
+		return bencode.raw[sp.start:sp.end]
+	}
+	if v := bencode.value[key]; v != nil {
+		return encoded(v)
+	}
+	return nil
+}
+
+// Pretty returns human readable representation of bencode element.
+// Strings which are not printable text are encoded with enc.
+func Pretty(bencode Bencode, enc BinaryEncoding) string {
+	return prettyPrint(bencode, "", enc)
+}
+
+func prettyPrint(bencode Bencode, tabs string, enc BinaryEncoding) string {
 	switch value := bencode.(type) {
+	case *DictElement:
+		return prettyPrint(*value, tabs, enc)
+	case *ListElement:
+		return prettyPrint(*value, tabs, enc)
+	case StringElement:
+		return TextString(string(value), enc)
 	case DictElement:
 		tabs = addTab(tabs)
 		data := "{" + newLine(tabs)
 
-		for k, v := range value.value {
-			if v == nil {
-				continue
+		value.Range(func(k string, v Bencode) bool {
+			if v != nil {
+				data += TextString(k, enc) + ": " + prettyPrint(v, tabs, enc) + "," + newLine(tabs)
 			}
-			data += k + ": " + prettyPrint(v, tabs) + "," + newLine(tabs)
-		}
+			return true
+		})
 
 		if len(data) == 2+len(tabs) {
 			return "{}"
@@ -116,7 +218,7 @@ func prettyPrint(bencode Bencode, tabs string) string {
 			if el == nil {
 				continue
 			}
-			data += prettyPrint(el, tabs) + "," + newLine(tabs)
+			data += prettyPrint(el, tabs, enc) + "," + newLine(tabs)
 		}
 
 		if len(data) == 2+len(tabs) {
@@ -140,6 +242,7 @@ func newLine(tabs string) string {
 type (
 	dictBencodeBuilder struct {
 		dict map[string]Bencode
+		keys []string
 	}
 	Builder interface {
 		Add(key string, value Bencode) Builder
@@ -147,17 +250,22 @@ type (
 	}
 )
 
+// Add sets dictionary value for the key. Keys are kept in the order they
+// were first added.
 func (d *dictBencodeBuilder) Add(key string, value Bencode) Builder {
+	if _, ok := d.dict[key]; !ok {
+		d.keys = append(d.keys, key)
+	}
 	d.dict[key] = value
 	return d
 }
 
 func (d *dictBencodeBuilder) Generate() Bencode {
-	return DictElement{value: d.dict}
+	return DictElement{value: d.dict, keys: d.keys}
 }
 
 func NewDictBuilder() Builder {
-	return &dictBencodeBuilder{dict: map[string]Bencode{}}
+	return &dictBencodeBuilder{dict: map[string]Bencode{}, keys: []string{}}
 }
 
 func String(value string) Bencode {
@@ -168,6 +276,13 @@ func Integer(value int) Bencode {
 	return IntElement(value)
 }
 
+func BigInteger(value *big.Int) Bencode {
+	if value.IsInt64() {
+		return IntElement(value.Int64())
+	}
+	return BigIntElement{value: new(big.Int).Set(value)}
+}
+
 func List(values ...Bencode) Bencode {
 	return ListElement{Value: values}
 }
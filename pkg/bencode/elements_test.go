@@ -2,6 +2,8 @@ package bencode
 
 import (
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestBencode_String(t *testing.T) {
@@ -131,3 +133,53 @@ func TestPrettyPrint(t *testing.T) {
 		t.Errorf("Bencode does not print pretty: got = %v, expected = %v", got, expected)
 	}
 }
+
+func TestDictElement_KeysAndRange(t *testing.T) {
+	t.Parallel()
+
+	dict := NewDictBuilder().
+		Add("z", IntElement(1)).
+		Add("a", IntElement(2)).
+		Add("m", IntElement(3)).
+		Add("z", IntElement(4)).
+		Generate().(DictElement)
+
+	assert.Equal(t, []string{"z", "a", "m"}, dict.Keys())
+	assert.Equal(t, 3, dict.Len())
+	assert.Equal(t, "d1:zi4e1:ai2e1:mi3ee", dict.Encode())
+
+	var visited []string
+	dict.Range(func(k string, v Bencode) bool {
+		visited = append(visited, k+"="+v.String())
+		return k != "a"
+	})
+	assert.Equal(t, []string{"z=4", "a=2"}, visited)
+}
+
+func TestDictElement_KeysSortedWithoutOrder(t *testing.T) {
+	t.Parallel()
+
+	dict := DictElement{value: map[string]Bencode{
+		"b": IntElement(1),
+		"a": IntElement(2),
+	}}
+	assert.Equal(t, []string{"a", "b"}, dict.Keys())
+}
+
+func TestPrettyPrint_ParsedKeepsOrder(t *testing.T) {
+	t.Parallel()
+
+	ben, err := Parse([]byte("d1:bd1:yi1e1:xi2ee1:ali1eee"))
+	assert.NoError(t, err)
+
+	expected := `{
+	b: {
+		y: 1,
+		x: 2,
+	},
+	a: [
+		1,
+	],
+}`
+	assert.Equal(t, expected, ben.String())
+}
@@ -0,0 +1,267 @@
+package bencode
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// Marshal returns bencode encoding of v.
+//
+// Struct fields are encoded using the same `ben` tags Unmarshal reads.
+// Dictionary keys are written in sorted order and optional fields
+// holding zero value are left out.
+func Marshal(v interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := NewEncoder(buf).Encode(v); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Encoder writes bencode values to an output stream.
+type Encoder struct {
+	w io.Writer
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes bencode encoding of v to the stream. Nothing is written
+// if v can not be encoded.
+func (e *Encoder) Encode(v interface{}) error {
+	buf := &bytes.Buffer{}
+	if err := encodeValue(buf, reflect.ValueOf(v)); err != nil {
+		return err
+	}
+
+	_, err := e.w.Write(buf.Bytes())
+	return err
+}
+
+type encodeField struct {
+	key   string
+	value reflect.Value
+	opts  tagOptions
+}
+
+var bencodeType = reflect.TypeOf((*Bencode)(nil)).Elem()
+
+func encodeValue(buf *bytes.Buffer, v reflect.Value) error {
+	if !v.IsValid() {
+		return fmt.Errorf("bencode: can not encode nil value")
+	}
+
+	if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+		return fmt.Errorf("bencode: can not encode nil value")
+	}
+
+	if m, ok := marshaler(v); ok {
+		data, err := callMarshaler(m)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+		return nil
+	}
+
+	if v.Type().Implements(bencodeType) && v.CanInterface() {
+		buf.WriteString(v.Interface().(Bencode).Encode())
+		return nil
+	}
+
+	if v.Type() == bigIntType {
+		n := v.Interface().(big.Int)
+		buf.WriteByte('i')
+		buf.WriteString(n.String())
+		buf.WriteByte('e')
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return encodeValue(buf, v.Elem())
+	case reflect.String:
+		writeString(buf, v.String())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		buf.WriteByte('i')
+		buf.WriteString(strconv.FormatInt(v.Int(), 10))
+		buf.WriteByte('e')
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		buf.WriteByte('i')
+		buf.WriteString(strconv.FormatUint(v.Uint(), 10))
+		buf.WriteByte('e')
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			writeString(buf, string(b))
+			return nil
+		}
+
+		buf.WriteByte('l')
+		for i := 0; i < v.Len(); i++ {
+			el := v.Index(i)
+			if isNil(el) {
+				continue
+			}
+			if err := encodeValue(buf, el); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("bencode: unsupported map key type %s", v.Type().Key())
+		}
+
+		keys := make([]string, 0, v.Len())
+		for _, k := range v.MapKeys() {
+			keys = append(keys, k.String())
+		}
+		sort.Strings(keys)
+
+		buf.WriteByte('d')
+		for _, k := range keys {
+			el := v.MapIndex(reflect.ValueOf(k).Convert(v.Type().Key()))
+			if isNil(el) {
+				continue
+			}
+			writeString(buf, k)
+			if err := encodeValue(buf, el); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	case reflect.Struct:
+		return encodeStruct(buf, v)
+	default:
+		return fmt.Errorf("unsupported bencode type %s", v.Type())
+	}
+
+	return nil
+}
+
+func encodeStruct(buf *bytes.Buffer, v reflect.Value) error {
+	fields := structFields(v, make([]encodeField, 0, v.NumField()))
+	sort.SliceStable(fields, func(i, j int) bool {
+		return fields[i].key < fields[j].key
+	})
+
+	buf.WriteByte('d')
+	for i := 0; i < len(fields); {
+		j := i + 1
+		for j < len(fields) && fields[j].key == fields[i].key {
+			j++
+		}
+
+		field, ok := pickField(fields[i:j])
+		i = j
+		if !ok {
+			continue
+		}
+
+		if field.opts.raw {
+			writeString(buf, field.key)
+			buf.Write(field.value.Bytes())
+			continue
+		}
+
+		if isNil(field.value) {
+			return fmt.Errorf("bencode: required field %q is nil", field.key)
+		}
+		writeString(buf, field.key)
+		if err := encodeValue(buf, field.value); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('e')
+
+	return nil
+}
+
+// structFields appends tagged fields of struct v to fields. Fields of
+// embedded structs without `ben` tag are appended after fields of the
+// outer struct, so that outer fields take precedence on the same key.
+func structFields(v reflect.Value, fields []encodeField) []encodeField {
+	embedded := make([]reflect.Value, 0)
+	for i := 0; i < v.NumField(); i++ {
+		ftype := v.Type().Field(i)
+		key, opts := parseTag(ftype.Tag.Get("ben"))
+		if ftype.Anonymous && key == "" {
+			f := v.Field(i)
+			if f.Kind() == reflect.Ptr && !f.IsNil() {
+				f = f.Elem()
+			}
+			if f.Kind() == reflect.Struct {
+				embedded = append(embedded, f)
+			}
+			continue
+		}
+		if !ftype.IsExported() || key == "" {
+			continue
+		}
+		// only byte slices can hold raw bencode
+		if opts.raw && (ftype.Type.Kind() != reflect.Slice || ftype.Type.Elem().Kind() != reflect.Uint8) {
+			opts.raw = false
+		}
+		fields = append(fields, encodeField{key: key, value: v.Field(i), opts: opts})
+	}
+
+	for _, e := range embedded {
+		fields = structFields(e, fields)
+	}
+	return fields
+}
+
+// pickField chooses which of the fields sharing the same dictionary key
+// is encoded. Non-empty raw field wins, otherwise first field which
+// is not left out.
+func pickField(fields []encodeField) (encodeField, bool) {
+	for _, f := range fields {
+		if f.opts.raw && f.value.Len() != 0 {
+			return f, true
+		}
+	}
+
+	for _, f := range fields {
+		if f.opts.raw || (f.opts.optional && isEmptyValue(f.value)) {
+			continue
+		}
+		return f, true
+	}
+
+	return encodeField{}, false
+}
+
+func isNil(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	default:
+		return false
+	}
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	default:
+		return v.IsZero()
+	}
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	buf.WriteString(strconv.Itoa(len(s)))
+	buf.WriteByte(':')
+	buf.WriteString(s)
+}
@@ -0,0 +1,222 @@
+package bencode_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anivanovic/gotit/pkg/bencode"
+)
+
+func TestMarshal(t *testing.T) {
+	t.Parallel()
+
+	type inner struct {
+		Value int `ben:"value"`
+	}
+	tests := []struct {
+		name    string
+		value   interface{}
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "string",
+			value: "hello",
+			want:  "5:hello",
+		},
+		{
+			name:  "empty string",
+			value: "",
+			want:  "0:",
+		},
+		{
+			name:  "negative integer",
+			value: int8(-12),
+			want:  "i-12e",
+		},
+		{
+			name:  "unsigned integer",
+			value: uint64(18446744073709551615),
+			want:  "i18446744073709551615e",
+		},
+		{
+			name:  "byte slice",
+			value: []byte{0, 1, 2},
+			want:  "3:\x00\x01\x02",
+		},
+		{
+			name:  "byte array",
+			value: [2]byte{'a', 'b'},
+			want:  "2:ab",
+		},
+		{
+			name:  "list",
+			value: []interface{}{"a", 1, []string{"b"}},
+			want:  "l1:ai1el1:bee",
+		},
+		{
+			name:  "map keys sorted",
+			value: map[string]int{"b": 2, "a": 1, "c": 3},
+			want:  "d1:ai1e1:bi2e1:ci3ee",
+		},
+		{
+			name:  "pointer",
+			value: &inner{Value: 3},
+			want:  "d5:valuei3ee",
+		},
+		{
+			name:  "bencode element",
+			value: bencode.List(bencode.String("a"), bencode.Integer(1)),
+			want:  "l1:ai1ee",
+		},
+		{
+			name:    "nil",
+			value:   nil,
+			wantErr: true,
+		},
+		{
+			name:    "unsupported type",
+			value:   1.5,
+			wantErr: true,
+		},
+		{
+			name:    "unsupported map key",
+			value:   map[int]string{1: "a"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := bencode.Marshal(tt.value)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestMarshal_Struct(t *testing.T) {
+	t.Parallel()
+
+	type testStruct struct {
+		Name       string            `ben:"name"`
+		Length     int64             `ben:"length"`
+		Optional   string            `ben:"optional,optional"`
+		OptionalOk int               `ben:"a optional,optional"`
+		List       []string          `ben:"list,optional"`
+		Dict       map[string]string `ben:"dict,optional"`
+		Ptr        *int              `ben:"ptr,optional"`
+		NoTag      string
+		unexported string `ben:"unexported"`
+	}
+
+	got, err := bencode.Marshal(testStruct{
+		Name:       "name",
+		Length:     0,
+		OptionalOk: 5,
+		NoTag:      "skipped",
+		unexported: "skipped",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "d10:a optionali5e6:lengthi0e4:name4:namee", string(got))
+}
+
+func TestMarshal_Embedded(t *testing.T) {
+	t.Parallel()
+
+	type Base struct {
+		Name string `ben:"name"`
+		Type int    `ben:"type"`
+	}
+	type testStruct struct {
+		Base
+		Type  string `ben:"type"`
+		Count int    `ben:"count"`
+	}
+
+	got, err := bencode.Marshal(testStruct{Base: Base{Name: "a", Type: 1}, Type: "outer", Count: 2})
+	require.NoError(t, err)
+	assert.Equal(t, "d5:counti2e4:name1:a4:type5:outere", string(got))
+
+	decoded := testStruct{}
+	require.NoError(t, bencode.Unmarshal(got, &decoded))
+	assert.Equal(t, "a", decoded.Name)
+	assert.Equal(t, "outer", decoded.Type)
+	assert.Equal(t, 0, decoded.Base.Type)
+	assert.Equal(t, 2, decoded.Count)
+}
+
+func TestMarshal_RequiredNilPointer(t *testing.T) {
+	t.Parallel()
+
+	type testStruct struct {
+		Ptr *int `ben:"ptr"`
+	}
+	_, err := bencode.Marshal(testStruct{})
+	assert.Error(t, err)
+}
+
+func TestMarshal_RawField(t *testing.T) {
+	t.Parallel()
+
+	type testStruct struct {
+		Info struct {
+			Name string `ben:"name"`
+		} `ben:"info"`
+		InfoRaw []byte `ben:"info,raw"`
+	}
+
+	value := testStruct{}
+	value.Info.Name = "encoded"
+	got, err := bencode.Marshal(value)
+	require.NoError(t, err)
+	assert.Equal(t, "d4:infod4:name7:encodedee", string(got))
+
+	value.InfoRaw = []byte("d4:name3:rawe")
+	got, err = bencode.Marshal(value)
+	require.NoError(t, err)
+	assert.Equal(t, "d4:infod4:name3:rawee", string(got))
+}
+
+func TestMarshal_MetainfoRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	data := readTorrentFile(t, "tears-of-steel.torrent")
+	metainfo := bencode.Metainfo{}
+	require.NoError(t, bencode.Unmarshal(data, &metainfo))
+
+	encoded, err := bencode.Marshal(metainfo)
+	require.NoError(t, err)
+	assert.Equal(t, data, encoded)
+
+	decoded := bencode.Metainfo{}
+	require.NoError(t, bencode.Unmarshal(encoded, &decoded))
+	assert.Equal(t, metainfo.Hash(), decoded.Hash())
+
+	// without raw info dictionary Info struct is encoded
+	metainfo.InfoDictRaw = nil
+	encoded, err = bencode.Marshal(metainfo)
+	require.NoError(t, err)
+	decoded = bencode.Metainfo{}
+	require.NoError(t, bencode.Unmarshal(encoded, &decoded))
+	assert.Equal(t, metainfo.Info, decoded.Info)
+}
+
+func TestEncoder_Encode(t *testing.T) {
+	t.Parallel()
+
+	buf := &bytes.Buffer{}
+	enc := bencode.NewEncoder(buf)
+	require.NoError(t, enc.Encode(1))
+	require.NoError(t, enc.Encode("a"))
+	assert.Error(t, enc.Encode(1.5))
+	assert.Equal(t, "i1e1:a", buf.String())
+}
@@ -0,0 +1,113 @@
+package bencode
+
+import (
+	"fmt"
+	"strings"
+
+	"code.cloudfoundry.org/bytefmt"
+)
+
+// FileTreeFile is file entry of v2 torrent file tree.
+type FileTreeFile struct {
+	Path   []string
+	Length int64
+	// PiecesRoot is SHA-256 merkle root of the file data. It is empty
+	// for empty files.
+	PiecesRoot  []byte
+	Attr        string
+	SymlinkPath []string
+}
+
+func (f FileTreeFile) String() string {
+	return fmt.Sprintf("[path: %s, size: %s]", f.FilePath(), bytefmt.ByteSize(uint64(f.Length)))
+}
+
+func (f FileTreeFile) FilePath() string {
+	return strings.Join(f.Path, "/")
+}
+
+// FileTree holds files of v2 torrent info dictionary (BEP 52), in the
+// order of the file tree dictionary. In bencode each path element is
+// a dictionary key, while file attributes are stored under empty key.
+type FileTree []FileTreeFile
+
+type fileTreeEntry struct {
+	Length      int64    `ben:"length"`
+	PiecesRoot  []byte   `ben:"pieces root,optional"`
+	Attr        string   `ben:"attr,optional"`
+	SymlinkPath []string `ben:"symlink path,optional"`
+}
+
+func (t *FileTree) UnmarshalBencode(data []byte) error {
+	ben, err := Parse(data)
+	if err != nil {
+		return err
+	}
+
+	files := FileTree{}
+	if err := files.walk(ben, nil); err != nil {
+		return err
+	}
+	*t = files
+	return nil
+}
+
+func (t *FileTree) walk(node Bencode, path []string) error {
+	dict, ok := dictValue(node)
+	if !ok {
+		return &UnmarshalTypeError{
+			Path:     "file tree." + strings.Join(path, "."),
+			Expected: "dict",
+			Got:      bencodeTypeName(node),
+		}
+	}
+
+	var err error
+	dict.Range(func(k string, v Bencode) bool {
+		if k != "" {
+			err = t.walk(v, append(path[:len(path):len(path)], k))
+			return err == nil
+		}
+
+		entry := fileTreeEntry{}
+		if err = processTarget(&entry, v, dict.rawValue(k), "file tree."+strings.Join(path, ".")); err != nil {
+			return false
+		}
+		*t = append(*t, FileTreeFile{
+			Path:        path,
+			Length:      entry.Length,
+			PiecesRoot:  entry.PiecesRoot,
+			Attr:        entry.Attr,
+			SymlinkPath: entry.SymlinkPath,
+		})
+		return true
+	})
+	return err
+}
+
+func (t FileTree) MarshalBencode() ([]byte, error) {
+	root := map[string]interface{}{}
+	for _, f := range t {
+		if len(f.Path) == 0 {
+			return nil, fmt.Errorf("bencode: file tree file with empty path")
+		}
+
+		node := root
+		for _, name := range f.Path {
+			child, ok := node[name].(map[string]interface{})
+			if !ok {
+				child = map[string]interface{}{}
+				node[name] = child
+			}
+			node = child
+		}
+		node[""] = fileTreeEntry{
+			Length:      f.Length,
+			PiecesRoot:  f.PiecesRoot,
+			Attr:        f.Attr,
+			SymlinkPath: f.SymlinkPath,
+		}
+	}
+
+	return Marshal(root)
+}
@@ -0,0 +1,56 @@
+package bencode_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anivanovic/gotit/pkg/bencode"
+)
+
+func TestFileTree_RoundTrip(t *testing.T) {
+	root := bytes.Repeat([]byte{0xAA}, 32)
+	tree := bencode.FileTree{
+		{Path: []string{"a.txt"}, Length: 10, PiecesRoot: root},
+		{Path: []string{"dir", "b.txt"}, Length: 20, PiecesRoot: root},
+		{Path: []string{"dir", "empty"}, Length: 0},
+	}
+
+	data, err := bencode.Marshal(tree)
+	require.NoError(t, err)
+	assert.Equal(t,
+		"d5:a.txtd0:d6:lengthi10e11:pieces root32:"+string(root)+"ee"+
+			"3:dird5:b.txtd0:d6:lengthi20e11:pieces root32:"+string(root)+"ee"+
+			"5:emptyd0:d6:lengthi0eeeee",
+		string(data))
+
+	got := bencode.FileTree{}
+	require.NoError(t, bencode.Unmarshal(data, &got))
+	assert.Equal(t, tree, got)
+}
+
+func TestFileTree_Metainfo(t *testing.T) {
+	root := bytes.Repeat([]byte{0xBB}, 32)
+	layer := bytes.Repeat([]byte{0xCC}, 64)
+	data := "d8:announce9:udp://a:14:infod9:file treed4:filed0:d6:lengthi40000e11:pieces root32:" + string(root) + "eee" +
+		"12:meta versioni2e4:name4:file12:piece lengthi32768ee" +
+		"12:piece layersd32:" + string(root) + "64:" + string(layer) + "ee"
+
+	m := bencode.Metainfo{}
+	require.NoError(t, bencode.Unmarshal([]byte(data), &m))
+	assert.True(t, m.IsV2())
+	assert.False(t, m.IsHybrid())
+	assert.Equal(t, bencode.FileTree{{Path: []string{"file"}, Length: 40000, PiecesRoot: root}}, m.Info.FileTree)
+	assert.Equal(t, layer, m.PieceLayers[string(root)])
+	assert.Len(t, m.HashV2(), 32)
+}
+
+func TestFileTree_Invalid(t *testing.T) {
+	got := bencode.FileTree{}
+	err := bencode.Unmarshal([]byte("d3:dirli1eee"), &got)
+	var typeErr *bencode.UnmarshalTypeError
+	require.ErrorAs(t, err, &typeErr)
+	assert.Equal(t, "file tree.dir", typeErr.Path)
+}
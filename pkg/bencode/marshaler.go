@@ -0,0 +1,109 @@
+package bencode
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// Unmarshaler is implemented by types which decode bencode representation
+// of themselves. UnmarshalBencode receives single encoded bencode value
+// and must copy the data if it is retained after returning.
+type Unmarshaler interface {
+	UnmarshalBencode([]byte) error
+}
+
+// Marshaler is implemented by types which encode themselves into valid
+// bencode value.
+type Marshaler interface {
+	MarshalBencode() ([]byte, error)
+}
+
+// RawMessage is encoded bencode value. It can be used to delay decoding
+// of part of the bencode or to precompute encoding. Decoded RawMessage
+// holds exact input bytes of the value, even when they are not in
+// canonical form, so hash of the value is kept.
+type RawMessage []byte
+
+var (
+	unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	marshalerType   = reflect.TypeOf((*Marshaler)(nil)).Elem()
+)
+
+func (m RawMessage) MarshalBencode() ([]byte, error) {
+	if len(m) == 0 {
+		return nil, errors.New("bencode: empty RawMessage")
+	}
+	return m, nil
+}
+
+func (m *RawMessage) UnmarshalBencode(data []byte) error {
+	if m == nil {
+		return errors.New("bencode: UnmarshalBencode on nil RawMessage")
+	}
+	*m = append((*m)[:0], data...)
+	return nil
+}
+
+// encoded returns bencode encoding of the element. Lists and dictionaries
+// produced by the parser return exact source bytes, while scalar values
+// are returned in their canonical form. Input bytes of scalar values are
+// returned by rawValue of their container.
+func encoded(b Bencode) []byte {
+	switch v := b.(type) {
+	case *DictElement:
+		if v.raw != nil {
+			return v.raw
+		}
+	case *ListElement:
+		if v.raw != nil {
+			return v.raw
+		}
+	}
+	return []byte(b.Encode())
+}
+
+// callMarshaler encodes value using its MarshalBencode method, checking
+// that the result is a single valid bencode value.
+func callMarshaler(m Marshaler) ([]byte, error) {
+	data, err := m.MarshalBencode()
+	if err != nil {
+		return nil, err
+	}
+
+	sc := newScanner(data)
+	if _, err := sc.Parse(); err != nil {
+		return nil, fmt.Errorf("bencode: invalid MarshalBencode output of %T: %w", m, err)
+	}
+	if !sc.IsFinished() {
+		return nil, fmt.Errorf("bencode: invalid MarshalBencode output of %T: trailing data", m)
+	}
+	return data, nil
+}
+
+// unmarshaler returns Unmarshaler implemented by the value or a pointer
+// to it.
+func unmarshaler(v reflect.Value) (Unmarshaler, bool) {
+	if v.Type().Implements(unmarshalerType) && v.CanInterface() {
+		if v.Kind() == reflect.Ptr && v.IsNil() {
+			return nil, false
+		}
+		return v.Interface().(Unmarshaler), true
+	}
+	if v.CanAddr() && v.Addr().Type().Implements(unmarshalerType) && v.Addr().CanInterface() {
+		return v.Addr().Interface().(Unmarshaler), true
+	}
+	return nil, false
+}
+
+// marshaler returns Marshaler implemented by the value or, for addressable
+// values, a pointer to it.
+func marshaler(v reflect.Value) (Marshaler, bool) {
+	if v.Type().Implements(marshalerType) && v.CanInterface() {
+		return v.Interface().(Marshaler), true
+	}
+	if v.CanAddr() && v.Addr().Type().Implements(marshalerType) && v.Addr().CanInterface() {
+		return v.Addr().Interface().(Marshaler), true
+	}
+	return nil, false
+}
@@ -0,0 +1,175 @@
+package bencode_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anivanovic/gotit/pkg/bencode"
+)
+
+// compactPeers decodes itself from compact peer string.
+type compactPeers []netip.AddrPort
+
+func (c *compactPeers) UnmarshalBencode(data []byte) error {
+	var raw []byte
+	if err := bencode.Unmarshal(data, (*bytesTarget)(&raw)); err != nil {
+		return err
+	}
+	if len(raw)%6 != 0 {
+		return errors.New("invalid compact peers length")
+	}
+	for i := 0; i < len(raw); i += 6 {
+		addr := netip.AddrFrom4([4]byte{raw[i], raw[i+1], raw[i+2], raw[i+3]})
+		*c = append(*c, netip.AddrPortFrom(addr, binary.BigEndian.Uint16(raw[i+4:])))
+	}
+	return nil
+}
+
+func (c compactPeers) MarshalBencode() ([]byte, error) {
+	raw := make([]byte, 0, len(c)*6)
+	for _, p := range c {
+		ip := p.Addr().As4()
+		raw = append(raw, ip[:]...)
+		raw = binary.BigEndian.AppendUint16(raw, p.Port())
+	}
+	return bencode.Marshal(raw)
+}
+
+// bytesTarget decodes bencode string element.
+type bytesTarget []byte
+
+func (b *bytesTarget) UnmarshalBencode(data []byte) error {
+	ben, err := bencode.Parse(data)
+	if err != nil {
+		return err
+	}
+	str, ok := ben.(bencode.StringElement)
+	if !ok {
+		return fmt.Errorf("expected string, got %s", data)
+	}
+	*b = append((*b)[:0], str...)
+	return nil
+}
+
+type invalidMarshaler struct{}
+
+func (invalidMarshaler) MarshalBencode() ([]byte, error) {
+	return []byte("i1ei2e"), nil
+}
+
+func TestUnmarshal_Unmarshaler(t *testing.T) {
+	t.Parallel()
+
+	type response struct {
+		Interval int           `ben:"interval"`
+		Peers    compactPeers  `ben:"peers"`
+		PeersPtr *compactPeers `ben:"peers,optional"`
+	}
+
+	data := "d8:intervali60e5:peers12:\x7f\x00\x00\x01\x1a\xe1\x0a\x00\x00\x02\x1a\xe2e"
+	target := response{}
+	require.NoError(t, bencode.Unmarshal([]byte(data), &target))
+
+	expected := compactPeers{
+		netip.MustParseAddrPort("127.0.0.1:6881"),
+		netip.MustParseAddrPort("10.0.0.2:6882"),
+	}
+	assert.Equal(t, 60, target.Interval)
+	assert.Equal(t, expected, target.Peers)
+	require.NotNil(t, target.PeersPtr)
+	assert.Equal(t, expected, *target.PeersPtr)
+
+	target.PeersPtr = nil
+	encoded, err := bencode.Marshal(target)
+	require.NoError(t, err)
+	assert.Equal(t, data, string(encoded))
+}
+
+func TestUnmarshal_UnmarshalerError(t *testing.T) {
+	t.Parallel()
+
+	type response struct {
+		Peers compactPeers `ben:"peers"`
+	}
+	err := bencode.Unmarshal([]byte("d5:peersi1ee"), &response{})
+	assert.Error(t, err)
+}
+
+func TestRawMessage(t *testing.T) {
+	t.Parallel()
+
+	type message struct {
+		Type    int                           `ben:"type"`
+		Payload bencode.RawMessage            `ben:"payload"`
+		Extra   map[string]bencode.RawMessage `ben:"extra,optional"`
+		List    []bencode.RawMessage          `ben:"list,optional"`
+	}
+
+	data := "d5:extrad1:ai-1e1:b3:xyze4:listl2:abi7ee7:payloadd1:ki1e1:zl1:aee4:typei2ee"
+	target := message{}
+	require.NoError(t, bencode.Unmarshal([]byte(data), &target))
+
+	assert.Equal(t, 2, target.Type)
+	assert.Equal(t, bencode.RawMessage("d1:ki1e1:zl1:aee"), target.Payload)
+	assert.Equal(t, bencode.RawMessage("i-1e"), target.Extra["a"])
+	assert.Equal(t, bencode.RawMessage("3:xyz"), target.Extra["b"])
+	assert.Equal(t, []bencode.RawMessage{bencode.RawMessage("2:ab"), bencode.RawMessage("i7e")}, target.List)
+
+	encoded, err := bencode.Marshal(target)
+	require.NoError(t, err)
+	assert.Equal(t, data, string(encoded))
+}
+
+func TestRawMessage_TopLevel(t *testing.T) {
+	t.Parallel()
+
+	var raw bencode.RawMessage
+	require.NoError(t, bencode.Unmarshal([]byte("d1:ai1ee"), &raw))
+	assert.Equal(t, bencode.RawMessage("d1:ai1ee"), raw)
+}
+
+func TestRawMessage_NonCanonical(t *testing.T) {
+	t.Parallel()
+
+	type message struct {
+		Dict bencode.RawMessage   `ben:"dict"`
+		Int  bencode.RawMessage   `ben:"int"`
+		List []bencode.RawMessage `ben:"list"`
+		Str  bencode.RawMessage   `ben:"str"`
+	}
+	data := "d4:dictd1:bi03e1:ai1ee3:inti-0e4:listli03e02:abe3:str03:abce"
+	target := message{}
+	require.NoError(t, bencode.Unmarshal([]byte(data), &target))
+
+	assert.Equal(t, bencode.RawMessage("d1:bi03e1:ai1ee"), target.Dict)
+	assert.Equal(t, bencode.RawMessage("i-0e"), target.Int)
+	assert.Equal(t, []bencode.RawMessage{bencode.RawMessage("i03e"), bencode.RawMessage("02:ab")}, target.List)
+	assert.Equal(t, bencode.RawMessage("03:abc"), target.Str)
+
+	encoded, err := bencode.Marshal(target)
+	require.NoError(t, err)
+	assert.Equal(t, data, string(encoded))
+
+	var top bencode.RawMessage
+	require.NoError(t, bencode.Unmarshal([]byte("i03e"), &top))
+	assert.Equal(t, bencode.RawMessage("i03e"), top)
+}
+
+func TestMarshal_MarshalerErrors(t *testing.T) {
+	t.Parallel()
+
+	_, err := bencode.Marshal(invalidMarshaler{})
+	assert.Error(t, err)
+
+	type message struct {
+		Payload bencode.RawMessage `ben:"payload"`
+	}
+	_, err = bencode.Marshal(message{})
+	assert.Error(t, err)
+}
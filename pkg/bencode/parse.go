@@ -2,50 +2,125 @@ package bencode
 
 import (
 	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
 	"unsafe"
 )
 
 var (
-	ErrElementEnd   = errors.New("element not ended with 'e'")
-	ErrColonMissing = errors.New("missing ':' in string element")
-	ErrStringLength = errors.New("string length invalid")
+	ErrElementEnd     = errors.New("element not ended with 'e'")
+	ErrColonMissing   = errors.New("missing ':' in string element")
+	ErrStringLength   = errors.New("string length invalid")
+	ErrInvalidInteger = errors.New("invalid integer")
+	ErrMaxDepth       = errors.New("bencode: maximum nesting depth exceeded")
+	ErrNonCanonical   = errors.New("bencode: non-canonical encoding")
+	ErrNoData         = errors.New("bencode: no data")
+	ErrInvalidChar    = errors.New("bencode: invalid character")
 )
 
+// SyntaxError describes malformed bencode input. It wraps one of the
+// parse errors above, so it can be tested with errors.Is as well.
+type SyntaxError struct {
+	// Offset of the input byte where the error was found.
+	Offset int64
+	Msg    string
+	err    error
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("bencode: %s at offset %d", e.Msg, e.Offset)
+}
+
+func (e *SyntaxError) Unwrap() error {
+	return e.err
+}
+
+// DefaultMaxDepth is maximum nesting of lists and dictionaries accepted
+// when parsing bencode.
+const DefaultMaxDepth = 256
+
 func Parse(data []byte) (Bencode, error) {
 	sc := newScanner(data)
 	return sc.Parse()
 }
 
+// ParseStrict parses data accepting only canonical bencode. Integers and
+// string lengths with leading zeros, negative zero, unsorted or duplicate
+// dictionary keys and data after the bencode value are rejected with
+// error wrapping ErrNonCanonical.
+func ParseStrict(data []byte) (Bencode, error) {
+	sc := newScanner(data)
+	sc.strict = true
+	return sc.Parse()
+}
+
 type scanner struct {
 	start   int
 	current int
 	bencode []byte
+	// offset of the scanned data in the whole input, used for
+	// error reporting.
+	offset int64
+
+	depth    int
+	maxDepth int
+	strict   bool
 }
 
 func newScanner(data []byte) *scanner {
 	return &scanner{
-		start:   0,
-		current: 0,
-		bencode: data,
+		start:    0,
+		current:  0,
+		bencode:  data,
+		maxDepth: DefaultMaxDepth,
 	}
 }
 
 func (s *scanner) Parse() (Bencode, error) {
 	if s.IsFinished() {
-		return nil, errors.New("bencode: no data")
+		return nil, s.syntaxError(s.current, ErrNoData)
+	}
+
+	ben, err := s.Next()
+	if err == nil && s.strict && !s.IsFinished() {
+		return nil, s.nonCanonical(s.current, "trailing data after bencode value")
+	}
+	return ben, err
+}
+
+// syntaxError returns SyntaxError wrapping err for position pos of the
+// scanned data.
+func (s *scanner) syntaxError(pos int, err error) error {
+	return &SyntaxError{
+		Offset: s.offset + int64(pos),
+		Msg:    strings.TrimPrefix(err.Error(), "bencode: "),
+		err:    err,
+	}
+}
+
+// nonCanonical returns error for non-canonical encoding found at
+// position pos of the scanned data.
+func (s *scanner) nonCanonical(pos int, msg string) error {
+	return &SyntaxError{
+		Offset: s.offset + int64(pos),
+		Msg:    "non-canonical encoding: " + msg,
+		err:    ErrNonCanonical,
 	}
-	return s.Next()
 }
 
 func (s *scanner) Next() (Bencode, error) {
 	switch s.peek() {
-	case 'l':
-		return s.readList()
-	case 'd':
-		return s.readDict()
+	case 'l', 'd':
+		return s.readContainer()
 	case 'i':
 		return s.readInt()
 	default:
+		if !s.isDigit() {
+			return nil, s.syntaxError(s.current, ErrInvalidChar)
+		}
 		v, err := s.readString()
 		return StringElement(v), err
 	}
@@ -93,45 +168,78 @@ func (s *scanner) number() (int, error) {
 	n := 0
 	for s.isDigit() {
 		d := int(s.peek() - '0')
-		n += d
-		n *= 10
+		if n > (math.MaxInt-d)/10 {
+			return 0, s.syntaxError(s.start, ErrStringLength)
+		}
+		n = n*10 + d
 		s.advance()
 	}
-	n /= 10
 
 	s.position()
 	return n, nil
 }
 
-func (s *scanner) readInt() (IntElement, error) {
+// readInt reads integer element. Values which do not fit into int64
+// are returned as BigIntElement.
+func (s *scanner) readInt() (Bencode, error) {
+	begin := s.current
 	s.advance()
 	s.position()
 
-	n, err := s.number()
-	if err != nil {
-		return 0, err
+	if s.peek() == '-' {
+		s.advance()
+	}
+	for s.isDigit() {
+		s.advance()
 	}
+	digits := b2s(s.read())
+	s.position()
 
 	if !s.match('e') {
-		return 0, ErrElementEnd
+		return IntElement(0), s.syntaxError(s.current, ErrElementEnd)
+	}
+	if digits == "" || digits == "-" {
+		return IntElement(0), s.syntaxError(begin, ErrInvalidInteger)
+	}
+	if s.strict {
+		if strings.HasPrefix(digits, "-0") {
+			return IntElement(0), s.nonCanonical(begin, "negative zero or leading zero in integer")
+		}
+		if len(digits) > 1 && digits[0] == '0' {
+			return IntElement(0), s.nonCanonical(begin, "leading zero in integer")
+		}
+	}
+
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err == nil {
+		return IntElement(n), nil
+	}
+
+	bigInt, ok := new(big.Int).SetString(digits, 10)
+	if !ok {
+		return IntElement(0), s.syntaxError(begin, ErrInvalidInteger)
 	}
-	return IntElement(n), nil
+	return BigIntElement{value: bigInt}, nil
 }
 
 func (s *scanner) readString() (string, error) {
+	begin := s.current
 	length, err := s.number()
 	if err != nil {
 		return "", err
 	}
+	if s.strict && s.current-begin > 1 && s.bencode[begin] == '0' {
+		return "", s.nonCanonical(begin, "leading zero in string length")
+	}
 
 	if !s.match(':') {
-		return "", ErrColonMissing
+		return "", s.syntaxError(s.current, ErrColonMissing)
 	}
-	s.current += length
 	// we need to check if we are trying to read beyond string length.
-	if s.current > len(s.bencode) {
-		return "", ErrStringLength
+	if length > len(s.bencode)-s.current {
+		return "", s.syntaxError(begin, ErrStringLength)
 	}
+	s.current += length
 
 	strElement := b2s(s.read())
 	s.position()
@@ -139,54 +247,87 @@ func (s *scanner) readString() (string, error) {
 	return strElement, nil
 }
 
+// readContainer reads list or dictionary element keeping track of
+// nesting depth.
+func (s *scanner) readContainer() (Bencode, error) {
+	s.depth++
+	defer func() { s.depth-- }()
+	if s.maxDepth > 0 && s.depth > s.maxDepth {
+		return nil, s.syntaxError(s.current, ErrMaxDepth)
+	}
+
+	if s.peek() == 'l' {
+		return s.readList()
+	}
+	return s.readDict()
+}
+
 func (s *scanner) readList() (*ListElement, error) {
 	bencodeList := make([]Bencode, 0)
+	spans := make([]span, 0)
 	start := s.start
 	s.advance()
 	s.position()
 
 	for s.peek() != 'e' && !s.IsFinished() {
 		s.position()
+		begin := s.current
 		element, err := s.Next()
 		if err != nil {
 			return nil, err
 		}
 		bencodeList = append(bencodeList, element)
+		spans = append(spans, span{begin - start, s.current - start})
 	}
 	if !s.match('e') {
-		return nil, ErrElementEnd
+		return nil, s.syntaxError(s.current, ErrElementEnd)
 	}
 	end := s.start
 	raw := s.bencode[start:end]
 
-	return &ListElement{Value: bencodeList, raw: raw}, nil
+	return &ListElement{Value: bencodeList, raw: raw, spans: spans}, nil
 }
 
 func (s *scanner) readDict() (*DictElement, error) {
 	dict := make(map[string]Bencode)
+	keys := make([]string, 0)
+	spans := make(map[string]span)
 	start := s.start
 	s.advance()
 	s.position()
 
 	for s.peek() != 'e' && !s.IsFinished() {
+		keyPos := s.current
 		k, err := s.readString()
 		if err != nil {
 			return nil, err
 		}
+		if s.strict && len(keys) > 0 {
+			if prev := keys[len(keys)-1]; k == prev {
+				return nil, s.nonCanonical(keyPos, fmt.Sprintf("duplicate dictionary key %q", k))
+			} else if k < prev {
+				return nil, s.nonCanonical(keyPos, fmt.Sprintf("dictionary key %q not sorted", k))
+			}
+		}
+		begin := s.current
 		v, err := s.Next()
 		if err != nil {
 			return nil, err
 		}
 
+		if _, ok := dict[k]; !ok {
+			keys = append(keys, k)
+		}
 		dict[k] = v
+		spans[k] = span{begin - start, s.current - start}
 	}
 	if !s.match('e') {
-		return nil, ErrElementEnd
+		return nil, s.syntaxError(s.current, ErrElementEnd)
 	}
 	end := s.start
 	raw := s.bencode[start:end]
 
-	return &DictElement{value: dict, raw: raw}, nil
+	return &DictElement{value: dict, keys: keys, raw: raw, spans: spans}, nil
 }
 
 func b2s(b []byte) string {
@@ -3,8 +3,10 @@ package bencode
 import (
 	"fmt"
 	"io"
+	"math/big"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -51,6 +53,38 @@ func TestParse(t *testing.T) {
 			want:    IntElement(45902),
 			wantErr: false,
 		},
+		{
+			name: "parse negative integer",
+			args: args{
+				data: "i-42e",
+			},
+			want:    IntElement(-42),
+			wantErr: false,
+		},
+		{
+			name: "parse integer above int64",
+			args: args{
+				data: "i9223372036854775808e",
+			},
+			want:    BigIntElement{value: new(big.Int).Lsh(big.NewInt(1), 63)},
+			wantErr: false,
+		},
+		{
+			name: "integer without digits",
+			args: args{
+				data: "ie",
+			},
+			want:    IntElement(0),
+			wantErr: true,
+		},
+		{
+			name: "string length overflow",
+			args: args{
+				data: "99999999999999999999:a",
+			},
+			want:    StringElement(""),
+			wantErr: true,
+		},
 		{
 			name: "parse string",
 			args: args{
@@ -67,6 +101,7 @@ func TestParse(t *testing.T) {
 			want: &ListElement{
 				Value: []Bencode{IntElement(45902), StringElement("hello")},
 				raw:   []byte("li45902e5:helloe"),
+				spans: []span{{1, 8}, {8, 15}},
 			},
 			wantErr: false,
 		},
@@ -79,7 +114,12 @@ func TestParse(t *testing.T) {
 				value: map[string]Bencode{
 					"hello": IntElement(45902),
 					"world": StringElement("me")},
-				raw: []byte("d5:helloi45902e5:world2:mee"),
+				keys: []string{"hello", "world"},
+				raw:  []byte("d5:helloi45902e5:world2:mee"),
+				spans: map[string]span{
+					"hello": {8, 15},
+					"world": {22, 26},
+				},
 			},
 			wantErr: false,
 		},
@@ -115,6 +155,14 @@ func TestParse(t *testing.T) {
 			want:    nilDict,
 			wantErr: true,
 		},
+		{
+			name: "nesting too deep",
+			args: args{
+				data: strings.Repeat("l", DefaultMaxDepth+1) + strings.Repeat("e", DefaultMaxDepth+1),
+			},
+			want:    nilList,
+			wantErr: true,
+		},
 		{
 			name: "string len error in middle of dict",
 			args: args{
@@ -138,6 +186,108 @@ func TestParse(t *testing.T) {
 	}
 }
 
+func TestParse_KeepsDictOrder(t *testing.T) {
+	t.Parallel()
+
+	data := "d1:zi1e1:ad1:yi2e1:bi3ee1:m0:e"
+	ben, err := Parse([]byte(data))
+	assert.NoError(t, err)
+
+	dict := ben.(*DictElement)
+	assert.Equal(t, []string{"z", "a", "m"}, dict.Keys())
+	assert.Equal(t, data, ben.Encode())
+}
+
+func TestParse_ReencodesTorrentExactly(t *testing.T) {
+	t.Parallel()
+
+	for _, name := range []string{"tears-of-steel.torrent", "ubuntu-21.04-desktop-amd64.iso.torrent"} {
+		data := readTorrentFile(t, name)
+		ben, err := Parse(data)
+		assert.NoError(t, err)
+		assert.Equal(t, string(data), ben.Encode(), name)
+	}
+}
+
+func TestParseStrict(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{name: "canonical", data: "d1:ai-1e1:bli0ei10ee1:c0:e"},
+		{name: "leading zero", data: "li03ee", wantErr: "leading zero in integer at offset 1"},
+		{name: "negative zero", data: "i-0e", wantErr: "negative zero or leading zero in integer at offset 0"},
+		{name: "negative leading zero", data: "i-01e", wantErr: "at offset 0"},
+		{name: "string length leading zero", data: "l1:a03:abce", wantErr: "leading zero in string length at offset 4"},
+		{name: "unsorted keys", data: "d1:bi1e1:ai2ee", wantErr: `dictionary key "a" not sorted at offset 7`},
+		{name: "duplicate keys", data: "d1:ai1e1:ai2ee", wantErr: `duplicate dictionary key "a" at offset 7`},
+		{name: "nested unsorted keys", data: "d1:ad1:zi1e1:yi2eee", wantErr: `dictionary key "y" not sorted at offset 11`},
+		{name: "trailing data", data: "i1ei2e", wantErr: "trailing data after bencode value at offset 3"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := Parse([]byte(tt.data))
+			assert.NoError(t, err, "non-strict parse should accept input")
+
+			_, err = ParseStrict([]byte(tt.data))
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, ErrNonCanonical)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestParse_SyntaxError(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		data       string
+		wantOffset int64
+		target     error
+	}{
+		{name: "no data", data: "", wantOffset: 0, target: ErrNoData},
+		{name: "integer not ended", data: "li12", wantOffset: 4, target: ErrElementEnd},
+		{name: "empty integer", data: "d1:aiee", wantOffset: 4, target: ErrInvalidInteger},
+		{name: "missing colon", data: "l1:a3abce", wantOffset: 5, target: ErrColonMissing},
+		{name: "string too long", data: "d1:a10:abce", wantOffset: 4, target: ErrStringLength},
+		{name: "list not ended", data: "l1:a", wantOffset: 4, target: ErrElementEnd},
+		{name: "dict not ended", data: "d1:ai1e", wantOffset: 7, target: ErrElementEnd},
+		{name: "invalid character", data: "lxe", wantOffset: 1, target: ErrInvalidChar},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := Parse([]byte(tt.data))
+			var syntaxErr *SyntaxError
+			if assert.ErrorAs(t, err, &syntaxErr) {
+				assert.Equal(t, tt.wantOffset, syntaxErr.Offset)
+				assert.ErrorIs(t, err, tt.target)
+			}
+		})
+	}
+}
+
+func TestParseStrict_Torrents(t *testing.T) {
+	t.Parallel()
+
+	for _, name := range []string{"tears-of-steel.torrent", "ubuntu-21.04-desktop-amd64.iso.torrent"} {
+		_, err := ParseStrict(readTorrentFile(t, name))
+		assert.NoError(t, err, name)
+	}
+}
+
 var data = readTorrentFile(nil, "ubuntu-21.04-desktop-amd64.iso.torrent")
 
 func BenchmarkParse(b *testing.B) {
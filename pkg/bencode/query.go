@@ -0,0 +1,142 @@
+package bencode
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrPathNotFound = errors.New("bencode: path not found")
+	ErrInvalidPath  = errors.New("bencode: invalid path")
+)
+
+// Lookup returns element found at path. Path is a list of dictionary keys
+// separated with dots, where list elements are selected with index in
+// square brackets, for example info.files[0].path. Keys containing dots
+// or brackets can be quoted, as in info["piece length"]. Empty path
+// returns b.
+func Lookup(b Bencode, path string) (Bencode, error) {
+	segments, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	current := b
+	walked := ""
+	for _, seg := range segments {
+		if seg.index >= 0 {
+			walked += fmt.Sprintf("[%d]", seg.index)
+			list, ok := listValue(current)
+			if !ok || seg.index >= len(list.Value) {
+				return nil, fmt.Errorf("%w: %s", ErrPathNotFound, walked)
+			}
+			current = list.Value[seg.index]
+			continue
+		}
+
+		walked = joinPath(walked, seg.key)
+		dict, ok := dictValue(current)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrPathNotFound, walked)
+		}
+		current = dict.Value(seg.key)
+		if current == nil {
+			return nil, fmt.Errorf("%w: %s", ErrPathNotFound, walked)
+		}
+	}
+
+	return current, nil
+}
+
+type pathSegment struct {
+	key string
+	// index of the list element, -1 for dictionary keys
+	index int
+}
+
+func parsePath(path string) ([]pathSegment, error) {
+	segments := make([]pathSegment, 0)
+	invalid := func(msg string) error {
+		return fmt.Errorf("%w %q: %s", ErrInvalidPath, path, msg)
+	}
+
+	i := 0
+	if strings.HasPrefix(path, ".") {
+		i++
+	}
+	for i < len(path) {
+		switch path[i] {
+		case '[':
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return nil, invalid("missing ']'")
+			}
+			inner := path[i+1 : i+end]
+			if strings.HasPrefix(inner, `"`) {
+				// quoted key may contain ']' so look for closing quote
+				key, rest, err := unquotePrefix(path[i+1:])
+				if err != nil || !strings.HasPrefix(rest, "]") {
+					return nil, invalid("malformed quoted key")
+				}
+				segments = append(segments, pathSegment{key: key, index: -1})
+				i = len(path) - len(rest) + 1
+			} else {
+				index, err := strconv.Atoi(inner)
+				if err != nil || index < 0 {
+					return nil, invalid(fmt.Sprintf("invalid list index %q", inner))
+				}
+				segments = append(segments, pathSegment{index: index})
+				i += end + 1
+			}
+		case '.':
+			i++
+			if i == len(path) || path[i] == '.' {
+				return nil, invalid("empty key")
+			}
+		default:
+			end := strings.IndexAny(path[i:], ".[")
+			if end < 0 {
+				end = len(path) - i
+			}
+			segments = append(segments, pathSegment{key: path[i : i+end], index: -1})
+			i += end
+		}
+	}
+
+	return segments, nil
+}
+
+// unquotePrefix unquotes Go string literal at the start of s returning
+// the rest of s.
+func unquotePrefix(s string) (string, string, error) {
+	prefix, err := strconv.QuotedPrefix(s)
+	if err != nil {
+		return "", "", err
+	}
+	key, err := strconv.Unquote(prefix)
+	return key, s[len(prefix):], err
+}
+
+func listValue(b Bencode) (*ListElement, bool) {
+	switch v := b.(type) {
+	case *ListElement:
+		return v, true
+	case ListElement:
+		return &v, true
+	default:
+		return nil, false
+	}
+}
+
+func dictValue(b Bencode) (*DictElement, bool) {
+	switch v := b.(type) {
+	case *DictElement:
+		return v, true
+	case DictElement:
+		return &v, true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,76 @@
+package bencode_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anivanovic/gotit/pkg/bencode"
+)
+
+func TestLookup(t *testing.T) {
+	t.Parallel()
+
+	data := readTorrentFile(t, "tears-of-steel.torrent")
+	ben, err := bencode.Parse(data)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{name: "top level key", path: "announce", want: "udp://tracker.leechers-paradise.org:6969"},
+		{name: "nested key", path: "info.name", want: "Tears of Steel"},
+		{name: "key with space", path: "info.piece length", want: "524288"},
+		{name: "quoted key", path: `info["piece length"]`, want: "524288"},
+		{name: "list index", path: "info.files[0].path[0]", want: "Tears of Steel.de.srt"},
+		{name: "nested lists", path: "announce-list[1][0]", want: "udp://tracker.coppersurfer.tk:6969"},
+		{name: "leading dot", path: ".info.name", want: "Tears of Steel"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := bencode.Lookup(ben, tt.path)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got.String())
+		})
+	}
+
+	root, err := bencode.Lookup(ben, "")
+	require.NoError(t, err)
+	assert.Equal(t, ben, root)
+}
+
+func TestLookup_Errors(t *testing.T) {
+	t.Parallel()
+
+	ben, err := bencode.Parse([]byte("d4:infod5:filesld6:lengthi1eeeee"))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		path    string
+		target  error
+		wantErr string
+	}{
+		{name: "missing key", path: "info.name", target: bencode.ErrPathNotFound, wantErr: "info.name"},
+		{name: "index out of range", path: "info.files[1]", target: bencode.ErrPathNotFound, wantErr: "info.files[1]"},
+		{name: "index on dict", path: "info[0]", target: bencode.ErrPathNotFound},
+		{name: "key on list", path: "info.files.length", target: bencode.ErrPathNotFound},
+		{name: "unclosed bracket", path: "info.files[0", target: bencode.ErrInvalidPath},
+		{name: "bad index", path: "info.files[x]", target: bencode.ErrInvalidPath},
+		{name: "empty key", path: "info..files", target: bencode.ErrInvalidPath},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := bencode.Lookup(ben, tt.path)
+			assert.ErrorIs(t, err, tt.target)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
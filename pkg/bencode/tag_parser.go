@@ -2,9 +2,11 @@ package bencode
 
 import (
 	"crypto/sha1"
-	"encoding/base64"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"math/big"
 	"reflect"
 	"strconv"
 	"strings"
@@ -14,9 +16,57 @@ import (
 )
 
 var (
-	ErrWrongTarget = errors.New("bencode: pointer to struct expected as target")
+	ErrWrongTarget = errors.New("bencode: non-nil pointer expected as target")
 )
 
+// UnmarshalTypeError describes bencode value which could not be assigned
+// to the target Go value.
+type UnmarshalTypeError struct {
+	// Path to the value in the bencode document, for example
+	// info.files[3].length.
+	Path string
+	// Type is the Go type value could not be assigned to.
+	Type reflect.Type
+	// Expected bencode type for the target Go type.
+	Expected string
+	// Got is the bencode type found in the input.
+	Got string
+	// Reason optionally describes why value could not be assigned,
+	// for example when integer overflows the field type.
+	Reason string
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	path := pathName(e.Path)
+	if e.Reason != "" {
+		return fmt.Sprintf("bencode: %s: %s", path, e.Reason)
+	}
+	return fmt.Sprintf("bencode: %s: expected %s, got %s", path, e.Expected, e.Got)
+}
+
+// As sets target to TypeError describing the same failure, so callers
+// testing for TypeError with errors.As keep working.
+func (e *UnmarshalTypeError) As(target any) bool {
+	t, ok := target.(*TypeError)
+	if !ok {
+		return false
+	}
+	*t = TypeError{
+		ElementName: e.Path,
+		FieldType:   e.Expected,
+		BencodeType: e.Got,
+	}
+	if e.Type != nil {
+		t.FieldType = e.Type.String()
+	}
+	return true
+}
+
+// TypeError describes bencode value which could not be assigned to the
+// target field.
+//
+// Deprecated: Unmarshal returns *UnmarshalTypeError, which reports the
+// full path of the value. TypeError is still matched by errors.As.
 type TypeError struct {
 	ElementName string
 	FieldType   string
@@ -32,55 +82,79 @@ func (e TypeError) Error() string {
 	)
 }
 
+var bigIntType = reflect.TypeOf(big.Int{})
+
 func Unmarshal(data []byte, target interface{}) error {
-	ben, err := Parse(data)
+	sc := newScanner(data)
+	ben, err := sc.Parse()
 	if err != nil {
 		return err
 	}
 
-	return processTarget(target, ben)
+	return processTarget(target, ben, data[:sc.current], "")
 }
 
-func processTarget(target interface{}, bencode Bencode) error {
+// processTarget decodes bencode into target. Raw holds input bytes of
+// the bencode value, which are passed to Unmarshaler targets.
+func processTarget(target interface{}, bencode Bencode, raw []byte, path string) error {
+	if u, ok := target.(Unmarshaler); ok {
+		return u.UnmarshalBencode(raw)
+	}
+
 	val := reflect.ValueOf(target)
-	if val.Kind() != reflect.Ptr {
+	if val.Kind() != reflect.Ptr || val.IsNil() {
 		return ErrWrongTarget
 	}
 
-	val = val.Elem()
-	if val.Kind() != reflect.Struct {
-		return ErrWrongTarget
+	return setField(val.Elem(), bencode, raw, path)
+}
+
+// decodeStruct sets struct fields from dictionary values. Fields of
+// embedded structs without `ben` tag are set as if they were fields of
+// the outer struct, unless the key is shadowed by outer struct field.
+func decodeStruct(val reflect.Value, dict *DictElement, path string, shadowed map[string]bool) error {
+	keys := make(map[string]bool, len(shadowed)+val.NumField())
+	for k := range shadowed {
+		keys[k] = true
+	}
+	for i := 0; i < val.NumField(); i++ {
+		ftype := val.Type().Field(i)
+		if tagName, _ := parseTag(ftype.Tag.Get("ben")); tagName != "" {
+			keys[tagName] = true
+		}
 	}
 
 	for i := 0; i < val.NumField(); i++ {
 		f := val.Field(i)
 		ftype := val.Type().Field(i)
-		tagName := ftype.Tag.Get("ben")
+		tagName, opts := parseTag(ftype.Tag.Get("ben"))
 
-		if !f.CanSet() || tagName == "" {
-			continue
-		}
-		optional := false
-		splits := strings.Split(tagName, ",")
-		tagName = splits[0]
-		for _, opt := range splits[1:] {
-			if opt == "optional" {
-				optional = true
+		if ftype.Anonymous && tagName == "" {
+			if err := decodeEmbedded(f, dict, path, keys); err != nil {
+				return err
 			}
+			continue
 		}
 
-		dict, ok := bencode.(*DictElement)
-		if !ok {
-			panic("bencode internal: bencode element not *DictElement")
+		if !f.CanSet() || tagName == "" || shadowed[tagName] {
+			continue
 		}
+		optional := opts.optional
+
+		fieldPath := joinPath(path, tagName)
 		value := dict.Value(tagName)
 		if value == nil && !optional {
-			return fmt.Errorf("Required ben field %q not found", tagName)
+			return fmt.Errorf("bencode: required field %q not found", fieldPath)
 		}
 		if value == nil {
 			continue
 		}
-		if err := setField(f, value, ftype.Name); err != nil {
+		if opts.raw && f.Kind() == reflect.Slice && f.Type().Elem().Kind() == reflect.Uint8 {
+			// raw fields keep encoded value of any bencode type
+			f.SetBytes(dict.rawValue(tagName))
+			continue
+		}
+		if err := setField(f, value, dict.rawValue(tagName), fieldPath); err != nil {
 			return err
 		}
 	}
@@ -88,10 +162,114 @@ func processTarget(target interface{}, bencode Bencode) error {
 	return nil
 }
 
-func setField(f reflect.Value, value Bencode, fieldName string) error {
+func decodeEmbedded(f reflect.Value, dict *DictElement, path string, shadowed map[string]bool) error {
+	if f.Kind() == reflect.Ptr {
+		if f.Type().Elem().Kind() != reflect.Struct {
+			return nil
+		}
+		if f.IsNil() {
+			// pointer to unexported struct type can not be allocated
+			if !f.CanSet() {
+				return nil
+			}
+			f.Set(reflect.New(f.Type().Elem()))
+		}
+		f = f.Elem()
+	}
+	if f.Kind() != reflect.Struct {
+		return nil
+	}
+
+	return decodeStruct(f, dict, path, shadowed)
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func newTypeError(f reflect.Value, value Bencode, path string) *UnmarshalTypeError {
+	return &UnmarshalTypeError{
+		Path:     path,
+		Type:     f.Type(),
+		Expected: expectedType(f.Type()),
+		Got:      bencodeTypeName(value),
+	}
+}
+
+// expectedType returns name of the bencode type which can be assigned
+// to Go type t.
+func expectedType(t reflect.Type) string {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == bigIntType {
+		return "int"
+	}
+
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "int"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "string"
+		}
+		return "list"
+	case reflect.Struct, reflect.Map:
+		return "dict"
+	default:
+		return t.String()
+	}
+}
+
+func bencodeTypeName(value Bencode) string {
+	switch value.(type) {
+	case IntElement, BigIntElement:
+		return "int"
+	case StringElement:
+		return "string"
+	case ListElement, *ListElement:
+		return "list"
+	case DictElement, *DictElement:
+		return "dict"
+	default:
+		return reflect.TypeOf(value).String()
+	}
+}
+
+type tagOptions struct {
+	// optional fields may be missing from the bencode dictionary and are
+	// left out when encoding if they hold a zero value.
+	optional bool
+	// raw fields hold already encoded bencode and are written verbatim,
+	// taking precedence over other fields sharing the same key.
+	raw bool
+}
+
+// parseTag splits `ben` struct tag into dictionary key and options.
+func parseTag(tag string) (string, tagOptions) {
+	splits := strings.Split(tag, ",")
+	opts := tagOptions{}
+	for _, opt := range splits[1:] {
+		switch opt {
+		case "optional":
+			opts.optional = true
+		case "raw":
+			opts.raw = true
+		}
+	}
+	return splits[0], opts
+}
+
+func setField(f reflect.Value, value Bencode, raw []byte, path string) error {
 	ftype := f.Type()
 
-	if ftype.Kind() == reflect.Ptr {
+	for ftype.Kind() == reflect.Ptr {
 		ftype = ftype.Elem()
 		if f.IsNil() {
 			f.Set(reflect.New(ftype))
@@ -100,45 +278,49 @@ func setField(f reflect.Value, value Bencode, fieldName string) error {
 		f = f.Elem()
 	}
 
+	if u, ok := unmarshaler(f); ok {
+		return u.UnmarshalBencode(raw)
+	}
+
+	if ftype == bigIntType {
+		n, ok := bigIntValue(value)
+		if !ok {
+			return newTypeError(f, value, path)
+		}
+		f.Addr().Interface().(*big.Int).Set(n)
+		return nil
+	}
+
 	switch ftype.Kind() {
 	case reflect.String:
-		f.SetString(value.String())
-	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		vali, err := strconv.ParseInt(value.String(), 0, ftype.Bits())
-		if err != nil {
-			return &TypeError{
-				ElementName: fieldName,
-				FieldType:   f.Type().String(),
-				BencodeType: reflect.TypeOf(value).String(),
-			}
-		}
-		f.SetInt(vali)
-	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		val, err := strconv.ParseUint(value.String(), 0, ftype.Bits())
-		if err != nil {
-			return err
+		str, ok := value.(StringElement)
+		if !ok {
+			return newTypeError(f, value, path)
 		}
-		f.SetUint(val)
+		f.SetString(string(str))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return setInteger(f, value, path)
 	case reflect.Slice:
 		// support byte array and set to raw value
 		if f.Type().Elem().Kind() == reflect.Uint8 {
-			f.Set(reflect.ValueOf(value.Raw()))
+			str, ok := value.(StringElement)
+			if !ok {
+				return newTypeError(f, value, path)
+			}
+			f.SetBytes([]byte(str))
 			return nil
 		}
 
 		values, ok := value.(*ListElement)
 		if !ok {
-			return &TypeError{
-				ElementName: fieldName,
-				FieldType:   f.Type().String(),
-				BencodeType: reflect.TypeOf(value).String(),
-			}
+			return newTypeError(f, value, path)
 		}
 		slice := reflect.MakeSlice(ftype, len(values.Value), len(values.Value))
 
 		for i, v := range values.Value {
 			listTarget := slice.Index(i)
-			if err := setField(listTarget, v, fieldName); err != nil {
+			if err := setField(listTarget, v, values.rawValue(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
 				return err
 			}
 		}
@@ -147,36 +329,33 @@ func setField(f reflect.Value, value Bencode, fieldName string) error {
 	case reflect.Struct:
 		subDict, ok := value.(*DictElement)
 		if !ok {
-			return &TypeError{
-				ElementName: fieldName,
-				FieldType:   f.Type().String(),
-				BencodeType: reflect.TypeOf(value).String(),
-			}
+			return newTypeError(f, value, path)
 		}
-		structPtr := f.Addr().Interface()
-		if err := processTarget(structPtr, subDict); err != nil {
+		if err := decodeStruct(f, subDict, path, nil); err != nil {
 			return err
 		}
+	case reflect.Interface:
+		if ftype.NumMethod() != 0 {
+			return fmt.Errorf("bencode: %s: can not decode into non-empty interface %s", path, ftype)
+		}
+		f.Set(reflect.ValueOf(toInterface(value)))
 	case reflect.Map:
 		values, ok := value.(*DictElement)
 		if !ok {
-			return &TypeError{
-				ElementName: fieldName,
-				FieldType:   f.Type().String(),
-				BencodeType: reflect.TypeOf(value).String(),
-			}
+			return newTypeError(f, value, path)
 		}
 
-		m := reflect.MakeMap(ftype)
-		for k, v := range values.value {
-			mapValue := reflect.New(ftype.Elem())
-			if mapValue.Kind() == reflect.Ptr {
-				mapValue = mapValue.Elem()
-			}
-			if err := setField(mapValue, v, fieldName); err != nil {
+		if ftype.Key().Kind() != reflect.String {
+			return fmt.Errorf("bencode: %s: unsupported map key type %s", path, ftype.Key())
+		}
+
+		m := reflect.MakeMapWithSize(ftype, values.Len())
+		for _, k := range values.Keys() {
+			mapValue := reflect.New(ftype.Elem()).Elem()
+			if err := setField(mapValue, values.Value(k), values.rawValue(k), joinPath(path, k)); err != nil {
 				return err
 			}
-			m.SetMapIndex(reflect.ValueOf(k), mapValue)
+			m.SetMapIndex(reflect.ValueOf(k).Convert(ftype.Key()), mapValue)
 		}
 		f.Set(m)
 	default:
@@ -186,9 +365,114 @@ func setField(f reflect.Value, value Bencode, fieldName string) error {
 	return nil
 }
 
+// setInteger sets signed or unsigned integer field checking that value
+// fits into the field type.
+func setInteger(f reflect.Value, value Bencode, path string) error {
+	n, ok := bigIntValue(value)
+	if !ok {
+		return newTypeError(f, value, path)
+	}
+
+	overflow := false
+	switch f.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		overflow = !n.IsInt64() || f.OverflowInt(n.Int64())
+		if !overflow {
+			f.SetInt(n.Int64())
+		}
+	default:
+		overflow = !n.IsUint64() || f.OverflowUint(n.Uint64())
+		if !overflow {
+			f.SetUint(n.Uint64())
+		}
+	}
+
+	if overflow {
+		err := newTypeError(f, value, path)
+		err.Reason = fmt.Sprintf("value %s overflows %s", n, f.Type())
+		return err
+	}
+	return nil
+}
+
+// toInterface converts bencode element into generic Go value. Integers
+// are returned as int64, or *big.Int if they do not fit, strings as
+// string, lists as []interface{} and dictionaries as
+// map[string]interface{}.
+func toInterface(value Bencode) interface{} {
+	switch v := value.(type) {
+	case IntElement:
+		return int64(v)
+	case BigIntElement:
+		return new(big.Int).Set(v.value)
+	case StringElement:
+		return string(v)
+	case ListElement:
+		return toInterface(&v)
+	case DictElement:
+		return toInterface(&v)
+	case *ListElement:
+		list := make([]interface{}, len(v.Value))
+		for i, el := range v.Value {
+			list[i] = toInterface(el)
+		}
+		return list
+	case *DictElement:
+		dict := make(map[string]interface{}, v.Len())
+		v.Range(func(k string, el Bencode) bool {
+			dict[k] = toInterface(el)
+			return true
+		})
+		return dict
+	default:
+		return value
+	}
+}
+
+func bigIntValue(value Bencode) (*big.Int, bool) {
+	switch v := value.(type) {
+	case IntElement:
+		return big.NewInt(int64(v)), true
+	case BigIntElement:
+		return v.value, true
+	default:
+		return nil, false
+	}
+}
+
 type TorrentFile struct {
 	Path   []string `ben:"path"`
 	Length int      `ben:"length"`
+	// Attr holds file attributes (BEP 47), like AttrPad.
+	Attr string `ben:"attr,optional"`
+	// SymlinkPath is path of symlink target, relative to torrent root.
+	SymlinkPath []string `ben:"symlink path,optional"`
+}
+
+// File attributes (BEP 47)
+const (
+	AttrPad        = 'p'
+	AttrExecutable = 'x'
+	AttrHidden     = 'h'
+	AttrSymlink    = 'l'
+)
+
+// IsPad reports whether file is padding file, which aligns next file to
+// piece boundary and is not written to disk.
+func (f TorrentFile) IsPad() bool {
+	return strings.ContainsRune(f.Attr, AttrPad)
+}
+
+func (f TorrentFile) IsExecutable() bool {
+	return strings.ContainsRune(f.Attr, AttrExecutable)
+}
+
+func (f TorrentFile) IsHidden() bool {
+	return strings.ContainsRune(f.Attr, AttrHidden)
+}
+
+func (f TorrentFile) IsSymlink() bool {
+	return strings.ContainsRune(f.Attr, AttrSymlink)
 }
 
 func (f TorrentFile) String() string {
@@ -200,21 +484,31 @@ func (f TorrentFile) FilePath() string {
 }
 
 type Metainfo struct {
-	Announce     string     `ben:"announce"`
+	Announce     string     `ben:"announce,optional"`
 	AnnounceList [][]string `ben:"announce-list,optional"`
 	UrlList      []string   `ben:"url-list,optional"`
+	HttpSeeds    []string   `ben:"httpseeds,optional"`
 	Info         struct {
 		Files       []TorrentFile `ben:"files,optional"`
 		Length      int64         `ben:"length,optional"`
 		Name        string        `ben:"name"`
 		PieceLength int64         `ben:"piece length"`
-		Pieces      string        `ben:"pieces"`
+		// Pieces holds v1 SHA-1 piece hashes. It is missing in v2 only
+		// torrents.
+		Pieces string `ben:"pieces,optional"`
+		// Private torrents (BEP 27) get peers only from their trackers.
+		Private     int64    `ben:"private,optional"`
+		MetaVersion int64    `ben:"meta version,optional"`
+		FileTree    FileTree `ben:"file tree,optional"`
 	} `ben:"info"`
-	InfoDictRaw  []byte `ben:"info"`
-	Comment      string `ben:"comment,optional"`
-	CreatedBy    string `ben:"created by,optional"`
-	CreationDate int64  `ben:"creation date,optional"`
-	Encoding     string `ben:"encoding,optional"`
+	InfoDictRaw []byte `ben:"info,raw"`
+	// PieceLayers maps pieces root of v2 files bigger than piece length
+	// to concatenated SHA-256 hashes of its pieces.
+	PieceLayers  map[string][]byte `ben:"piece layers,optional"`
+	Comment      string            `ben:"comment,optional"`
+	CreatedBy    string            `ben:"created by,optional"`
+	CreationDate int64             `ben:"creation date,optional"`
+	Encoding     string            `ben:"encoding,optional"`
 }
 
 func (m Metainfo) String() string {
@@ -226,6 +520,11 @@ func (m Metainfo) String() string {
 	printValue("length", bytefmt.ByteSize(uint64(m.Info.Length)), 2, b)
 	printValue("piece-length", m.Info.PieceLength, 2, b)
 	printValue("files", m.Info.Files, 2, b)
+	printValue("meta-version", m.Info.MetaVersion, 2, b)
+	if m.IsPrivate() {
+		printValue("private", "true", 2, b)
+	}
+	printValue("file-tree", m.Info.FileTree, 2, b)
 	// TODO: should we print pieces?
 	b.WriteString("\t}\n")
 	printValue("comment", m.Comment, 1, b)
@@ -234,7 +533,11 @@ func (m Metainfo) String() string {
 	printValue("announce", m.Announce, 1, b)
 	printValue("announce-list", m.AnnounceList, 1, b)
 	printValue("url-list", m.UrlList, 1, b)
-	printValue("(calculated) info-hash", base64.URLEncoding.EncodeToString(m.Hash()), 1, b)
+	printValue("httpseeds", m.HttpSeeds, 1, b)
+	printValue("(calculated) info-hash", hex.EncodeToString(m.Hash()), 1, b)
+	if m.IsV2() {
+		printValue("(calculated) info-hash-v2", hex.EncodeToString(m.HashV2()), 1, b)
+	}
 	b.WriteString("}\n")
 
 	return b.String()
@@ -275,11 +578,42 @@ func printValue(name string, value interface{}, ident int, b *strings.Builder) {
 			}
 			b.WriteString(strings.Repeat("\t", ident) + "]\n")
 		}
+	case FileTree:
+		if len(value) != 0 {
+			b.WriteString(strings.Repeat("\t", ident) + name + ": [\n")
+			for _, f := range value {
+				b.WriteString(strings.Repeat("\t", ident+1) + f.String() + ",\n")
+			}
+			b.WriteString(strings.Repeat("\t", ident) + "]\n")
+		}
 	}
 }
 
+// Hash returns v1 info-hash, SHA-1 of the info dictionary.
 func (m Metainfo) Hash() []byte {
 	sha := sha1.New()
 	sha.Write(m.InfoDictRaw)
 	return sha.Sum(nil)
 }
+
+// HashV2 returns v2 info-hash, SHA-256 of the info dictionary.
+func (m Metainfo) HashV2() []byte {
+	sha := sha256.New()
+	sha.Write(m.InfoDictRaw)
+	return sha.Sum(nil)
+}
+
+// IsPrivate reports whether torrent is private (BEP 27).
+func (m Metainfo) IsPrivate() bool {
+	return m.Info.Private == 1
+}
+
+// IsV2 reports whether torrent has v2 metadata (BEP 52).
+func (m Metainfo) IsV2() bool {
+	return m.Info.MetaVersion == 2
+}
+
+// IsHybrid reports whether torrent has both v1 and v2 metadata.
+func (m Metainfo) IsHybrid() bool {
+	return m.IsV2() && m.Info.Pieces != ""
+}
@@ -3,10 +3,13 @@ package bencode_test
 import (
 	"fmt"
 	"io"
+	"math"
+	"math/big"
 	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/anivanovic/gotit/pkg/bencode"
 )
@@ -88,7 +91,7 @@ func TestUnmarshal(t *testing.T) {
 	url-list: [
 		https://webtorrent.io/torrents/,
 	]
-	(calculated) info-hash: IJyCJrKZswi-ryuc0_tJIS29E-w=
+	(calculated) info-hash: 209c8226b299b308beaf2b9cd3fb49212dbd13ec
 }
 `)
 }
@@ -124,7 +127,68 @@ func TestUnmarshal_TypeError(t *testing.T) {
 	target := &testStruct{}
 	err := bencode.Unmarshal([]byte(data), target)
 	assert.Error(t, err)
-	assert.Equal(t, err.Error(), "could not assign bencode value to target (field name: IntValue, field type: int, bencode type: *bencode.ListElement)")
+	assert.Equal(t, err.Error(), "bencode: int: expected int, got list")
+}
+
+func TestUnmarshal_TypeErrorPath(t *testing.T) {
+	data := "d4:infod5:filesld6:lengthi1e4:pathl1:aeed6:lengthli2ee4:pathl1:beeeee"
+	type file struct {
+		Length int      `ben:"length"`
+		Path   []string `ben:"path"`
+	}
+	type testStruct struct {
+		Info struct {
+			Files []file `ben:"files"`
+		} `ben:"info"`
+	}
+
+	err := bencode.Unmarshal([]byte(data), &testStruct{})
+	var typeErr *bencode.UnmarshalTypeError
+	if assert.ErrorAs(t, err, &typeErr) {
+		assert.Equal(t, "info.files[1].length", typeErr.Path)
+		assert.Equal(t, "int", typeErr.Expected)
+		assert.Equal(t, "list", typeErr.Got)
+		assert.Equal(t, "bencode: info.files[1].length: expected int, got list", err.Error())
+	}
+}
+
+func TestUnmarshal_StringTypeError(t *testing.T) {
+	type stringTarget struct {
+		Value string `ben:"value"`
+	}
+	type bytesTarget struct {
+		Value []byte `ben:"value"`
+	}
+
+	for _, data := range []string{"d5:valuei1ee", "d5:valuel1:aee", "d5:valued1:ai1eee"} {
+		var typeErr *bencode.UnmarshalTypeError
+		err := bencode.Unmarshal([]byte(data), &stringTarget{})
+		if assert.ErrorAs(t, err, &typeErr, data) {
+			assert.Equal(t, "string", typeErr.Expected)
+		}
+		err = bencode.Unmarshal([]byte(data), &bytesTarget{})
+		if assert.ErrorAs(t, err, &typeErr, data) {
+			assert.Equal(t, "string", typeErr.Expected)
+		}
+	}
+
+	target := bytesTarget{}
+	require.NoError(t, bencode.Unmarshal([]byte("d5:value3:abce"), &target))
+	assert.Equal(t, []byte("abc"), target.Value)
+}
+
+func TestUnmarshal_DeprecatedTypeError(t *testing.T) {
+	type testStruct struct {
+		Value string `ben:"value"`
+	}
+	err := bencode.Unmarshal([]byte("d5:valuei1ee"), &testStruct{})
+
+	var typeErr bencode.TypeError
+	if assert.ErrorAs(t, err, &typeErr) {
+		assert.Equal(t, "value", typeErr.ElementName)
+		assert.Equal(t, "string", typeErr.FieldType)
+		assert.Equal(t, "int", typeErr.BencodeType)
+	}
 }
 
 func TestUnmarshal_SupportedTypes(t *testing.T) {
@@ -193,6 +257,97 @@ func TestUnmarshal_SupportedTypes(t *testing.T) {
 	assert.Equal(t, *target.IntPointer, 33)
 }
 
+func TestUnmarshal_IntegerRanges(t *testing.T) {
+	t.Parallel()
+
+	type testStruct struct {
+		Int8   int8     `ben:"int8,optional"`
+		Int64  int64    `ben:"int64,optional"`
+		Uint8  uint8    `ben:"uint8,optional"`
+		Uint64 uint64   `ben:"uint64,optional"`
+		Big    *big.Int `ben:"big,optional"`
+		BigVal big.Int  `ben:"big_value,optional"`
+	}
+	tests := []struct {
+		name    string
+		data    string
+		want    testStruct
+		wantErr string
+	}{
+		{
+			name: "limits",
+			data: "d4:int8i-128e5:int64i-9223372036854775808e5:uint8i255e6:uint64i18446744073709551615ee",
+			want: testStruct{Int8: -128, Int64: math.MinInt64, Uint8: 255, Uint64: math.MaxUint64},
+		},
+		{
+			name:    "int8 overflow",
+			data:    "d4:int8i128ee",
+			wantErr: "bencode: int8: value 128 overflows int8",
+		},
+		{
+			name:    "int64 overflow",
+			data:    "d5:int64i9223372036854775808ee",
+			wantErr: "bencode: int64: value 9223372036854775808 overflows int64",
+		},
+		{
+			name:    "negative unsigned",
+			data:    "d5:uint8i-1ee",
+			wantErr: "bencode: uint8: value -1 overflows uint8",
+		},
+		{
+			name:    "uint64 overflow",
+			data:    "d6:uint64i18446744073709551616ee",
+			wantErr: "value 18446744073709551616 overflows uint64",
+		},
+		{
+			name:    "string is not integer",
+			data:    "d4:int82:12e",
+			wantErr: "bencode: int8: expected int, got string",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			target := testStruct{}
+			err := bencode.Unmarshal([]byte(tt.data), &target)
+			if tt.wantErr != "" {
+				var typeErr *bencode.UnmarshalTypeError
+				assert.ErrorAs(t, err, &typeErr)
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, target)
+		})
+	}
+}
+
+func TestUnmarshal_BigInt(t *testing.T) {
+	t.Parallel()
+
+	type testStruct struct {
+		Big      *big.Int   `ben:"big"`
+		BigValue big.Int    `ben:"small"`
+		BigList  []*big.Int `ben:"list"`
+	}
+
+	huge := "-123456789012345678901234567890"
+	data := "d3:bigi" + huge + "e4:listli1ei" + huge[1:] + "ee5:smalli42ee"
+	target := testStruct{}
+	assert.NoError(t, bencode.Unmarshal([]byte(data), &target))
+
+	expected, _ := new(big.Int).SetString(huge, 10)
+	assert.Equal(t, 0, expected.Cmp(target.Big))
+	assert.Equal(t, int64(42), target.BigValue.Int64())
+	assert.Len(t, target.BigList, 2)
+	assert.Equal(t, huge[1:], target.BigList[1].String())
+
+	encoded, err := bencode.Marshal(target)
+	assert.NoError(t, err)
+	assert.Equal(t, data, string(encoded))
+}
+
 func BenchmarkUnmarshal(b *testing.B) {
 	data := readTorrentFile(b, "tears-of-steel.torrent")
 	torrent := bencode.TorrentFile{}
@@ -204,3 +359,91 @@ func BenchmarkUnmarshal(b *testing.B) {
 		}
 	}
 }
+
+func TestUnmarshal_Interface(t *testing.T) {
+	t.Parallel()
+
+	data := "d4:dictd1:ai1ee3:inti-5e3:inti170141183460469231731687303715884105728e4:listl1:ai2ee6:string3:abce"
+	var target interface{}
+	err := bencode.Unmarshal([]byte(data), &target)
+	assert.NoError(t, err)
+
+	huge, _ := new(big.Int).SetString("170141183460469231731687303715884105728", 10)
+	assert.Equal(t, map[string]interface{}{
+		"dict":   map[string]interface{}{"a": int64(1)},
+		"int":    huge,
+		"list":   []interface{}{"a", int64(2)},
+		"string": "abc",
+	}, target)
+}
+
+func TestUnmarshal_MapsAndPointers(t *testing.T) {
+	t.Parallel()
+
+	type file struct {
+		Length int64 `ben:"length"`
+	}
+	type testStruct struct {
+		Files  map[string]file        `ben:"files"`
+		Layers map[string][]byte      `ben:"layers"`
+		Ptr    **int                  `ben:"ptr"`
+		Attr   *string                `ben:"attr,optional"`
+		Extra  map[string]interface{} `ben:"extra"`
+	}
+
+	data := "d5:extrad1:xli1eee5:filesd1:ad6:lengthi3eee6:layersd1:b2:\x00\x01e3:ptri7ee"
+	target := testStruct{}
+	err := bencode.Unmarshal([]byte(data), &target)
+	assert.NoError(t, err)
+
+	assert.Equal(t, map[string]file{"a": {Length: 3}}, target.Files)
+	assert.Equal(t, map[string][]byte{"b": {0, 1}}, target.Layers)
+	assert.Equal(t, 7, **target.Ptr)
+	assert.Nil(t, target.Attr)
+	assert.Equal(t, map[string]interface{}{"x": []interface{}{int64(1)}}, target.Extra)
+
+	m := map[string]int{}
+	assert.NoError(t, bencode.Unmarshal([]byte("d1:ai1e1:bi2ee"), &m))
+	assert.Equal(t, map[string]int{"a": 1, "b": 2}, m)
+}
+
+func TestUnmarshal_Embedded(t *testing.T) {
+	t.Parallel()
+
+	type Base struct {
+		Name string `ben:"name"`
+	}
+	type Extra struct {
+		Size int `ben:"size"`
+	}
+	type testStruct struct {
+		Base
+		*Extra
+		Msg int `ben:"msg_type"`
+	}
+
+	target := testStruct{}
+	err := bencode.Unmarshal([]byte("d8:msg_typei1e4:name3:abc4:sizei9ee"), &target)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", target.Name)
+	assert.Equal(t, 9, target.Size)
+	assert.Equal(t, 1, target.Msg)
+
+	err = bencode.Unmarshal([]byte("d8:msg_typei1e4:sizei9ee"), &testStruct{})
+	assert.ErrorContains(t, err, `"name"`)
+}
+
+func TestUnmarshal_FileAttributes(t *testing.T) {
+	data := "d4:pathl4:.pad1:5e6:lengthi5e4:attr1:pe"
+	f := bencode.TorrentFile{}
+	assert.NoError(t, bencode.Unmarshal([]byte(data), &f))
+	assert.True(t, f.IsPad())
+	assert.False(t, f.IsExecutable())
+
+	data = "d4:pathl4:linke6:lengthi0e4:attr2:lh12:symlink pathl3:dir6:targetee"
+	f = bencode.TorrentFile{}
+	assert.NoError(t, bencode.Unmarshal([]byte(data), &f))
+	assert.True(t, f.IsSymlink())
+	assert.True(t, f.IsHidden())
+	assert.Equal(t, []string{"dir", "target"}, f.SymlinkPath)
+}
@@ -0,0 +1,379 @@
+package bencode
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// BinaryEncoding selects how strings which are not printable text are
+// written when bencode is converted to text formats such as JSON.
+type BinaryEncoding int
+
+const (
+	BinaryHex BinaryEncoding = iota
+	BinaryBase64
+)
+
+const (
+	hexPrefix    = "hex:"
+	base64Prefix = "base64:"
+)
+
+// ParseBinaryEncoding returns BinaryEncoding for its name, hex or base64.
+func ParseBinaryEncoding(name string) (BinaryEncoding, error) {
+	switch name {
+	case "hex":
+		return BinaryHex, nil
+	case "base64":
+		return BinaryBase64, nil
+	default:
+		return BinaryHex, fmt.Errorf("unknown binary encoding %q, expected hex or base64", name)
+	}
+}
+
+func (e BinaryEncoding) String() string {
+	if e == BinaryBase64 {
+		return "base64"
+	}
+	return "hex"
+}
+
+// TextString returns s unchanged if it is printable UTF-8 text. Other
+// strings are encoded with enc and prefixed with "hex:" or "base64:".
+// Text strings starting with one of the prefixes are encoded as well,
+// so that ParseTextString always returns the original value.
+func TextString(s string, enc BinaryEncoding) string {
+	if isText(s) && !strings.HasPrefix(s, hexPrefix) && !strings.HasPrefix(s, base64Prefix) {
+		return s
+	}
+
+	if enc == BinaryBase64 {
+		return base64Prefix + base64.StdEncoding.EncodeToString([]byte(s))
+	}
+	return hexPrefix + hex.EncodeToString([]byte(s))
+}
+
+// ParseTextString reverses TextString, decoding strings prefixed with
+// "hex:" or "base64:".
+func ParseTextString(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, hexPrefix):
+		b, err := hex.DecodeString(s[len(hexPrefix):])
+		if err != nil {
+			return "", fmt.Errorf("decode hex string: %w", err)
+		}
+		return string(b), nil
+	case strings.HasPrefix(s, base64Prefix):
+		b, err := base64.StdEncoding.DecodeString(s[len(base64Prefix):])
+		if err != nil {
+			return "", fmt.Errorf("decode base64 string: %w", err)
+		}
+		return string(b), nil
+	default:
+		return s, nil
+	}
+}
+
+func isText(s string) bool {
+	if !utf8.ValidString(s) {
+		return false
+	}
+	for _, r := range s {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteJSON writes bencode element as indented JSON. Dictionary keys are
+// written in dictionary order and strings which are not text are encoded
+// as described by TextString.
+func WriteJSON(w io.Writer, b Bencode, enc BinaryEncoding) error {
+	buf := &bytes.Buffer{}
+	if err := writeJSON(buf, b, enc); err != nil {
+		return err
+	}
+
+	out := &bytes.Buffer{}
+	if err := json.Indent(out, buf.Bytes(), "", "  "); err != nil {
+		return err
+	}
+	out.WriteByte('\n')
+
+	_, err := out.WriteTo(w)
+	return err
+}
+
+func writeJSON(buf *bytes.Buffer, b Bencode, enc BinaryEncoding) error {
+	switch v := b.(type) {
+	case IntElement, BigIntElement:
+		buf.WriteString(v.String())
+	case StringElement:
+		return writeJSONString(buf, TextString(string(v), enc))
+	case ListElement:
+		return writeJSON(buf, &v, enc)
+	case *ListElement:
+		buf.WriteByte('[')
+		for i, el := range v.Value {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeJSON(buf, el, enc); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case DictElement:
+		return writeJSON(buf, &v, enc)
+	case *DictElement:
+		buf.WriteByte('{')
+		var err error
+		first := true
+		v.Range(func(k string, el Bencode) bool {
+			if !first {
+				buf.WriteByte(',')
+			}
+			first = false
+			if err = writeJSONString(buf, TextString(k, enc)); err != nil {
+				return false
+			}
+			buf.WriteByte(':')
+			err = writeJSON(buf, el, enc)
+			return err == nil
+		})
+		if err != nil {
+			return err
+		}
+		buf.WriteByte('}')
+	default:
+		return fmt.Errorf("unsupported bencode type %T", b)
+	}
+
+	return nil
+}
+
+func writeJSONString(buf *bytes.Buffer, s string) error {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(s); err != nil {
+		return err
+	}
+	// drop newline written by the encoder
+	buf.Truncate(buf.Len() - 1)
+	return nil
+}
+
+// ReadJSON reads JSON value, as written by WriteJSON, and converts it into
+// bencode. Strings prefixed with "hex:" or "base64:" are decoded and
+// dictionary keys are sorted, so the result is in canonical form. JSON
+// numbers must be integers, while booleans and null values are rejected.
+// Keys which are equal after decoding are rejected as duplicates.
+func ReadJSON(r io.Reader) (Bencode, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+
+	b, err := readJSONValue(dec, "")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("bencode: unexpected data after JSON value")
+	}
+	return b, nil
+}
+
+func readJSONValue(dec *json.Decoder, path string) (Bencode, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := tok.(type) {
+	case json.Number:
+		n, ok := new(big.Int).SetString(v.String(), 10)
+		if !ok {
+			return nil, fmt.Errorf("bencode: %s: number %s is not an integer", pathName(path), v)
+		}
+		return BigInteger(n), nil
+	case string:
+		return textString(v, path)
+	case json.Delim:
+		if v == '[' {
+			values := make([]Bencode, 0)
+			for i := 0; dec.More(); i++ {
+				b, err := readJSONValue(dec, fmt.Sprintf("%s[%d]", path, i))
+				if err != nil {
+					return nil, err
+				}
+				values = append(values, b)
+			}
+			if _, err := dec.Token(); err != nil {
+				return nil, err
+			}
+			return List(values...), nil
+		}
+
+		dict := newTextDict(path)
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			err = dict.add(tok.(string), func(path string) (Bencode, error) {
+				return readJSONValue(dec, path)
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return SortedDict(dict.values), nil
+	default:
+		return nil, fmt.Errorf("bencode: %s: unsupported JSON value %v", pathName(path), v)
+	}
+}
+
+// ReadYAML reads YAML document and converts it into bencode the same way
+// ReadJSON converts JSON. Integers and strings, including !!binary
+// values, are supported, while other scalar types are rejected.
+func ReadYAML(r io.Reader) (Bencode, error) {
+	dec := yaml.NewDecoder(r)
+	node := &yaml.Node{}
+	if err := dec.Decode(node); err != nil {
+		return nil, err
+	}
+	if err := dec.Decode(&yaml.Node{}); err != io.EOF {
+		return nil, errors.New("bencode: unexpected data after YAML document")
+	}
+
+	return fromYAML(node, "")
+}
+
+func fromYAML(node *yaml.Node, path string) (Bencode, error) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil, errors.New("bencode: empty YAML document")
+		}
+		return fromYAML(node.Content[0], path)
+	case yaml.AliasNode:
+		return fromYAML(node.Alias, path)
+	case yaml.ScalarNode:
+		switch node.ShortTag() {
+		case "!!int":
+			n, ok := new(big.Int).SetString(node.Value, 0)
+			if !ok {
+				return nil, fmt.Errorf("bencode: %s: invalid integer %s", pathName(path), node.Value)
+			}
+			return BigInteger(n), nil
+		case "!!str":
+			return textString(node.Value, path)
+		case "!!binary":
+			return textString(base64Prefix+strings.Join(strings.Fields(node.Value), ""), path)
+		default:
+			return nil, fmt.Errorf("bencode: %s: unsupported YAML value %s of type %s", pathName(path), node.Value, node.ShortTag())
+		}
+	case yaml.SequenceNode:
+		values := make([]Bencode, len(node.Content))
+		for i, el := range node.Content {
+			b, err := fromYAML(el, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			values[i] = b
+		}
+		return List(values...), nil
+	case yaml.MappingNode:
+		dict := newTextDict(path)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			value := node.Content[i+1]
+			err := dict.add(node.Content[i].Value, func(path string) (Bencode, error) {
+				return fromYAML(value, path)
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		return SortedDict(dict.values), nil
+	default:
+		return nil, fmt.Errorf("bencode: %s: unsupported YAML node", pathName(path))
+	}
+}
+
+// textString decodes string read from JSON or YAML at path.
+func textString(s, path string) (Bencode, error) {
+	value, err := ParseTextString(s)
+	if err != nil {
+		return nil, fmt.Errorf("bencode: %s: %w", pathName(path), err)
+	}
+	return String(value), nil
+}
+
+// textDict collects dictionary read from JSON or YAML.
+type textDict struct {
+	path   string
+	values map[string]Bencode
+}
+
+func newTextDict(path string) *textDict {
+	return &textDict{path: path, values: make(map[string]Bencode)}
+}
+
+// add decodes key k and sets its value, which is converted by value
+// using path of the dictionary entry. Keys must be unique after
+// decoding, so "a" and "hex:61" can not be used in the same dictionary.
+func (d *textDict) add(k string, value func(path string) (Bencode, error)) error {
+	path := joinPath(d.path, k)
+	key, err := ParseTextString(k)
+	if err != nil {
+		return fmt.Errorf("bencode: %s: %w", path, err)
+	}
+	if _, ok := d.values[key]; ok {
+		return fmt.Errorf("bencode: %s: duplicate key", path)
+	}
+
+	b, err := value(path)
+	if err != nil {
+		return err
+	}
+	d.values[key] = b
+	return nil
+}
+
+// SortedDict returns dictionary element holding values, with keys in
+// sorted order as required by canonical bencode.
+func SortedDict(values map[string]Bencode) Bencode {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	builder := NewDictBuilder()
+	for _, k := range keys {
+		builder.Add(k, values[k])
+	}
+	return builder.Generate()
+}
+
+func pathName(path string) string {
+	if path == "" {
+		return "(root)"
+	}
+	return path
+}
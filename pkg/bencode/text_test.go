@@ -0,0 +1,185 @@
+package bencode_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anivanovic/gotit/pkg/bencode"
+)
+
+func TestTextString(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		value string
+		enc   bencode.BinaryEncoding
+		want  string
+	}{
+		{name: "text", value: "Tears of Steel", want: "Tears of Steel"},
+		{name: "utf8 text", value: "čćž\t", want: "čćž\t"},
+		{name: "binary hex", value: "\x00\xff", want: "hex:00ff"},
+		{name: "binary base64", value: "\x00\xff", enc: bencode.BinaryBase64, want: "base64:AP8="},
+		{name: "ambiguous prefix", value: "hex:00", want: "hex:6865783a3030"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got := bencode.TextString(tt.value, tt.enc)
+			assert.Equal(t, tt.want, got)
+
+			decoded, err := bencode.ParseTextString(got)
+			require.NoError(t, err)
+			assert.Equal(t, tt.value, decoded)
+		})
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	t.Parallel()
+
+	ben, err := bencode.Parse([]byte("d1:zi170141183460469231731687303715884105728e1:ad1:bl2:\x00\x01i-1eee1:c5:helloe"))
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, bencode.WriteJSON(buf, ben, bencode.BinaryHex))
+	expected := `{
+  "z": 170141183460469231731687303715884105728,
+  "a": {
+    "b": [
+      "hex:0001",
+      -1
+    ]
+  },
+  "c": "hello"
+}
+`
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestPretty_Binary(t *testing.T) {
+	t.Parallel()
+
+	ben, err := bencode.Parse([]byte("d6:pieces2:\x00\x01e"))
+	require.NoError(t, err)
+	assert.Equal(t, "{\n\tpieces: base64:AAE=,\n}", bencode.Pretty(ben, bencode.BinaryBase64))
+}
+
+func TestReadJSON(t *testing.T) {
+	t.Parallel()
+
+	ben, err := bencode.ReadJSON(strings.NewReader(`{"z": 170141183460469231731687303715884105728, "a": ["hex:0001", -1, "base64:AAE="], "hex:6b": {}}`))
+	require.NoError(t, err)
+	assert.Equal(t, "d1:al2:\x00\x01i-1e2:\x00\x01e1:kde1:zi170141183460469231731687303715884105728ee", ben.Encode())
+}
+
+func TestReadJSON_RoundTrip(t *testing.T) {
+	t.Parallel()
+
+	for _, enc := range []bencode.BinaryEncoding{bencode.BinaryHex, bencode.BinaryBase64} {
+		data := readTorrentFile(t, "tears-of-steel.torrent")
+		ben, err := bencode.Parse(data)
+		require.NoError(t, err)
+
+		buf := &bytes.Buffer{}
+		require.NoError(t, bencode.WriteJSON(buf, ben, enc))
+
+		decoded, err := bencode.ReadJSON(buf)
+		require.NoError(t, err)
+		assert.Equal(t, string(data), decoded.Encode(), enc.String())
+	}
+}
+
+func TestReadJSON_Errors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{name: "float", data: `{"a": [1.5]}`, wantErr: "a[0]: number 1.5 is not an integer"},
+		{name: "boolean", data: `{"a": true}`, wantErr: "a: unsupported JSON value true"},
+		{name: "null", data: `null`, wantErr: "(root): unsupported JSON value"},
+		{name: "invalid hex", data: `{"a": "hex:zz"}`, wantErr: "a: decode hex string"},
+		{name: "trailing data", data: `{} {}`, wantErr: "unexpected data after JSON value"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := bencode.ReadJSON(strings.NewReader(tt.data))
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestReadYAML(t *testing.T) {
+	t.Parallel()
+
+	data := `
+z: !!int 170141183460469231731687303715884105728
+a:
+  - hex:0001
+  - -1
+  - !!binary AAE=
+hex:6b: {}
+`
+	ben, err := bencode.ReadYAML(strings.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, "d1:al2:\x00\x01i-1e2:\x00\x01e1:kde1:zi170141183460469231731687303715884105728ee", ben.Encode())
+}
+
+func TestReadYAML_Errors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{name: "float", data: "a: [1.5]", wantErr: "a[0]: unsupported YAML value 1.5"},
+		{name: "boolean", data: "a: true", wantErr: "a: unsupported YAML value true"},
+		{name: "invalid hex", data: "a: hex:zz", wantErr: "a: decode hex string"},
+		{name: "empty", data: "", wantErr: "EOF"},
+		{name: "trailing document", data: "a: 1\n---\nb: 2", wantErr: "unexpected data after YAML document"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := bencode.ReadYAML(strings.NewReader(tt.data))
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestReadText_DuplicateKeys(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		read    func(io.Reader) (bencode.Bencode, error)
+		data    string
+		wantErr string
+	}{
+		{name: "json", read: bencode.ReadJSON, data: `{"d": {"a": 1, "a": 2}}`, wantErr: "d.a: duplicate key"},
+		{name: "json decoded", read: bencode.ReadJSON, data: `{"d": {"a": 1, "hex:61": 2}}`, wantErr: "d.hex:61: duplicate key"},
+		{name: "yaml", read: bencode.ReadYAML, data: "d: {a: 1, a: 2}", wantErr: "d.a: duplicate key"},
+		{name: "yaml decoded", read: bencode.ReadYAML, data: "d: {a: 1, hex:61: 2}", wantErr: "d.hex:61: duplicate key"},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := tt.read(strings.NewReader(tt.data))
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
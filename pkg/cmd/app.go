@@ -35,6 +35,17 @@ type (
 	}
 )
 
+// ExitError ends command with exit code other than 1, used for general
+// errors.
+type ExitError struct {
+	Code int
+	Msg  string
+}
+
+func (e *ExitError) Error() string {
+	return e.Msg
+}
+
 func NewApp() *App {
 	rootCmd := cobra.Command{
 		Use: "gotit",
@@ -54,6 +65,11 @@ func NewApp() *App {
 
 	rootCmd.AddCommand(NewCommand(app))
 	rootCmd.AddCommand(NewDownloadCommand(app))
+	rootCmd.AddCommand(NewCreateCommand(app))
+	rootCmd.AddCommand(NewEditCommand(app))
+	rootCmd.AddCommand(NewInfoCommand(app))
+	rootCmd.AddCommand(NewMagnetCommand(app))
+	rootCmd.AddCommand(NewVerifyCommand(app))
 	rootCmd.AddCommand(NewVersionCommand())
 
 	return app
@@ -112,7 +128,11 @@ func (a App) NewCmdRun(
 	return func(_ *cobra.Command, args []string) {
 		defer stop()
 		if err := fn(ctx, appCtx, args); err != nil {
-			appCtx.printer.Fatal(1, "error with the app")
+			var exitErr *ExitError
+			if errors.As(err, &exitErr) {
+				appCtx.printer.Fatalf(exitErr.Code, "%s\n", exitErr.Msg)
+			}
+			appCtx.printer.Fatalf(1, "error with the app: %v\n", err)
 		}
 	}
 }
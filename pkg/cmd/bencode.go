@@ -1,47 +1,201 @@
 package cmd
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
-	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
 
 	"github.com/anivanovic/gotit/pkg/bencode"
 )
 
+type bencodeFlags struct {
+	format string
+	binary string
+}
+
 func NewCommand(app *App) *cobra.Command {
+	f := &bencodeFlags{}
+	cmd := &cobra.Command{
+		Use:   "bencode <file> [path]",
+		Short: "Inspect bencoded file",
+		Long: `Decode file in bencode format, like torrent file or tracker response, and print it
+as human readable text, JSON or YAML. Use - to read from standard input.
+
+Optional path selects single value to print, for example info.files[0].path.
+Dictionary keys are separated with dots and list elements are selected with
+index in square brackets. Keys containing dots can be quoted: info["piece length"].
+
+Strings which are not printable text, like piece hashes, are printed encoded
+and prefixed with hex: or base64:.`,
+		Args: cobra.RangeArgs(1, 2),
+		Run: app.NewCmdRun(func(_ context.Context, appContext AppContext, args []string) error {
+			return runBencode(appContext, args, f)
+		}),
+	}
+	cmd.Flags().StringVarP(&f.format, "format", "f", "pretty", "Output format [pretty,json,yaml]")
+	cmd.Flags().StringVar(&f.binary, "binary", "hex", "Encoding of binary strings [hex,base64]")
+
+	cmd.AddCommand(newEncodeCommand(app))
+	return cmd
+}
+
+type encodeFlags struct {
+	format string
+	output string
+}
+
+func newEncodeCommand(app *App) *cobra.Command {
+	f := &encodeFlags{}
 	cmd := &cobra.Command{
-		Use:   "bencode <file>",
-		Short: "Inspect torrent file",
-		Long:  "Decode torrent file in bencode format and print human readable metadata about torrent",
-		Args:  cobra.ExactArgs(1),
-		Run:   app.NewCmdRun(run),
+		Use:   "encode <file>",
+		Short: "Encode JSON or YAML into bencode",
+		Long: `Read JSON or YAML file and write it in canonical bencode format. Use - to read
+from standard input.
+
+Strings prefixed with hex: or base64: are decoded into binary strings, so
+output of the bencode command can be edited and encoded back. Numbers must be
+integers, while booleans and null values are not supported.`,
+		Args: cobra.ExactArgs(1),
+		Run: app.NewCmdRun(func(_ context.Context, appContext AppContext, args []string) error {
+			return runEncode(appContext, args, f)
+		}),
 	}
+	cmd.Flags().StringVarP(&f.format, "format", "f", "", "Input format [json,yaml], detected from file extension if not set")
+	cmd.Flags().StringVarP(&f.output, "out", "o", "", "Output file, standard output if not set")
+
 	return cmd
 }
 
-func run(_ context.Context, appContext AppContext, args []string) error {
-	file := args[0]
-	f, err := os.Open(file)
+func runEncode(appContext AppContext, args []string, f *encodeFlags) error {
+	format := f.format
+	if format == "" {
+		format = "json"
+		if ext := filepath.Ext(args[0]); ext == ".yaml" || ext == ".yml" {
+			format = "yaml"
+		}
+	}
+
+	data, err := readInput(args[0])
 	if err != nil {
-		appContext.log.Fatal("open file", zap.Error(err))
+		return err
 	}
 
-	data, err := io.ReadAll(f)
+	var ben bencode.Bencode
+	switch format {
+	case "json":
+		ben, err = bencode.ReadJSON(bytes.NewReader(data))
+	case "yaml":
+		ben, err = bencode.ReadYAML(bytes.NewReader(data))
+	default:
+		return fmt.Errorf("unknown input format %q", format)
+	}
 	if err != nil {
-		appContext.log.Sugar().Fatalf("read file %s: %v", file, err)
+		return fmt.Errorf("read %s: %w", args[0], err)
 	}
 
-	torrentMetadata := bencode.Metainfo{}
-	if err := bencode.Unmarshal(data, &torrentMetadata); err != nil {
-		appContext.log.Fatal("parse torrent file", zap.Error(err))
+	if f.output == "" {
+		appContext.printer.Info(ben.Encode())
+		return nil
 	}
+	return os.WriteFile(f.output, []byte(ben.Encode()), 0o644)
+}
 
-	//appContext.printer.Info("Metainfo file:", file)
-	//appContext.printer.Info(torrentMetadata)
+func runBencode(appContext AppContext, args []string, f *bencodeFlags) error {
+	enc, err := bencode.ParseBinaryEncoding(f.binary)
+	if err != nil {
+		return err
+	}
 
-	// TODO: return errors instead
+	data, err := readInput(args[0])
+	if err != nil {
+		return err
+	}
+
+	ben, err := bencode.Parse(data)
+	if err != nil {
+		return fmt.Errorf("parse %s: %w", args[0], err)
+	}
+
+	if len(args) == 2 {
+		ben, err = bencode.Lookup(ben, args[1])
+		if err != nil {
+			return err
+		}
+	}
+
+	out := &strings.Builder{}
+	switch f.format {
+	case "pretty":
+		out.WriteString(bencode.Pretty(ben, enc))
+		out.WriteString("\n")
+	case "json":
+		if err := bencode.WriteJSON(out, ben, enc); err != nil {
+			return err
+		}
+	case "yaml":
+		yamlEnc := yaml.NewEncoder(out)
+		yamlEnc.SetIndent(2)
+		if err := yamlEnc.Encode(yamlNode(ben, enc)); err != nil {
+			return err
+		}
+		if err := yamlEnc.Close(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown output format %q", f.format)
+	}
+
+	appContext.printer.Info(out.String())
 	return nil
 }
+
+// readInput reads whole file, or standard input if name is -.
+func readInput(name string) ([]byte, error) {
+	if name == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("read file %s: %w", name, err)
+	}
+	return data, nil
+}
+
+// yamlNode converts bencode element into YAML node keeping order of
+// dictionary keys.
+func yamlNode(b bencode.Bencode, enc bencode.BinaryEncoding) *yaml.Node {
+	switch v := b.(type) {
+	case bencode.IntElement, bencode.BigIntElement:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: v.String()}
+	case bencode.StringElement:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: bencode.TextString(string(v), enc)}
+	case bencode.ListElement:
+		return yamlNode(&v, enc)
+	case *bencode.ListElement:
+		node := &yaml.Node{Kind: yaml.SequenceNode}
+		for _, el := range v.Value {
+			node.Content = append(node.Content, yamlNode(el, enc))
+		}
+		return node
+	case bencode.DictElement:
+		return yamlNode(&v, enc)
+	case *bencode.DictElement:
+		node := &yaml.Node{Kind: yaml.MappingNode}
+		v.Range(func(k string, el bencode.Bencode) bool {
+			key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: bencode.TextString(k, enc)}
+			node.Content = append(node.Content, key, yamlNode(el, enc))
+			return true
+		})
+		return node
+	default:
+		return &yaml.Node{Kind: yaml.ScalarNode, Value: b.String()}
+	}
+}
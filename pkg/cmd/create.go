@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"code.cloudfoundry.org/bytefmt"
+	"github.com/spf13/cobra"
+
+	"github.com/anivanovic/gotit/pkg/bencode"
+)
+
+type createFlags struct {
+	output      string
+	trackers    []string
+	webSeeds    []string
+	comment     string
+	createdBy   string
+	private     bool
+	pieceLength string
+	version     string
+	workers     int
+}
+
+func NewCreateCommand(app *App) *cobra.Command {
+	f := &createFlags{}
+	cmd := &cobra.Command{
+		Use:   "create <file|dir>",
+		Short: "Create torrent file",
+		Long: `Hash file or directory into pieces and write torrent file for it.
+
+Each --tracker flag adds new tier of trackers. Trackers of the same tier are
+separated with comma. Piece length is selected from content size if not set.`,
+		Args: cobra.ExactArgs(1),
+		Run: app.NewCmdRun(func(_ context.Context, appContext AppContext, args []string) error {
+			return runCreate(appContext, args, f)
+		}),
+	}
+	cmd.Flags().StringVarP(&f.output, "out", "o", "", "Output torrent file, <name>.torrent if not set")
+	cmd.Flags().StringArrayVarP(&f.trackers, "tracker", "t", nil, "Tracker tier, comma separated tracker urls")
+	cmd.Flags().StringArrayVarP(&f.webSeeds, "web-seed", "w", nil, "Web seed url")
+	cmd.Flags().StringVarP(&f.comment, "comment", "c", "", "Torrent comment")
+	cmd.Flags().StringVar(&f.createdBy, "created-by", "gotit/"+Version, "Torrent creator")
+	cmd.Flags().BoolVar(&f.private, "private", false, "Mark torrent private")
+	cmd.Flags().StringVar(&f.pieceLength, "piece-length", "", "Piece length, like 256K or 1M")
+	cmd.Flags().StringVar(&f.version, "meta-version", "v1", "Torrent metadata version [v1,v2,hybrid]")
+	cmd.Flags().IntVar(&f.workers, "workers", 0, "Number of hashing goroutines, number of CPUs if not set")
+
+	return cmd
+}
+
+func runCreate(appContext AppContext, args []string, f *createFlags) error {
+	opts := bencode.CreateOptions{
+		WebSeeds:  f.webSeeds,
+		Comment:   f.comment,
+		CreatedBy: f.createdBy,
+		Private:   f.private,
+		Workers:   f.workers,
+	}
+
+	switch f.version {
+	case "v1":
+		opts.Version = bencode.MetaV1
+	case "v2":
+		opts.Version = bencode.MetaV2
+	case "hybrid":
+		opts.Version = bencode.MetaHybrid
+	default:
+		return fmt.Errorf("unknown metadata version %q", f.version)
+	}
+
+	if f.pieceLength != "" {
+		length, err := bytefmt.ToBytes(f.pieceLength)
+		if err != nil {
+			return fmt.Errorf("invalid piece length %q: %w", f.pieceLength, err)
+		}
+		opts.PieceLength = int64(length)
+	}
+
+	opts.AnnounceList = parseTiers(f.trackers)
+
+	m, err := bencode.Create(args[0], opts)
+	if err != nil {
+		return err
+	}
+	data, err := bencode.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	output := f.output
+	if output == "" {
+		output = filepath.Base(filepath.Clean(args[0])) + ".torrent"
+	}
+	if err := os.WriteFile(output, data, 0o644); err != nil {
+		return err
+	}
+
+	appContext.printer.Infof("created %s with piece length %s\n", output, bytefmt.ByteSize(uint64(m.Info.PieceLength)))
+	if opts.Version != bencode.MetaV2 {
+		appContext.printer.Infof("info-hash: %x\n", m.Hash())
+	}
+	if m.IsV2() {
+		appContext.printer.Infof("info-hash v2: %x\n", m.HashV2())
+	}
+	return nil
+}
+
+// parseTiers returns tracker tiers from flag values holding comma
+// separated tracker urls.
+func parseTiers(tiers []string) [][]string {
+	var list [][]string
+	for _, tier := range tiers {
+		var urls []string
+		for _, url := range strings.Split(tier, ",") {
+			if url = strings.TrimSpace(url); url != "" {
+				urls = append(urls, url)
+			}
+		}
+		if len(urls) != 0 {
+			list = append(list, urls)
+		}
+	}
+	return list
+}
@@ -13,13 +13,16 @@ import (
 
 	"github.com/anivanovic/gotit/pkg/bencode"
 	"github.com/anivanovic/gotit/pkg/download"
+	"github.com/anivanovic/gotit/pkg/magnet"
+	"github.com/anivanovic/gotit/pkg/peer"
 	"github.com/anivanovic/gotit/pkg/torrent"
 )
 
 type flags struct {
-	output     string
-	peerNum    int
-	listenPort int
+	output      string
+	peerNum     int
+	listenPort  int
+	maxRequests int
 }
 
 func newFlags() *flags {
@@ -29,10 +32,14 @@ func newFlags() *flags {
 func NewDownloadCommand(app *App) *cobra.Command {
 	f := newFlags()
 	cmd := &cobra.Command{
-		Use:   "download -out <out_dir> <torrent_file>",
+		Use:   "download -out <out_dir> <torrent_file|magnet_link>",
 		Short: "Download torrent",
-		Long:  "Start download process for torrent file",
-		Args:  cobra.ExactArgs(1),
+		Long: `Start download process for torrent file or magnet link. Torrent metadata of magnet link is fetched from peers.
+
+Download continues from data already in output directory. Downloaded pieces are
+saved to <name>.resume file next to torrent data, and existing data is verified
+when resume file is missing or files changed since it was saved.`,
+		Args: cobra.ExactArgs(1),
 
 		Run: app.NewCmdRun(func(ctx context.Context, appContext AppContext, args []string) error {
 			return runDownload(ctx, appContext.log, args, f)
@@ -41,6 +48,7 @@ func NewDownloadCommand(app *App) *cobra.Command {
 	cmd.Flags().StringVarP(&f.output, "out", "o", "", "Torrent download output directory")
 	cmd.Flags().IntVarP(&f.peerNum, "num-peer", "n", 30, "Maximum number of peers to download torrent from")
 	cmd.Flags().IntVarP(&f.listenPort, "port", "p", 6666, "Port number on which to listen for other peers requests")
+	cmd.Flags().IntVarP(&f.maxRequests, "max-requests", "r", peer.DefaultMaxRequests, "Maximum number of block requests outstanding to a peer")
 	_ = cmd.MarkFlagRequired("out")
 
 	return cmd
@@ -58,27 +66,53 @@ func runDownload(ctx context.Context, l *zap.Logger, args []string, f *flags) er
 		return errors.New(fmt.Sprintf("not a directory: %s", outDir))
 	}
 
-	file, err := os.Open(torrentFile)
+	torrentMetadata, err := loadMetainfo(ctx, torrentFile, l, f)
 	if err != nil {
 		return err
 	}
 
-	data, err := io.ReadAll(file)
+	t, err := torrent.New(torrentMetadata, outDir, l)
 	if err != nil {
 		return err
 	}
+	mng := download.NewMng(t, l, f.peerNum, f.listenPort, f.maxRequests)
+	defer mng.Stop()
 
-	var torrentMetadata bencode.Metainfo
-	if err := bencode.Unmarshal(data, &torrentMetadata); err != nil {
-		return err
+	return mng.Download(ctx)
+}
+
+// loadMetainfo reads torrent file or, for magnet links, fetches torrent
+// metainfo from peers.
+func loadMetainfo(ctx context.Context, source string, l *zap.Logger, f *flags) (*bencode.Metainfo, error) {
+	if magnet.IsMagnet(source) {
+		m, err := magnet.Parse(source)
+		if err != nil {
+			return nil, err
+		}
+
+		l.Info("fetching torrent metadata from peers", zap.String("name", m.Name))
+		return download.FetchMetainfo(ctx, m, l, f.listenPort)
+	}
+
+	return readMetainfo(source)
+}
+
+// readMetainfo reads torrent file.
+func readMetainfo(path string) (*bencode.Metainfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
 	}
+	defer file.Close()
 
-	t, err := torrent.New(&torrentMetadata, outDir, l)
+	data, err := io.ReadAll(file)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	mng := download.NewMng(t, l, f.peerNum, f.listenPort)
-	defer mng.Stop()
 
-	return mng.Download(ctx)
+	var torrentMetadata bencode.Metainfo
+	if err := bencode.Unmarshal(data, &torrentMetadata); err != nil {
+		return nil, err
+	}
+	return &torrentMetadata, nil
 }
@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/anivanovic/gotit/pkg/bencode"
+)
+
+type editFlags struct {
+	output      string
+	announce    string
+	trackers    []string
+	webSeeds    []string
+	addWebSeeds []string
+	comment     string
+	createdBy   string
+}
+
+func NewEditCommand(app *App) *cobra.Command {
+	f := &editFlags{}
+	cmd := &cobra.Command{
+		Use:   "edit <torrent_file>",
+		Short: "Edit trackers, web seeds and comment of torrent file",
+		Long: `Change fields of torrent file outside of info dictionary. Info dictionary is
+kept as is, so info-hash of the torrent does not change.
+
+Each --tracker flag adds new tier of trackers, replacing current trackers.
+Trackers of the same tier are separated with comma. Announce is set to the
+first tracker, unless --announce is set. Empty flag value removes the field,
+while empty --tracker removes announce as well.`,
+		Args: cobra.ExactArgs(1),
+	}
+	cmd.Run = app.NewCmdRun(func(_ context.Context, appContext AppContext, args []string) error {
+		return runEdit(cmd, appContext, args, f)
+	})
+	cmd.Flags().StringVarP(&f.output, "out", "o", "", "Output torrent file, torrent file is overwritten if not set")
+	cmd.Flags().StringVar(&f.announce, "announce", "", "Announce tracker url")
+	cmd.Flags().StringArrayVarP(&f.trackers, "tracker", "t", nil, "Tracker tier, comma separated tracker urls")
+	cmd.Flags().StringArrayVarP(&f.webSeeds, "web-seed", "w", nil, "Web seed url, replacing current web seeds")
+	cmd.Flags().StringArrayVar(&f.addWebSeeds, "add-web-seed", nil, "Web seed url, added to current web seeds")
+	cmd.Flags().StringVarP(&f.comment, "comment", "c", "", "Torrent comment")
+	cmd.Flags().StringVar(&f.createdBy, "created-by", "", "Torrent creator")
+
+	return cmd
+}
+
+func runEdit(cmd *cobra.Command, appContext AppContext, args []string, f *editFlags) error {
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	m := &bencode.Metainfo{}
+	if err := bencode.Unmarshal(data, m); err != nil {
+		return err
+	}
+
+	flags := cmd.Flags()
+	edit := bencode.MetainfoEdit{}
+	if flags.Changed("tracker") {
+		tiers := parseTiers(f.trackers)
+		edit.AnnounceList = &tiers
+		if len(tiers) != 0 {
+			edit.Announce = &tiers[0][0]
+		}
+	}
+	if flags.Changed("announce") {
+		edit.Announce = &f.announce
+	}
+	if flags.Changed("web-seed") || flags.Changed("add-web-seed") {
+		seeds := m.UrlList
+		if flags.Changed("web-seed") {
+			seeds = nonEmpty(f.webSeeds)
+		}
+		seeds = append(seeds[:len(seeds):len(seeds)], nonEmpty(f.addWebSeeds)...)
+		edit.UrlList = &seeds
+	}
+	if flags.Changed("comment") {
+		edit.Comment = &f.comment
+	}
+	if flags.Changed("created-by") {
+		edit.CreatedBy = &f.createdBy
+	}
+	if edit == (bencode.MetainfoEdit{}) {
+		return errors.New("nothing to edit")
+	}
+
+	edited, err := bencode.EditMetainfo(data, edit)
+	if err != nil {
+		return err
+	}
+
+	output := f.output
+	if output == "" {
+		output = args[0]
+	}
+	if err := os.WriteFile(output, edited, 0o644); err != nil {
+		return err
+	}
+
+	appContext.printer.Infof("written %s\n", output)
+	appContext.printer.Infof("info-hash: %x\n", m.Hash())
+	return nil
+}
+
+// nonEmpty returns values which are not empty strings.
+func nonEmpty(values []string) []string {
+	var result []string
+	for _, v := range values {
+		if v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
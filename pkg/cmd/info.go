@@ -0,0 +1,245 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"code.cloudfoundry.org/bytefmt"
+	"github.com/spf13/cobra"
+
+	"github.com/anivanovic/gotit/pkg/bencode"
+	"github.com/anivanovic/gotit/pkg/magnet"
+)
+
+type infoFlags struct {
+	json bool
+}
+
+// torrentInfo is torrent metadata printed by info command.
+type torrentInfo struct {
+	Name            string     `json:"name"`
+	InfoHash        string     `json:"infoHash,omitempty"`
+	InfoHashV2      string     `json:"infoHashV2,omitempty"`
+	MetaVersion     string     `json:"metaVersion"`
+	Private         bool       `json:"private"`
+	Length          int64      `json:"length"`
+	PieceLength     int64      `json:"pieceLength"`
+	Pieces          int64      `json:"pieces"`
+	LastPieceLength int64      `json:"lastPieceLength"`
+	Files           []fileInfo `json:"files"`
+	Trackers        [][]string `json:"trackers"`
+	WebSeeds        []string   `json:"webSeeds,omitempty"`
+	HttpSeeds       []string   `json:"httpSeeds,omitempty"`
+	Comment         string     `json:"comment,omitempty"`
+	CreatedBy       string     `json:"createdBy,omitempty"`
+	CreationDate    *time.Time `json:"creationDate,omitempty"`
+}
+
+// fileInfo is torrent file with its offset in torrent data. Padding files
+// are not listed, but are counted in offsets.
+type fileInfo struct {
+	Path   string `json:"path"`
+	Length int64  `json:"length"`
+	Offset int64  `json:"offset"`
+}
+
+func NewInfoCommand(app *App) *cobra.Command {
+	f := &infoFlags{}
+	cmd := &cobra.Command{
+		Use:   "info <torrent_file>",
+		Short: "Print torrent file metadata",
+		Long: `Print info-hashes, files, pieces and trackers of torrent file. File offsets are
+positions of files in torrent data, including padding files.`,
+		Args: cobra.ExactArgs(1),
+		Run: app.NewCmdRun(func(_ context.Context, appContext AppContext, args []string) error {
+			return runInfo(appContext, args, f)
+		}),
+	}
+	cmd.Flags().BoolVar(&f.json, "json", false, "Print metadata as JSON")
+
+	return cmd
+}
+
+func NewMagnetCommand(app *App) *cobra.Command {
+	return &cobra.Command{
+		Use:   "magnet <torrent_file>",
+		Short: "Print magnet link of torrent file",
+		Args:  cobra.ExactArgs(1),
+		Run: app.NewCmdRun(func(_ context.Context, appContext AppContext, args []string) error {
+			m, err := readMetainfo(args[0])
+			if err != nil {
+				return err
+			}
+			appContext.printer.Infof("%s\n", magnet.FromMetainfo(m))
+			return nil
+		}),
+	}
+}
+
+func runInfo(appContext AppContext, args []string, f *infoFlags) error {
+	m, err := readMetainfo(args[0])
+	if err != nil {
+		return err
+	}
+
+	info := newTorrentInfo(m)
+	if f.json {
+		data, err := json.MarshalIndent(info, "", "  ")
+		if err != nil {
+			return err
+		}
+		appContext.printer.Infof("%s\n", data)
+		return nil
+	}
+
+	appContext.printer.Info(info.String())
+	return nil
+}
+
+func newTorrentInfo(m *bencode.Metainfo) *torrentInfo {
+	info := &torrentInfo{
+		Name:        m.Info.Name,
+		MetaVersion: "v1",
+		Private:     m.IsPrivate(),
+		PieceLength: m.Info.PieceLength,
+		Trackers:    m.AnnounceList,
+		WebSeeds:    m.UrlList,
+		HttpSeeds:   m.HttpSeeds,
+		Comment:     m.Comment,
+		CreatedBy:   m.CreatedBy,
+	}
+	if len(info.Trackers) == 0 && m.Announce != "" {
+		info.Trackers = [][]string{{m.Announce}}
+	}
+	if m.CreationDate != 0 {
+		date := time.Unix(m.CreationDate, 0)
+		info.CreationDate = &date
+	}
+
+	switch {
+	case m.IsHybrid():
+		info.MetaVersion = "hybrid"
+	case m.IsV2():
+		info.MetaVersion = "v2"
+	}
+	if !m.IsV2() || m.IsHybrid() {
+		info.InfoHash = hex.EncodeToString(m.Hash())
+	}
+	if m.IsV2() {
+		info.InfoHashV2 = hex.EncodeToString(m.HashV2())
+	}
+
+	if m.IsV2() && !m.IsHybrid() {
+		info.v2Files(m)
+	} else {
+		info.v1Files(m)
+	}
+	return info
+}
+
+// v1Files sets files of v1 and hybrid torrents, which are stored one
+// after another in torrent data.
+func (info *torrentInfo) v1Files(m *bencode.Metainfo) {
+	var offset int64
+	if len(m.Info.Files) == 0 {
+		info.Files = []fileInfo{{Path: m.Info.Name, Length: m.Info.Length}}
+		offset = m.Info.Length
+	}
+	for _, f := range m.Info.Files {
+		if !f.IsPad() {
+			info.Files = append(info.Files, fileInfo{Path: f.FilePath(), Length: int64(f.Length), Offset: offset})
+			info.Length += int64(f.Length)
+		}
+		offset += int64(f.Length)
+	}
+	if len(m.Info.Files) == 0 {
+		info.Length = m.Info.Length
+	}
+
+	info.Pieces = int64(len(m.Info.Pieces) / sha1.Size)
+	if info.Pieces > 0 {
+		info.LastPieceLength = offset - (info.Pieces-1)*info.PieceLength
+	}
+}
+
+// v2Files sets files of v2 torrents, where each file starts at piece
+// boundary.
+func (info *torrentInfo) v2Files(m *bencode.Metainfo) {
+	var offset int64
+	for _, f := range m.Info.FileTree {
+		info.Files = append(info.Files, fileInfo{Path: f.FilePath(), Length: f.Length, Offset: offset})
+		info.Length += f.Length
+		if f.Length == 0 {
+			continue
+		}
+
+		pieces := (f.Length + info.PieceLength - 1) / info.PieceLength
+		info.Pieces += pieces
+		offset += pieces * info.PieceLength
+		info.LastPieceLength = f.Length - (pieces-1)*info.PieceLength
+	}
+}
+
+func (info *torrentInfo) String() string {
+	b := &strings.Builder{}
+	w := tabwriter.NewWriter(b, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "name:\t%s\n", info.Name)
+	if info.InfoHash != "" {
+		fmt.Fprintf(w, "info-hash:\t%s\n", info.InfoHash)
+	}
+	if info.InfoHashV2 != "" {
+		fmt.Fprintf(w, "info-hash v2:\t%s\n", info.InfoHashV2)
+	}
+	fmt.Fprintf(w, "meta version:\t%s\n", info.MetaVersion)
+	fmt.Fprintf(w, "private:\t%t\n", info.Private)
+	fmt.Fprintf(w, "size:\t%s (%d bytes)\n", bytefmt.ByteSize(uint64(info.Length)), info.Length)
+	fmt.Fprintf(w, "pieces:\t%d x %s, last piece %s\n",
+		info.Pieces,
+		bytefmt.ByteSize(uint64(info.PieceLength)),
+		bytefmt.ByteSize(uint64(info.LastPieceLength)))
+	if info.Comment != "" {
+		fmt.Fprintf(w, "comment:\t%s\n", info.Comment)
+	}
+	if info.CreatedBy != "" {
+		fmt.Fprintf(w, "created by:\t%s\n", info.CreatedBy)
+	}
+	if info.CreationDate != nil {
+		fmt.Fprintf(w, "creation date:\t%s\n", info.CreationDate.Format(time.DateTime))
+	}
+	_ = w.Flush()
+
+	if len(info.Trackers) != 0 {
+		b.WriteString("trackers:\n")
+		for i, tier := range info.Trackers {
+			fmt.Fprintf(b, "  tier %d: %s\n", i+1, strings.Join(tier, ", "))
+		}
+	}
+	if len(info.WebSeeds) != 0 {
+		b.WriteString("web seeds:\n")
+		for _, url := range info.WebSeeds {
+			fmt.Fprintf(b, "  %s\n", url)
+		}
+	}
+	if len(info.HttpSeeds) != 0 {
+		b.WriteString("http seeds:\n")
+		for _, url := range info.HttpSeeds {
+			fmt.Fprintf(b, "  %s\n", url)
+		}
+	}
+
+	b.WriteString("files:\n")
+	w = tabwriter.NewWriter(b, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "  offset\tsize\tpath\n")
+	for _, f := range info.Files {
+		fmt.Fprintf(w, "  %d\t%s\t%s\n", f.Offset, bytefmt.ByteSize(uint64(f.Length)), f.Path)
+	}
+	_ = w.Flush()
+
+	return b.String()
+}
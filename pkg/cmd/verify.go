@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/anivanovic/gotit/pkg/torrent"
+)
+
+// exitIncomplete is verify command exit code when data is not complete.
+const exitIncomplete = 2
+
+type verifyFlags struct {
+	output string
+}
+
+func NewVerifyCommand(app *App) *cobra.Command {
+	f := &verifyFlags{}
+	cmd := &cobra.Command{
+		Use:   "verify -out <out_dir> <torrent_file>",
+		Short: "Verify downloaded torrent data",
+		Long: `Read torrent files from output directory and check all pieces against torrent
+piece hashes. Prints completion of every file.
+
+Exit code is 0 when data is complete, 2 when some pieces are missing or
+corrupted and 1 on any other error.`,
+		Args: cobra.ExactArgs(1),
+		Run: app.NewCmdRun(func(ctx context.Context, appContext AppContext, args []string) error {
+			return runVerify(ctx, appContext, args, f)
+		}),
+	}
+	cmd.Flags().StringVarP(&f.output, "out", "o", "", "Torrent download output directory")
+	_ = cmd.MarkFlagRequired("out")
+
+	return cmd
+}
+
+func runVerify(ctx context.Context, appContext AppContext, args []string, f *verifyFlags) error {
+	m, err := readMetainfo(args[0])
+	if err != nil {
+		return err
+	}
+
+	t, err := torrent.Load(m, f.output, appContext.log)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	result, err := t.Verify(ctx)
+	if err != nil {
+		return err
+	}
+
+	out := &strings.Builder{}
+	for _, file := range result.Files {
+		switch {
+		case file.Missing:
+			fmt.Fprintf(out, "missing  %s\n", file.Path)
+		case file.Pieces == 0:
+			fmt.Fprintf(out, "%6.1f%%  %s\n", 100.0, file.Path)
+		default:
+			fmt.Fprintf(out, "%6.1f%%  %s\n", 100*float64(file.Verified)/float64(file.Pieces), file.Path)
+		}
+	}
+	fmt.Fprintf(out, "%d of %d pieces valid\n", result.Have.Count(), t.PiecesNum)
+	appContext.printer.Info(out.String())
+
+	if !result.Complete() {
+		return &ExitError{Code: exitIncomplete, Msg: "torrent data is incomplete"}
+	}
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"net/netip"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/anivanovic/gotit"
@@ -13,16 +14,21 @@ import (
 	"github.com/anivanovic/gotit/pkg/stats"
 	"github.com/anivanovic/gotit/pkg/torrent"
 	"github.com/anivanovic/gotit/pkg/tracker"
-	"github.com/anivanovic/gotit/pkg/util"
+	"github.com/anivanovic/gotit/pkg/webseed"
 
 	"github.com/avast/retry-go"
 	"go.uber.org/zap"
 )
 
+// resumeInterval is time between saves of resume file.
+const resumeInterval = time.Minute
+
 type Manager struct {
 	logger     *zap.Logger
 	peerNum    int
 	listenPort int
+	// maxRequests limits block requests outstanding to a peer
+	maxRequests int
 
 	torrent         *torrent.Torrent
 	torrentStatus   *stats.Stats
@@ -30,20 +36,31 @@ type Manager struct {
 
 	poolMu   sync.Mutex
 	peerPool map[string]*peer.Peer
+	// piecesQueue holds block requests peers failed to download, shared
+	// so they are requested again from any peer
+	piecesQueue *torrent.PiecesQueue
+	// picker chooses pieces downloaded from peers and web seeds
+	picker peer.PiecePicker
 
 	cancelCtx context.CancelFunc
 	wg        *sync.WaitGroup
+	// resumed is set once download state is restored, so state is not
+	// saved before it is known
+	resumed atomic.Bool
 }
 
-func NewMng(torrent *torrent.Torrent, logger *zap.Logger, peerNum, listenPort int) *Manager {
-	s := stats.NewStats(uint64(torrent.Length))
+func NewMng(t *torrent.Torrent, logger *zap.Logger, peerNum, listenPort, maxRequests int) *Manager {
+	s := stats.NewStats(uint64(t.Length))
 	pp := stats.NewProgressPrinter(s)
 	return &Manager{
 		logger:          logger,
-		torrent:         torrent,
+		torrent:         t,
 		peerPool:        make(map[string]*peer.Peer, 100),
+		piecesQueue:     torrent.NewPiecesQueue(),
+		picker:          torrent.NewRarestFirst(t),
 		peerNum:         peerNum,
 		listenPort:      listenPort,
+		maxRequests:     maxRequests,
 		poolMu:          sync.Mutex{},
 		wg:              &sync.WaitGroup{},
 		torrentStatus:   s,
@@ -54,10 +71,19 @@ func NewMng(torrent *torrent.Torrent, logger *zap.Logger, peerNum, listenPort in
 func (m *Manager) Download(ctx context.Context) error {
 	ctx, m.cancelCtx = context.WithCancel(ctx)
 
-	pieceCh := make(chan *util.PeerMessage, 1024)
+	counters, err := m.torrent.Resume(ctx)
+	if err != nil {
+		return err
+	}
+	m.torrentStatus.Restore(counters.Downloaded, counters.Uploaded, uint64(m.torrent.Left()))
+	m.resumed.Store(true)
+
+	pieceCh := make(chan *torrent.Block, 1024)
 
 	m.initStatisticsPrinting(ctx)
+	m.initResumeSaving(ctx)
 	m.getIps(ctx, pieceCh)
+	m.initWebSeeds(ctx, pieceCh)
 
 	go m.torrent.WritePiece(pieceCh, m.torrentStatus)
 
@@ -69,15 +95,49 @@ func (m *Manager) Download(ctx context.Context) error {
 
 // announce to all trackers from torrent file and gather
 // peers ip addresses
-func (m *Manager) getIps(ctx context.Context, pieceCh chan *util.PeerMessage) {
+func (m *Manager) getIps(ctx context.Context, pieceCh chan *torrent.Block) {
 	m.logger.Info("trackers", zap.Any("urls", m.torrent.Trackers))
+	if m.torrent.Private {
+		m.logger.Info("private torrent, peers are accepted only from trackers")
+	}
 
 	for url := range m.torrent.Trackers {
 		go m.runTracker(ctx, url, pieceCh)
 	}
 }
 
-func (m *Manager) runTracker(ctx context.Context, url string, pieceCh chan *util.PeerMessage) error {
+// initWebSeeds starts downloading pieces from torrent web seeds, along
+// with peers.
+func (m *Manager) initWebSeeds(ctx context.Context, pieceCh chan *torrent.Block) {
+	var seeds []*webseed.Seed
+	for _, url := range m.torrent.WebSeeds {
+		s, err := webseed.New(url, m.torrent, m.picker, pieceCh, m.logger)
+		if err != nil {
+			m.logger.Warn("skipping web seed", zap.String("url", url), zap.Error(err))
+			continue
+		}
+		seeds = append(seeds, s)
+	}
+	for _, url := range m.torrent.HttpSeeds {
+		s, err := webseed.NewHttpSeed(url, m.torrent, m.picker, pieceCh, m.logger)
+		if err != nil {
+			m.logger.Warn("skipping http seed", zap.String("url", url), zap.Error(err))
+			continue
+		}
+		seeds = append(seeds, s)
+	}
+
+	for _, s := range seeds {
+		m.logger.Info("downloading from web seed", zap.String("url", s.Url()))
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			s.Run(ctx)
+		}()
+	}
+}
+
+func (m *Manager) runTracker(ctx context.Context, url string, pieceCh chan *torrent.Block) error {
 	tracker, err := tracker.New(url, m.logger)
 	if err != nil {
 		return err
@@ -106,10 +166,9 @@ func (m *Manager) runTracker(ctx context.Context, url string, pieceCh chan *util
 	}
 }
 
-func (m *Manager) initPeers(ctx context.Context, ips []netip.AddrPort, pieceCh chan *util.PeerMessage) {
-	pq := torrent.NewPiecesQueue()
+func (m *Manager) initPeers(ctx context.Context, ips []netip.AddrPort, pieceCh chan *torrent.Block) {
 	for _, ip := range ips {
-		p := peer.NewPeer(ip, m.torrent, pq, pieceCh, m.logger)
+		p := peer.NewPeer(ip, m.torrent, m.picker, m.piecesQueue, pieceCh, m.maxRequests, m.logger)
 		if m.AddPeer(p) {
 			m.startPeerDownload(ctx, p)
 		}
@@ -191,11 +250,40 @@ func (m *Manager) initStatisticsPrinting(ctx context.Context) {
 	}()
 }
 
+// initResumeSaving periodically saves download state, so it is not lost
+// if client is killed.
+func (m *Manager) initResumeSaving(ctx context.Context) {
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(resumeInterval):
+				m.saveResume()
+			}
+		}
+	}()
+}
+
+func (m *Manager) saveResume() {
+	if !m.resumed.Load() {
+		return
+	}
+	err := m.torrent.SaveResume(torrent.Counters{
+		Downloaded: m.torrentStatus.Download(),
+		Uploaded:   m.torrentStatus.Upload(),
+	})
+	if err != nil {
+		m.logger.Error("saving resume file", zap.Error(err))
+	}
+}
+
 func (m *Manager) Stop() {
 	if m.peerPool == nil {
 		return
 	}
 	m.cancelCtx()
+	m.saveResume()
 
 	m.poolMu.Lock()
 	defer m.poolMu.Unlock()
@@ -0,0 +1,183 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/anivanovic/gotit"
+	"github.com/anivanovic/gotit/pkg/bencode"
+	"github.com/anivanovic/gotit/pkg/magnet"
+	"github.com/anivanovic/gotit/pkg/peer"
+	"github.com/anivanovic/gotit/pkg/tracker"
+)
+
+// metadataWorkers is number of peers metadata is fetched from at once.
+const metadataWorkers = 8
+
+var (
+	ErrNoMetadata  = errors.New("no peer sent torrent metadata")
+	ErrPrivatePeer = errors.New("private torrent metadata from peer not returned by tracker")
+)
+
+// metadataPeer is peer address with its source, magnet link or tracker.
+type metadataPeer struct {
+	addr        netip.AddrPort
+	fromTracker bool
+}
+
+// FetchMetainfo finds peers of the magnet link torrent, using its trackers
+// and peer addresses, and downloads torrent metainfo from them.
+func FetchMetainfo(ctx context.Context, m *magnet.Magnet, logger *zap.Logger, listenPort int) (*bencode.Metainfo, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	peers := make(chan metadataPeer, 100)
+	go findPeers(ctx, m, logger, listenPort, peers)
+
+	result := make(chan *bencode.Metainfo, 1)
+	wg := &sync.WaitGroup{}
+	seenMu := &sync.Mutex{}
+	// peer is tried again when returned by tracker after magnet link, as
+	// private torrent metadata is accepted only from tracker peers
+	seen := make(map[metadataPeer]bool)
+	for i := 0; i < metadataWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range peers {
+				seenMu.Lock()
+				tried := seen[p]
+				seen[p] = true
+				seenMu.Unlock()
+				if tried {
+					continue
+				}
+
+				info, err := peer.FetchMetadata(ctx, p.addr, m.SwarmHash(), logger)
+				if err != nil {
+					logger.Debug("fetching metadata failed",
+						zap.Stringer("ip", p.addr),
+						zap.Error(err))
+					continue
+				}
+				metainfo, err := peerMetainfo(m, info, p.fromTracker)
+				if err != nil {
+					logger.Debug("invalid metadata",
+						zap.Stringer("ip", p.addr),
+						zap.Error(err))
+					continue
+				}
+
+				select {
+				case result <- metainfo:
+					cancel()
+				default:
+				}
+				return
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case metainfo := <-result:
+		return metainfo, nil
+	case <-done:
+		select {
+		case metainfo := <-result:
+			return metainfo, nil
+		default:
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return nil, ErrNoMetadata
+	}
+}
+
+// peerMetainfo returns metainfo of the magnet link from info dictionary
+// sent by peer. Private torrents (BEP 27) accept metadata only from peers
+// returned by trackers, as whether torrent is private is not known before
+// its metadata is fetched.
+func peerMetainfo(m *magnet.Magnet, info []byte, fromTracker bool) (*bencode.Metainfo, error) {
+	metainfo, err := m.Metainfo(info)
+	if err != nil {
+		return nil, err
+	}
+	if metainfo.IsPrivate() && !fromTracker {
+		return nil, ErrPrivatePeer
+	}
+	return metainfo, nil
+}
+
+// findPeers sends magnet link peers and peers returned by its trackers to
+// peers channel, closing it when done.
+func findPeers(ctx context.Context, m *magnet.Magnet, logger *zap.Logger, listenPort int, peers chan<- metadataPeer) {
+	defer close(peers)
+
+	send := func(p metadataPeer) bool {
+		select {
+		case peers <- p:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	for _, p := range m.Peers {
+		addr, err := net.ResolveTCPAddr("tcp", p)
+		if err != nil {
+			logger.Warn("invalid magnet peer address", zap.String("addr", p), zap.Error(err))
+			continue
+		}
+		if !send(metadataPeer{addr: addr.AddrPort()}) {
+			return
+		}
+	}
+
+	wg := &sync.WaitGroup{}
+	for _, url := range m.Trackers {
+		wg.Add(1)
+		go func(url string) {
+			defer wg.Done()
+			ips, err := announceMagnet(ctx, url, m, logger, listenPort)
+			if err != nil {
+				logger.Debug("tracker announce failed",
+					zap.String("url", url),
+					zap.Error(err))
+				return
+			}
+			logger.Sugar().With("url", url).Infof("tracker sent %d peers", len(ips))
+			for _, ip := range ips {
+				if !send(metadataPeer{addr: ip, fromTracker: true}) {
+					return
+				}
+			}
+		}(url)
+	}
+	wg.Wait()
+}
+
+func announceMagnet(ctx context.Context, url string, m *magnet.Magnet, logger *zap.Logger, listenPort int) ([]netip.AddrPort, error) {
+	t, err := tracker.New(url, logger)
+	if err != nil {
+		return nil, err
+	}
+	defer t.Close()
+
+	return t.Announce(ctx, string(m.SwarmHash()), &gotit.AnnounceData{
+		// torrent size is not known until metadata is fetched
+		Left: 1,
+		Port: listenPort,
+	})
+}
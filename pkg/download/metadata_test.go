@@ -0,0 +1,49 @@
+package download
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anivanovic/gotit/pkg/bencode"
+	"github.com/anivanovic/gotit/pkg/magnet"
+)
+
+func createMetainfo(t *testing.T, private bool) *bencode.Metainfo {
+	file := filepath.Join(t.TempDir(), "data")
+	require.NoError(t, os.WriteFile(file, []byte("torrent data"), 0o644))
+
+	m, err := bencode.Create(file, bencode.CreateOptions{
+		AnnounceList: [][]string{{"udp://tracker"}},
+		Private:      private,
+	})
+	require.NoError(t, err)
+	return m
+}
+
+func TestPeerMetainfo_Private(t *testing.T) {
+	m := createMetainfo(t, true)
+	link := magnet.FromMetainfo(m)
+
+	_, err := peerMetainfo(link, m.InfoDictRaw, false)
+	assert.ErrorIs(t, err, ErrPrivatePeer)
+
+	metainfo, err := peerMetainfo(link, m.InfoDictRaw, true)
+	require.NoError(t, err)
+	assert.True(t, metainfo.IsPrivate())
+}
+
+func TestPeerMetainfo_Public(t *testing.T) {
+	m := createMetainfo(t, false)
+	link := magnet.FromMetainfo(m)
+
+	metainfo, err := peerMetainfo(link, m.InfoDictRaw, false)
+	require.NoError(t, err)
+	assert.Equal(t, m.Hash(), metainfo.Hash())
+
+	_, err = peerMetainfo(link, []byte("d4:name1:xe"), true)
+	assert.ErrorIs(t, err, magnet.ErrHashMismatch)
+}
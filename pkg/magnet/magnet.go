@@ -0,0 +1,226 @@
+package magnet
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/anivanovic/gotit/pkg/bencode"
+)
+
+var (
+	ErrInvalidMagnet = errors.New("magnet: invalid magnet link")
+	ErrHashMismatch  = errors.New("magnet: info dictionary does not match info hash")
+	// ErrPieceLayersMissing is returned for v2 only torrents, which files
+	// span multiple pieces. Their piece hashes are stored in piece layers
+	// outside of info dictionary and are not fetched with metadata.
+	ErrPieceLayersMissing = errors.New("magnet: v2 torrent piece layers are not available")
+)
+
+const (
+	btihPrefix = "urn:btih:"
+	btmhPrefix = "urn:btmh:"
+	// sha256Multihash prefixes v2 info hash in btmh exact topic, with
+	// SHA-256 function code and 32 bytes digest length.
+	sha256Multihash = "1220"
+)
+
+// Magnet holds data of magnet link (BEP 9) identifying torrent by its
+// info hash.
+type Magnet struct {
+	// InfoHash is SHA-1 hash of torrent info dictionary.
+	InfoHash []byte
+	// InfoHashV2 is SHA-256 hash of v2 torrent info dictionary (BEP 52).
+	InfoHashV2 []byte
+	// Name is display name of the torrent (dn).
+	Name string
+	// Trackers holds tracker urls (tr).
+	Trackers []string
+	// WebSeeds holds web seed urls (ws).
+	WebSeeds []string
+	// Peers holds addresses of peers in host:port form (x.pe).
+	Peers []string
+}
+
+// IsMagnet reports whether s looks like magnet link.
+func IsMagnet(s string) bool {
+	return strings.HasPrefix(s, "magnet:")
+}
+
+// Parse parses magnet link. Exact topic must be BitTorrent info hash in
+// hex or base32 encoding, or v2 info hash in SHA-256 multihash form.
+func Parse(uri string) (*Magnet, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMagnet, err)
+	}
+	if u.Scheme != "magnet" {
+		return nil, fmt.Errorf("%w: unexpected scheme %q", ErrInvalidMagnet, u.Scheme)
+	}
+
+	query, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMagnet, err)
+	}
+
+	m := &Magnet{
+		Name:     query.Get("dn"),
+		Trackers: query["tr"],
+		WebSeeds: query["ws"],
+		Peers:    query["x.pe"],
+	}
+	for _, xt := range query["xt"] {
+		switch {
+		case strings.HasPrefix(strings.ToLower(xt), btihPrefix) && m.InfoHash == nil:
+			m.InfoHash, err = decodeInfoHash(xt[len(btihPrefix):])
+		case strings.HasPrefix(strings.ToLower(xt), btmhPrefix) && m.InfoHashV2 == nil:
+			m.InfoHashV2, err = decodeMultihash(xt[len(btmhPrefix):])
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if m.InfoHash == nil && m.InfoHashV2 == nil {
+		return nil, fmt.Errorf("%w: missing %s or %s exact topic", ErrInvalidMagnet, btihPrefix, btmhPrefix)
+	}
+
+	return m, nil
+}
+
+func decodeInfoHash(s string) ([]byte, error) {
+	var (
+		hash []byte
+		err  error
+	)
+	switch len(s) {
+	case 40:
+		hash, err = hex.DecodeString(s)
+	case 32:
+		hash, err = base32.StdEncoding.DecodeString(strings.ToUpper(s))
+	default:
+		return nil, fmt.Errorf("%w: info hash %q has invalid length", ErrInvalidMagnet, s)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: info hash %q: %v", ErrInvalidMagnet, s, err)
+	}
+	return hash, nil
+}
+
+func decodeMultihash(s string) ([]byte, error) {
+	if len(s) != len(sha256Multihash)+2*sha256.Size || !strings.HasPrefix(s, sha256Multihash) {
+		return nil, fmt.Errorf("%w: info hash %q is not SHA-256 multihash", ErrInvalidMagnet, s)
+	}
+	hash, err := hex.DecodeString(s[len(sha256Multihash):])
+	if err != nil {
+		return nil, fmt.Errorf("%w: info hash %q: %v", ErrInvalidMagnet, s, err)
+	}
+	return hash, nil
+}
+
+// SwarmHash returns info hash used to find peers and fetch metadata from
+// them. It is v1 info hash when known, otherwise v2 info hash truncated
+// to 20 bytes (BEP 52).
+func (m *Magnet) SwarmHash() []byte {
+	if m.InfoHash != nil {
+		return m.InfoHash
+	}
+	return m.InfoHashV2[:sha1.Size]
+}
+
+// FromMetainfo returns magnet link of the torrent. Trackers of all tiers
+// and web seeds are included in the link.
+func FromMetainfo(metainfo *bencode.Metainfo) *Magnet {
+	m := &Magnet{
+		Name:     metainfo.Info.Name,
+		WebSeeds: metainfo.UrlList,
+	}
+	if !metainfo.IsV2() || metainfo.IsHybrid() {
+		m.InfoHash = metainfo.Hash()
+	}
+	if metainfo.IsV2() {
+		m.InfoHashV2 = metainfo.HashV2()
+	}
+
+	seen := make(map[string]bool)
+	add := func(tr string) {
+		if tr != "" && !seen[tr] {
+			seen[tr] = true
+			m.Trackers = append(m.Trackers, tr)
+		}
+	}
+	add(metainfo.Announce)
+	for _, tier := range metainfo.AnnounceList {
+		for _, tr := range tier {
+			add(tr)
+		}
+	}
+	return m
+}
+
+// String returns magnet link URI. Info hashes are hex encoded.
+func (m *Magnet) String() string {
+	var params []string
+	if m.InfoHash != nil {
+		params = append(params, "xt="+btihPrefix+hex.EncodeToString(m.InfoHash))
+	}
+	if m.InfoHashV2 != nil {
+		params = append(params, "xt="+btmhPrefix+sha256Multihash+hex.EncodeToString(m.InfoHashV2))
+	}
+	if m.Name != "" {
+		params = append(params, "dn="+url.QueryEscape(m.Name))
+	}
+	for _, tr := range m.Trackers {
+		params = append(params, "tr="+url.QueryEscape(tr))
+	}
+	for _, ws := range m.WebSeeds {
+		params = append(params, "ws="+url.QueryEscape(ws))
+	}
+	for _, pe := range m.Peers {
+		params = append(params, "x.pe="+url.QueryEscape(pe))
+	}
+	return "magnet:?" + strings.Join(params, "&")
+}
+
+// Metainfo returns torrent metainfo created from info dictionary fetched
+// from peers. Info dictionary is checked against all info hashes of the
+// link. Trackers and web seeds from the magnet link are used as torrent
+// trackers and web seeds.
+func (m *Magnet) Metainfo(info []byte) (*bencode.Metainfo, error) {
+	if m.InfoHash != nil {
+		hash := sha1.Sum(info)
+		if string(hash[:]) != string(m.InfoHash) {
+			return nil, ErrHashMismatch
+		}
+	}
+	if m.InfoHashV2 != nil {
+		hash := sha256.Sum256(info)
+		if string(hash[:]) != string(m.InfoHashV2) {
+			return nil, ErrHashMismatch
+		}
+	}
+
+	metainfo := &bencode.Metainfo{InfoDictRaw: info, UrlList: m.WebSeeds}
+	if err := bencode.Unmarshal(info, &metainfo.Info); err != nil {
+		return nil, fmt.Errorf("magnet: parse info dictionary: %w", err)
+	}
+	if metainfo.IsV2() && !metainfo.IsHybrid() {
+		for _, f := range metainfo.Info.FileTree {
+			if f.Length > metainfo.Info.PieceLength {
+				return nil, fmt.Errorf("%w: file %s", ErrPieceLayersMissing, f.FilePath())
+			}
+		}
+	}
+
+	if len(m.Trackers) > 0 {
+		metainfo.Announce = m.Trackers[0]
+		for _, tr := range m.Trackers {
+			metainfo.AnnounceList = append(metainfo.AnnounceList, []string{tr})
+		}
+	}
+	return metainfo, nil
+}
@@ -0,0 +1,190 @@
+package magnet_test
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anivanovic/gotit/pkg/bencode"
+	"github.com/anivanovic/gotit/pkg/magnet"
+)
+
+const (
+	hexHash   = "209c8226b299b308beaf2b9cd3fb49212dbd13ec"
+	hexHashV2 = "2e1a0b3f4c8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	hash, _ := hex.DecodeString(hexHash)
+	hashV2, _ := hex.DecodeString(hexHashV2)
+	tests := []struct {
+		name string
+		uri  string
+		want *magnet.Magnet
+	}{
+		{
+			name: "hex hash",
+			uri: "magnet:?xt=urn:btih:" + hexHash + "&dn=Tears+of+Steel" +
+				"&tr=udp%3A%2F%2Fexplodie.org%3A6969&tr=wss%3A%2F%2Ftracker.btorrent.xyz" +
+				"&x.pe=127.0.0.1:6881&x.pe=[::1]:6882",
+			want: &magnet.Magnet{
+				InfoHash: hash,
+				Name:     "Tears of Steel",
+				Trackers: []string{"udp://explodie.org:6969", "wss://tracker.btorrent.xyz"},
+				Peers:    []string{"127.0.0.1:6881", "[::1]:6882"},
+			},
+		},
+		{
+			name: "base32 hash",
+			uri:  "magnet:?xt=urn:btih:ECOIEJVSTGZQRPVPFOONH62JEEW32E7M",
+			want: &magnet.Magnet{InfoHash: hash},
+		},
+		{
+			name: "v2 hash and web seeds",
+			uri: "magnet:?xt=urn:btih:" + hexHash + "&xt=urn:btmh:1220" + hexHashV2 +
+				"&ws=http%3A%2F%2Fseed.example.com%2F",
+			want: &magnet.Magnet{
+				InfoHash:   hash,
+				InfoHashV2: hashV2,
+				WebSeeds:   []string{"http://seed.example.com/"},
+			},
+		},
+		{
+			name: "uppercase hex and other topics",
+			uri:  "magnet:?xt=urn:sha1:XYZ&xt=urn:btih:209C8226B299B308BEAF2B9CD3FB49212DBD13EC",
+			want: &magnet.Magnet{InfoHash: hash},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := magnet.Parse(tt.uri)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		uri  string
+	}{
+		{name: "not magnet", uri: "http://example.com/?xt=urn:btih:" + hexHash},
+		{name: "missing topic", uri: "magnet:?dn=name"},
+		{name: "short hash", uri: "magnet:?xt=urn:btih:209c8226"},
+		{name: "invalid hex", uri: "magnet:?xt=urn:btih:" + "zz9c8226b299b308beaf2b9cd3fb49212dbd13ec"},
+		{name: "invalid base32", uri: "magnet:?xt=urn:btih:11111111111111111111111111111111"},
+		{name: "not sha256 multihash", uri: "magnet:?xt=urn:btih:" + hexHash + "&xt=urn:btmh:1114" + hexHash},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := magnet.Parse(tt.uri)
+			assert.ErrorIs(t, err, magnet.ErrInvalidMagnet)
+		})
+	}
+}
+
+func TestMagnet_Metainfo(t *testing.T) {
+	t.Parallel()
+
+	info := []byte("d6:lengthi10e4:name4:test12:piece lengthi16384e6:pieces20:aaaaaaaaaaaaaaaaaaaae")
+	hash := sha1.Sum(info)
+	m := &magnet.Magnet{
+		InfoHash: hash[:],
+		Trackers: []string{"udp://a:1", "http://b/announce"},
+		WebSeeds: []string{"http://seed/"},
+	}
+
+	metainfo, err := m.Metainfo(info)
+	require.NoError(t, err)
+	assert.Equal(t, "test", metainfo.Info.Name)
+	assert.Equal(t, int64(10), metainfo.Info.Length)
+	assert.Equal(t, "udp://a:1", metainfo.Announce)
+	assert.Equal(t, [][]string{{"udp://a:1"}, {"http://b/announce"}}, metainfo.AnnounceList)
+	assert.Equal(t, []string{"http://seed/"}, metainfo.UrlList)
+	assert.Equal(t, hash[:], m.SwarmHash())
+	assert.Equal(t, hash[:], metainfo.Hash())
+
+	_, err = m.Metainfo(append(info, ' '))
+	assert.ErrorIs(t, err, magnet.ErrHashMismatch)
+}
+
+func TestFromMetainfo(t *testing.T) {
+	t.Parallel()
+
+	info := []byte("d6:lengthi10e4:name9:test file12:piece lengthi16384e6:pieces20:aaaaaaaaaaaaaaaaaaaae")
+	hash := sha1.Sum(info)
+	metainfo := &bencode.Metainfo{
+		Announce:     "udp://a:1",
+		AnnounceList: [][]string{{"udp://a:1", "http://b/announce"}, {"http://c/announce"}},
+		UrlList:      []string{"http://seed/"},
+		InfoDictRaw:  info,
+	}
+	require.NoError(t, bencode.Unmarshal(info, &metainfo.Info))
+
+	m := magnet.FromMetainfo(metainfo)
+	assert.Equal(t, &magnet.Magnet{
+		InfoHash: hash[:],
+		Name:     "test file",
+		Trackers: []string{"udp://a:1", "http://b/announce", "http://c/announce"},
+		WebSeeds: []string{"http://seed/"},
+	}, m)
+	assert.Equal(t, "magnet:?xt=urn:btih:"+hex.EncodeToString(hash[:])+"&dn=test+file"+
+		"&tr=udp%3A%2F%2Fa%3A1&tr=http%3A%2F%2Fb%2Fannounce&tr=http%3A%2F%2Fc%2Fannounce"+
+		"&ws=http%3A%2F%2Fseed%2F", m.String())
+
+	parsed, err := magnet.Parse(m.String())
+	require.NoError(t, err)
+	assert.Equal(t, m, parsed)
+}
+
+func TestFromMetainfo_V2(t *testing.T) {
+	t.Parallel()
+
+	info := []byte("d9:file treed1:ad0:d6:lengthi10eeee12:meta versioni2e4:name1:a12:piece lengthi16384ee")
+	hashV2 := sha256.Sum256(info)
+	metainfo := &bencode.Metainfo{InfoDictRaw: info}
+	require.NoError(t, bencode.Unmarshal(info, &metainfo.Info))
+
+	m := magnet.FromMetainfo(metainfo)
+	assert.Nil(t, m.InfoHash)
+	assert.Equal(t, hashV2[:], m.InfoHashV2)
+	assert.Equal(t, "magnet:?xt=urn:btmh:1220"+hex.EncodeToString(hashV2[:])+"&dn=a", m.String())
+
+	parsed, err := magnet.Parse(m.String())
+	require.NoError(t, err)
+	assert.Equal(t, m, parsed)
+	assert.Equal(t, hashV2[:20], parsed.SwarmHash())
+
+	fetched, err := parsed.Metainfo(info)
+	require.NoError(t, err)
+	assert.Equal(t, hashV2[:], fetched.HashV2())
+
+	_, err = parsed.Metainfo(append(info, ' '))
+	assert.ErrorIs(t, err, magnet.ErrHashMismatch)
+}
+
+func TestMetainfo_V2PieceLayersMissing(t *testing.T) {
+	t.Parallel()
+
+	info := []byte("d9:file treed1:ad0:d6:lengthi20000e11:pieces root32:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaeee12:meta versioni2e4:name1:a12:piece lengthi16384ee")
+	metainfo := &bencode.Metainfo{InfoDictRaw: info}
+	require.NoError(t, bencode.Unmarshal(info, &metainfo.Info))
+
+	m, err := magnet.Parse(magnet.FromMetainfo(metainfo).String())
+	require.NoError(t, err)
+	_, err = m.Metainfo(info)
+	assert.ErrorIs(t, err, magnet.ErrPieceLayersMissing)
+}
@@ -0,0 +1,101 @@
+// Package merkle implements SHA-256 merkle trees used by BitTorrent v2
+// (BEP 52) to hash files and pieces.
+package merkle
+
+import (
+	"crypto/sha256"
+)
+
+// BlockSize is size of data blocks which are leaves of the merkle tree.
+const BlockSize = 16 * 1024
+
+// HashSize is size of SHA-256 hashes in the tree.
+const HashSize = sha256.Size
+
+// BlockHashes returns SHA-256 hashes of data split into BlockSize blocks.
+// Last block can be shorter.
+func BlockHashes(data []byte) [][]byte {
+	hashes := make([][]byte, 0, (len(data)+BlockSize-1)/BlockSize)
+	for begin := 0; begin < len(data); begin += BlockSize {
+		end := min(begin+BlockSize, len(data))
+		hash := sha256.Sum256(data[begin:end])
+		hashes = append(hashes, hash[:])
+	}
+	return hashes
+}
+
+// Root returns root of the tree with hashes as leaves. Tree is padded to
+// width leaves with pad hashes, where width is rounded up to power of two.
+func Root(hashes [][]byte, width int, pad []byte) []byte {
+	width = NextPowerOfTwo(max(width, len(hashes)))
+	layer := make([][]byte, width)
+	copy(layer, hashes)
+	for i := len(hashes); i < width; i++ {
+		layer[i] = pad
+	}
+
+	for len(layer) > 1 {
+		next := make([][]byte, len(layer)/2)
+		for i := range next {
+			next[i] = hashPair(layer[2*i], layer[2*i+1])
+		}
+		layer = next
+	}
+
+	return layer[0]
+}
+
+// PieceRoot returns root of piece data tree, padded to leaves number of
+// blocks. Leaves of the tree beyond the end of data are zero hashes.
+func PieceRoot(data []byte, leaves int) []byte {
+	return Root(BlockHashes(data), leaves, make([]byte, HashSize))
+}
+
+// FileRoot returns pieces root of the file data, which is root of the
+// tree of all file blocks. Empty files have no root.
+func FileRoot(data []byte) []byte {
+	if len(data) == 0 {
+		return nil
+	}
+	return PieceRoot(data, 1)
+}
+
+// PadHash returns root of the tree with leaves number of zero hashes as
+// leaves. It is used to pad piece layers.
+func PadHash(leaves int) []byte {
+	return Root(nil, leaves, make([]byte, HashSize))
+}
+
+// LayerRoot returns root of the tree built from piece layer hashes, where
+// each piece covers pieceLeaves blocks.
+func LayerRoot(layer [][]byte, pieceLeaves int) []byte {
+	return Root(layer, len(layer), PadHash(pieceLeaves))
+}
+
+// PieceLayer returns hashes of the piece layer of data, where each piece
+// covers pieceLeaves blocks.
+func PieceLayer(data []byte, pieceLeaves int) [][]byte {
+	pieceLength := pieceLeaves * BlockSize
+	layer := make([][]byte, 0, (len(data)+pieceLength-1)/pieceLength)
+	for begin := 0; begin < len(data); begin += pieceLength {
+		end := min(begin+pieceLength, len(data))
+		layer = append(layer, PieceRoot(data[begin:end], pieceLeaves))
+	}
+	return layer
+}
+
+// NextPowerOfTwo returns smallest power of two not less than n.
+func NextPowerOfTwo(n int) int {
+	p := 1
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
+
+func hashPair(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
@@ -0,0 +1,72 @@
+package merkle_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/anivanovic/gotit/pkg/merkle"
+)
+
+func sum(data ...[]byte) []byte {
+	h := sha256.New()
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+func TestFileRoot(t *testing.T) {
+	t.Parallel()
+
+	zero := make([]byte, merkle.HashSize)
+	block := bytes.Repeat([]byte{1}, merkle.BlockSize)
+	last := []byte("last block")
+
+	assert.Nil(t, merkle.FileRoot(nil))
+	assert.Equal(t, sum(last), merkle.FileRoot(last), "single block file root is block hash")
+
+	data := append(append(bytes.Clone(block), block...), last...)
+	want := sum(sum(sum(block), sum(block)), sum(sum(last), zero))
+	assert.Equal(t, want, merkle.FileRoot(data))
+}
+
+func TestPieceRoot_PadsToLeaves(t *testing.T) {
+	t.Parallel()
+
+	zero := make([]byte, merkle.HashSize)
+	data := []byte("short piece")
+
+	want := sum(sum(sum(data), zero), sum(zero, zero))
+	assert.Equal(t, want, merkle.PieceRoot(data, 4))
+}
+
+func TestLayerRoot_MatchesFileRoot(t *testing.T) {
+	t.Parallel()
+
+	const pieceLeaves = 4
+	for _, size := range []int{
+		pieceLeaves*merkle.BlockSize + 1,
+		3 * pieceLeaves * merkle.BlockSize,
+		5*pieceLeaves*merkle.BlockSize - 100,
+	} {
+		data := make([]byte, size)
+		for i := range data {
+			data[i] = byte(i * 7)
+		}
+
+		layer := merkle.PieceLayer(data, pieceLeaves)
+		assert.Len(t, layer, (size+pieceLeaves*merkle.BlockSize-1)/(pieceLeaves*merkle.BlockSize))
+		assert.Equal(t, merkle.FileRoot(data), merkle.LayerRoot(layer, pieceLeaves), "size %d", size)
+	}
+}
+
+func TestNextPowerOfTwo(t *testing.T) {
+	t.Parallel()
+
+	for n, want := range map[int]int{0: 1, 1: 1, 2: 2, 3: 4, 5: 8, 1024: 1024, 1025: 2048} {
+		assert.Equal(t, want, merkle.NextPowerOfTwo(n), "n = %d", n)
+	}
+}
@@ -0,0 +1,243 @@
+package peer
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/netip"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/anivanovic/gotit/pkg/bencode"
+	"github.com/anivanovic/gotit/pkg/gotitnet"
+	"github.com/anivanovic/gotit/pkg/util"
+)
+
+const (
+	// metadataPieceLength is size of info dictionary pieces exchanged
+	// with ut_metadata extension.
+	metadataPieceLength = 16 * 1024
+	// maxMetadataSize limits info dictionary size accepted from peers.
+	maxMetadataSize = 16 * 1024 * 1024
+	// metadataTimeout is read and write timeout used while fetching
+	// metadata, as peers may take a while to answer requests.
+	metadataTimeout = 10 * time.Second
+	// utMetadataId is extension message id we expect ut_metadata
+	// messages to be sent with.
+	utMetadataId = 1
+)
+
+// ut_metadata message types
+const (
+	metadataRequest = iota
+	metadataData
+	metadataReject
+)
+
+var (
+	ErrMetadataNotSupported = errors.New("peer does not support metadata exchange")
+	ErrMetadataRejected     = errors.New("peer rejected metadata request")
+	ErrMetadataInvalid      = errors.New("peer sent metadata not matching info hash")
+)
+
+type extensionHandshake struct {
+	M            map[string]int `ben:"m"`
+	MetadataSize int            `ben:"metadata_size,optional"`
+	Version      string         `ben:"v,optional"`
+}
+
+type metadataMessage struct {
+	MsgType   int `ben:"msg_type"`
+	Piece     int `ben:"piece"`
+	TotalSize int `ben:"total_size,optional"`
+}
+
+// FetchMetadata connects to the peer and downloads torrent info dictionary
+// using extension protocol (BEP 10) and ut_metadata extension (BEP 9).
+// Returned info dictionary is checked against infoHash.
+func FetchMetadata(ctx context.Context, addr netip.AddrPort, infoHash []byte, logger *zap.Logger) ([]byte, error) {
+	logger = logger.With(zap.String("ip", addr.String()))
+	conn, err := gotitnet.NewTimeoutConn("tcp", addr.String(), metadataTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("peer connect: %w", err)
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	if _, err := conn.Write(createHandshake(infoHash)); err != nil {
+		return nil, fmt.Errorf("peer handshake: %w", err)
+	}
+	response, err := conn.ReadPeerHandshake()
+	if err != nil {
+		return nil, fmt.Errorf("peer handshake: %w", err)
+	}
+	if !isHandshakeValid(response, infoHash, nil) {
+		return nil, errors.New("peer handshake invalid")
+	}
+	if !supportsExtensions(response) {
+		return nil, ErrMetadataNotSupported
+	}
+
+	handshake, err := bencode.Marshal(extensionHandshake{
+		M:       map[string]int{"ut_metadata": utMetadataId},
+		Version: "gotit",
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.WriteMsg(util.CreateExtendedMessage(0, handshake)); err != nil {
+		return nil, fmt.Errorf("send extension handshake: %w", err)
+	}
+
+	f := &metadataFetcher{conn: conn, logger: logger}
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		data, err := conn.ReadPeerMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, fmt.Errorf("read peer message: %w", err)
+		}
+
+		msg := util.NewPeerMessage(data)
+		if msg.Type != util.ExtendedMessageType {
+			continue
+		}
+
+		done, err := f.handle(msg)
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			break
+		}
+	}
+
+	if !matchesInfoHash(f.metadata, infoHash) {
+		return nil, ErrMetadataInvalid
+	}
+
+	logger.Info("fetched torrent metadata", zap.Int("size", len(f.metadata)))
+	return f.metadata, nil
+}
+
+// matchesInfoHash reports whether info dictionary has infoHash, which is
+// SHA-1 hash or, for v2 torrents, SHA-256 hash truncated to 20 bytes.
+func matchesInfoHash(info, infoHash []byte) bool {
+	hash := sha1.Sum(info)
+	if bytes.Equal(hash[:], infoHash) {
+		return true
+	}
+	hashV2 := sha256.Sum256(info)
+	return bytes.Equal(hashV2[:sha1.Size], infoHash)
+}
+
+// metadataFetcher keeps state of info dictionary download from single
+// peer.
+type metadataFetcher struct {
+	conn   *gotitnet.TimeoutConn
+	logger *zap.Logger
+
+	peerMetadataId uint8
+	metadata       []byte
+	received       []bool
+	remaining      int
+}
+
+// handle processes extension message returning true when all metadata
+// pieces are received.
+func (f *metadataFetcher) handle(msg *util.PeerMessage) (bool, error) {
+	switch msg.ExtendedId() {
+	case 0:
+		return false, f.handleHandshake(msg.ExtendedPayload())
+	case utMetadataId:
+		if f.metadata == nil {
+			return false, errors.New("peer sent metadata before extension handshake")
+		}
+		return f.handleMetadata(msg.ExtendedPayload())
+	default:
+		f.logger.Debug("ignoring extension message", zap.Uint8("extendedId", msg.ExtendedId()))
+		return false, nil
+	}
+}
+
+func (f *metadataFetcher) handleHandshake(payload []byte) error {
+	handshake := extensionHandshake{}
+	if err := bencode.Unmarshal(payload, &handshake); err != nil {
+		return fmt.Errorf("parse extension handshake: %w", err)
+	}
+
+	id := handshake.M["ut_metadata"]
+	if id <= 0 || id > 255 {
+		return ErrMetadataNotSupported
+	}
+	if handshake.MetadataSize <= 0 || handshake.MetadataSize > maxMetadataSize {
+		return fmt.Errorf("peer sent invalid metadata size %d", handshake.MetadataSize)
+	}
+	if f.metadata != nil {
+		// metadata is already requested
+		return nil
+	}
+
+	f.peerMetadataId = uint8(id)
+	f.metadata = make([]byte, handshake.MetadataSize)
+	f.remaining = (handshake.MetadataSize + metadataPieceLength - 1) / metadataPieceLength
+	f.received = make([]bool, f.remaining)
+
+	for i := range f.received {
+		request, err := bencode.Marshal(metadataMessage{MsgType: metadataRequest, Piece: i})
+		if err != nil {
+			return err
+		}
+		if _, err := f.conn.WriteMsg(util.CreateExtendedMessage(f.peerMetadataId, request)); err != nil {
+			return fmt.Errorf("request metadata piece %d: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+func (f *metadataFetcher) handleMetadata(payload []byte) (bool, error) {
+	dec := bencode.NewDecoder(bytes.NewReader(payload))
+	msg := metadataMessage{}
+	if err := dec.Decode(&msg); err != nil {
+		return false, fmt.Errorf("parse metadata message: %w", err)
+	}
+
+	switch msg.MsgType {
+	case metadataData:
+	case metadataReject:
+		return false, fmt.Errorf("%w: piece %d", ErrMetadataRejected, msg.Piece)
+	default:
+		return false, nil
+	}
+
+	if msg.Piece < 0 || msg.Piece >= len(f.received) {
+		return false, fmt.Errorf("peer sent invalid metadata piece %d", msg.Piece)
+	}
+
+	// piece data follows bencoded message dictionary
+	data := payload[dec.InputOffset():]
+	begin := msg.Piece * metadataPieceLength
+	end := min(begin+metadataPieceLength, len(f.metadata))
+	if len(data) != end-begin {
+		return false, fmt.Errorf("peer sent metadata piece %d of invalid length %d", msg.Piece, len(data))
+	}
+
+	if !f.received[msg.Piece] {
+		copy(f.metadata[begin:end], data)
+		f.received[msg.Piece] = true
+		f.remaining--
+	}
+
+	return f.remaining == 0, nil
+}
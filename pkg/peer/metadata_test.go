@@ -0,0 +1,166 @@
+package peer
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/anivanovic/gotit/pkg/bencode"
+	"github.com/anivanovic/gotit/pkg/util"
+)
+
+// --- fake metadata peer ------------------------------------------------------
+
+// servePeer accepts single connection and serves metadata in response to
+// ut_metadata requests. onRequest can change the data sent for the piece.
+func servePeer(t *testing.T, metadata []byte, onRequest func(piece int, data []byte) []byte) netip.AddrPort {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		handshake := make([]byte, 68)
+		if _, err := io.ReadFull(conn, handshake); err != nil {
+			return
+		}
+		response := validHandshake(handshake[28:48], bytes.Repeat([]byte{1}, 20))
+		response[25] |= extensionProtocolBit
+		_, _ = conn.Write(response)
+
+		const peerMetadataId = 3
+		extHandshake, _ := bencode.Marshal(extensionHandshake{
+			M:            map[string]int{"ut_metadata": peerMetadataId},
+			MetadataSize: len(metadata),
+		})
+		_, _ = util.CreateExtendedMessage(0, extHandshake).Send(conn)
+
+		for {
+			msg, err := readMessage(conn)
+			if err != nil {
+				return
+			}
+			// requests use id peer advertised, while replies use our id
+			if msg.Type != util.ExtendedMessageType || msg.ExtendedId() != peerMetadataId {
+				continue
+			}
+
+			request := metadataMessage{}
+			if err := bencode.Unmarshal(msg.ExtendedPayload(), &request); err != nil {
+				return
+			}
+			begin := request.Piece * metadataPieceLength
+			end := min(begin+metadataPieceLength, len(metadata))
+			data := onRequest(request.Piece, metadata[begin:end])
+
+			reply := metadataMessage{MsgType: metadataData, Piece: request.Piece, TotalSize: len(metadata)}
+			if data == nil {
+				reply.MsgType = metadataReject
+			}
+			payload, _ := bencode.Marshal(reply)
+			_, _ = util.CreateExtendedMessage(utMetadataId, append(payload, data...)).Send(conn)
+		}
+	}()
+
+	return netip.MustParseAddrPort(l.Addr().String())
+}
+
+func readMessage(r io.Reader) (*util.PeerMessage, error) {
+	size := make([]byte, 4)
+	if _, err := io.ReadFull(r, size); err != nil {
+		return nil, err
+	}
+	data := make([]byte, binary.BigEndian.Uint32(size))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return util.NewPeerMessage(data), nil
+}
+
+func testMetadata() ([]byte, []byte) {
+	pieces := bytes.Repeat([]byte{0xAB}, 20*1700)
+	info := []byte("d6:lengthi10e4:name4:test12:piece lengthi16384e6:pieces34000:")
+	info = append(info, pieces...)
+	info = append(info, 'e')
+	hash := sha1.Sum(info)
+	return info, hash[:]
+}
+
+// --- FetchMetadata -----------------------------------------------------------
+
+func TestFetchMetadata(t *testing.T) {
+	metadata, hash := testMetadata()
+	require.Greater(t, len(metadata), 2*metadataPieceLength)
+
+	addr := servePeer(t, metadata, func(_ int, data []byte) []byte { return data })
+	got, err := FetchMetadata(context.Background(), addr, hash, zap.NewNop())
+	require.NoError(t, err)
+	assert.Equal(t, metadata, got)
+}
+
+func TestFetchMetadata_V2Hash(t *testing.T) {
+	metadata, _ := testMetadata()
+	hash := sha256.Sum256(metadata)
+
+	addr := servePeer(t, metadata, func(_ int, data []byte) []byte { return data })
+	got, err := FetchMetadata(context.Background(), addr, hash[:20], zap.NewNop())
+	require.NoError(t, err)
+	assert.Equal(t, metadata, got)
+}
+
+func TestFetchMetadata_Rejected(t *testing.T) {
+	metadata, hash := testMetadata()
+
+	addr := servePeer(t, metadata, func(piece int, data []byte) []byte {
+		if piece == 1 {
+			return nil
+		}
+		return data
+	})
+	_, err := FetchMetadata(context.Background(), addr, hash, zap.NewNop())
+	assert.ErrorIs(t, err, ErrMetadataRejected)
+}
+
+func TestFetchMetadata_HashMismatch(t *testing.T) {
+	metadata, hash := testMetadata()
+
+	addr := servePeer(t, metadata, func(_ int, data []byte) []byte {
+		corrupted := bytes.Clone(data)
+		corrupted[0] ^= 0xFF
+		return corrupted
+	})
+	_, err := FetchMetadata(context.Background(), addr, hash, zap.NewNop())
+	assert.ErrorIs(t, err, ErrMetadataInvalid)
+}
+
+func TestFetchMetadata_InvalidPieceLength(t *testing.T) {
+	metadata, hash := testMetadata()
+
+	addr := servePeer(t, metadata, func(_ int, data []byte) []byte { return data[1:] })
+	_, err := FetchMetadata(context.Background(), addr, hash, zap.NewNop())
+	assert.ErrorContains(t, err, "invalid length")
+}
+
+// --- extension handshake -----------------------------------------------------
+
+func TestCreateHandshake_ExtensionBit(t *testing.T) {
+	hs := createHandshake(bytes.Repeat([]byte{0x01}, 20))
+	assert.True(t, supportsExtensions(hs))
+	assert.False(t, supportsExtensions(validHandshake(bytes.Repeat([]byte{0x01}, 20), ClientId)))
+}
@@ -24,12 +24,26 @@ var (
 	clientIdPrefix  = [8]byte{'-', 'G', 'O', '0', '1', '0', '0', '-'}
 )
 
-type PiecesSource interface {
-	Next(bitset *bitset.BitSet) (uint, bool)
-}
-
-type PieceChecker interface {
-	CheckPiece([]byte, int) bool
+// extensionProtocolBit is set in the fifth reserved handshake byte by
+// peers supporting extension protocol (BEP 10).
+const extensionProtocolBit = 0x10
+
+// PiecePicker chooses pieces to download from peers. Peers report
+// pieces they have, so pieces can be chosen by their availability.
+type PiecePicker interface {
+	// Next claims piece to download from peer having pieces have.
+	Next(have *bitset.BitSet) (uint, bool)
+	// Abandon releases claimed piece, which peer stopped downloading
+	// before all of its blocks were requested. Peer picking the piece
+	// again requests all of its blocks.
+	Abandon(index uint)
+	// PeerHas adds pieces of a peer to pieces availability.
+	PeerHas(have *bitset.BitSet)
+	// PeerHasPiece adds a piece peer announced it has.
+	PeerHasPiece(index uint)
+	// PeerGone removes pieces of a peer from pieces availability.
+	PeerGone(have *bitset.BitSet)
+	PieceSize(index int) int
 }
 
 type Peer struct {
@@ -41,18 +55,19 @@ type Peer struct {
 	ClientStatus *Status
 	lastMsgSent  time.Time
 	piecesQueue  *torrent.PiecesQueue
-	piecesSource PiecesSource
-	pieceChecker PieceChecker
+	picker       PiecePicker
+	requests     *requestQueue
 
 	torrent *torrent.Torrent
 
 	logger *zap.Logger
 
-	blockIdx uint
-	pieceIdx uint
-	blockNum uint
+	blockIdx  uint
+	pieceIdx  uint
+	pieceSize uint
+	blockNum  uint
 
-	writeCh chan<- *util.PeerMessage
+	writeCh chan<- *torrent.Block
 }
 
 type Status struct {
@@ -77,12 +92,14 @@ func isHandshakeValid(handshake, hash, peerId []byte) bool {
 
 func (p *Peer) createPieceMessage() *util.PeerMessage {
 	beginOffset := p.blockIdx * torrent.BlockLength
-	msg := util.CreatePieceMessage(uint32(p.pieceIdx), uint32(beginOffset), uint32(torrent.BlockLength))
+	// last block of the last piece can be shorter
+	length := min(torrent.BlockLength, p.pieceSize-beginOffset)
+	msg := util.CreatePieceMessage(uint32(p.pieceIdx), uint32(beginOffset), uint32(length))
 
 	p.logger.Debug("created piece request",
 		zap.Uint("piece", p.pieceIdx),
 		zap.Uint("offset", beginOffset),
-		zap.Uint("length", torrent.BlockLength),
+		zap.Uint("length", length),
 	)
 
 	p.blockIdx++
@@ -96,8 +113,10 @@ func newPeerStatus() *Status {
 func NewPeer(
 	ip netip.AddrPort,
 	t *torrent.Torrent,
+	picker PiecePicker,
 	piecesQueue *torrent.PiecesQueue,
-	writeCh chan<- *util.PeerMessage,
+	writeCh chan<- *torrent.Block,
+	maxRequests int,
 	logger *zap.Logger,
 ) *Peer {
 	return &Peer{
@@ -108,12 +127,10 @@ func NewPeer(
 		ClientStatus: newPeerStatus(),
 		lastMsgSent:  time.Now(),
 		logger:       logger.With(zap.String("ip", ip.String())),
-		blockIdx:     uint(0),
-		blockNum:     uint(t.PieceLength) / torrent.BlockLength,
 		piecesQueue:  piecesQueue,
+		requests:     newRequestQueue(maxRequests),
 		writeCh:      writeCh,
-		piecesSource: t,
-		pieceChecker: t,
+		picker:       picker,
 		torrent:      t,
 		Bitset:       t.EmptyBitset(),
 	}
@@ -208,11 +225,13 @@ func wait(ctx context.Context, sleepDuration time.Duration) error {
 	}
 }
 
-// Run communicates with remote peer and downloads torrent pieces.
+// Run communicates with remote peer and downloads torrent pieces. Block
+// requests are pipelined, and not received blocks are requested again
+// through pieces queue.
 func (p *Peer) Run(ctx context.Context) {
-	var requestMsg *util.PeerMessage
-	sentPieceMsg := false
+	defer p.release()
 
+	b := newDefaultBackoff()
 	for {
 		// check if canceled
 		if err := ctx.Err(); err != nil {
@@ -236,19 +255,13 @@ func (p *Peer) Run(ctx context.Context) {
 			}
 		}
 
-		b := newDefaultBackoff()
-		if !p.ClientStatus.Choked && p.ClientStatus.Interested && !sentPieceMsg {
-			requestMsg = p.nextRequestMessage()
-			if requestMsg == nil {
-				if err := wait(ctx, time.Second*2); err != nil {
-					return
-				}
-
-				continue
-			}
+		if expired := p.requests.expired(time.Now()); len(expired) > 0 {
+			p.logger.Debug("block requests timed out", zap.Int("blocks", len(expired)))
+			p.requeue(expired)
+		}
 
-			if _, err := p.sendMessage(requestMsg); err != nil {
-				p.piecesQueue.RequestFailed(requestMsg)
+		if !p.ClientStatus.Choked && p.ClientStatus.Interested {
+			if err := p.sendRequests(); err != nil {
 				d := b.Duration()
 				p.logger.Warn("Error requesting piece. Retrying",
 					zap.Duration("backoff", d),
@@ -259,16 +272,17 @@ func (p *Peer) Run(ctx context.Context) {
 				continue
 			}
 
-			b.Reset()
-			sentPieceMsg = true
+			if p.requests.empty() {
+				// we do not have any block to request from the peer
+				if err := wait(ctx, time.Second*2); err != nil {
+					return
+				}
+				continue
+			}
 		}
 
 		response, err := p.conn.ReadPeerMessage()
 		if err != nil {
-			if sentPieceMsg {
-				p.piecesQueue.RequestFailed(requestMsg)
-				sentPieceMsg = false
-			}
 			d := b.Duration()
 			if err := wait(ctx, d); err != nil {
 				return
@@ -281,15 +295,50 @@ func (p *Peer) Run(ctx context.Context) {
 	}
 }
 
+// release hands over downloads of the peer to other peers, once it stops
+// running.
+func (p *Peer) release() {
+	p.requeue(p.requests.drain())
+	if p.blockIdx < p.blockNum {
+		p.picker.Abandon(p.pieceIdx)
+	}
+	p.blockIdx, p.blockNum = 0, 0
+	p.picker.PeerGone(p.Bitset)
+}
+
+// sendRequests sends block requests until request queue is full.
+func (p *Peer) sendRequests() error {
+	for !p.requests.full() {
+		msg := p.nextRequestMessage()
+		if msg == nil {
+			return nil
+		}
+
+		if _, err := p.sendMessage(msg); err != nil {
+			p.piecesQueue.RequestFailed(msg)
+			return err
+		}
+		p.requests.add(msg, time.Now())
+	}
+	return nil
+}
+
+// requeue makes block requests available to all peers.
+func (p *Peer) requeue(msgs []*util.PeerMessage) {
+	for _, msg := range msgs {
+		p.piecesQueue.RequestFailed(msg)
+	}
+}
+
 func (p *Peer) nextRequestMessage() *util.PeerMessage {
 	if p.blockIdx >= p.blockNum {
 		// when finished with piece download check if we have failed
 		// piece requests
-		if req := p.piecesQueue.FailedPieceMessage(); req != nil {
+		if req := p.piecesQueue.FailedPieceMessage(p.Bitset); req != nil {
 			return req
 		}
 
-		indx, found := p.piecesSource.Next(p.Bitset)
+		indx, found := p.picker.Next(p.Bitset)
 		if !found {
 			// we do not have any piece to request from the peer
 			return nil
@@ -297,6 +346,8 @@ func (p *Peer) nextRequestMessage() *util.PeerMessage {
 
 		p.blockIdx = 0
 		p.pieceIdx = indx
+		p.pieceSize = uint(p.picker.PieceSize(int(indx)))
+		p.blockNum = (p.pieceSize + torrent.BlockLength - 1) / torrent.BlockLength
 	}
 
 	return p.createPieceMessage()
@@ -332,10 +383,12 @@ func (p *Peer) handlePeerMessage(message *util.PeerMessage) {
 	switch message.Type {
 	case util.BitfieldMessageType:
 		p.logger.Debug("Peer sent bitfield message", zap.Int("peerId", p.Id))
+		p.picker.PeerGone(p.Bitset)
 		p.Bitset = message.Bitfield()
+		p.picker.PeerHas(p.Bitset)
 	case util.HaveMessageType:
 		p.logger.Debug("Peer sent have message", zap.Int("peerId", p.Id))
-		p.Bitset.Set(uint(message.Index()))
+		p.setHave(uint(message.Index()))
 	case util.InterestedMessageType:
 		p.logger.Debug("Peer sent interested message", zap.Int("peerId", p.Id))
 		p.PeerStatus.Interested = true
@@ -347,6 +400,8 @@ func (p *Peer) handlePeerMessage(message *util.PeerMessage) {
 	case util.ChokeMessageType:
 		p.logger.Debug("Peer sent choke message", zap.Int("peerId", p.Id))
 		p.ClientStatus.Choked = true
+		// choking peer discards requests it did not answer
+		p.requeue(p.requests.drain())
 	case util.UnchokeMessageType:
 		p.logger.Debug("Peer sent unchoke message", zap.Int("peerId", p.Id))
 		p.ClientStatus.Choked = false
@@ -357,6 +412,8 @@ func (p *Peer) handlePeerMessage(message *util.PeerMessage) {
 		p.handlePieceMessage(message)
 	case util.CancelMessageType:
 		p.logger.Debug("Peer sent cancel message")
+	case util.ExtendedMessageType:
+		p.logger.Debug("Peer sent extended message", zap.Uint8("extendedId", message.ExtendedId()))
 	default:
 		p.logger.Error("peer sent unrecognized message",
 			zap.Int("peerId", p.Id),
@@ -386,19 +443,40 @@ func (p *Peer) SendUnchoke() error {
 }
 
 func (p *Peer) SendInterested() error {
-	_, err := p.sendMessage(util.CreateInterestedMessage())
-	return err
+	if _, err := p.sendMessage(util.CreateInterestedMessage()); err != nil {
+		return err
+	}
+
+	p.ClientStatus.Interested = true
+	return nil
 }
 
+// handlePieceMessage passes received block to be assembled into piece,
+// which is checked once all of its blocks are received.
 func (p *Peer) handlePieceMessage(message *util.PeerMessage) {
-	if !p.pieceChecker.CheckPiece(message.Data(), int(message.Index())) {
-		p.logger.Warn("Discarding corrupted piece. Sha1 check failed.",
-			zap.Uint32("index", message.Index()))
+	p.requests.receive(message.Index(), message.Offset(), len(message.Data()), time.Now())
+	p.writeCh <- &torrent.Block{
+		Index:  int(message.Index()),
+		Offset: int(message.Offset()),
+		Data:   message.Data(),
+		Source: p.AddrPort.String(),
+	}
+	p.setHave(uint(message.Index()))
+}
+
+// setHave marks piece peer has.
+func (p *Peer) setHave(index uint) {
+	if p.Bitset.Test(index) {
 		return
 	}
+	p.Bitset.Set(index)
+	p.picker.PeerHasPiece(index)
+}
 
-	p.writeCh <- message
-	p.Bitset.Set(uint(message.Index()))
+// supportsExtensions reports whether peer handshake advertises extension
+// protocol support.
+func supportsExtensions(handshake []byte) bool {
+	return len(handshake) >= 28 && handshake[20+5]&extensionProtocolBit != 0
 }
 
 func createHandshake(hash []byte) []byte {
@@ -407,7 +485,9 @@ func createHandshake(hash []byte) []byte {
 	// 19 - as number of letters in protocol type string
 	_ = binary.Write(buf, binary.BigEndian, uint8(len(bittorrentProto)))
 	_ = binary.Write(buf, binary.BigEndian, bittorrentProto)
-	_ = binary.Write(buf, binary.BigEndian, uint64(0))
+	reserved := [8]byte{}
+	reserved[5] |= extensionProtocolBit
+	_ = binary.Write(buf, binary.BigEndian, reserved)
 	_ = binary.Write(buf, binary.BigEndian, hash)
 	_ = binary.Write(buf, binary.BigEndian, ClientId)
 
@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"net/netip"
 	"testing"
+	"time"
 
 	"github.com/anivanovic/gotit/pkg/torrent"
 	"github.com/anivanovic/gotit/pkg/util"
@@ -26,17 +27,17 @@ func validHandshake(hash, peerId []byte) []byte {
 	return buf.Bytes()
 }
 
-func makePeer(t *testing.T, piecesSource PiecesSource, checker PieceChecker) (*Peer, chan *util.PeerMessage) {
+func makePeer(t *testing.T, picker PiecePicker) (*Peer, chan *torrent.Block) {
 	t.Helper()
-	ch := make(chan *util.PeerMessage, 16)
+	ch := make(chan *torrent.Block, 16)
 	logger := zap.NewNop()
 	p := &Peer{
 		PeerStatus:   newPeerStatus(),
 		ClientStatus: newPeerStatus(),
 		Bitset:       bitset.New(8),
 		piecesQueue:  torrent.NewPiecesQueue(),
-		piecesSource: piecesSource,
-		pieceChecker: checker,
+		picker:       picker,
+		requests:     newRequestQueue(DefaultMaxRequests),
 		writeCh:      ch,
 		logger:       logger,
 	}
@@ -45,21 +46,48 @@ func makePeer(t *testing.T, piecesSource PiecesSource, checker PieceChecker) (*P
 
 // --- mocks -------------------------------------------------------------------
 
-type mockPiecesSource struct {
+type mockPicker struct {
 	idx   uint
 	found bool
+	// size is length of pieces, four blocks when not set
+	size int
+
+	availability map[uint]int
+	abandoned    []uint
 }
 
-func (m *mockPiecesSource) Next(_ *bitset.BitSet) (uint, bool) {
+func (m *mockPicker) Next(_ *bitset.BitSet) (uint, bool) {
 	return m.idx, m.found
 }
 
-type mockPieceChecker struct {
-	valid bool
+func (m *mockPicker) Abandon(index uint) {
+	m.abandoned = append(m.abandoned, index)
 }
 
-func (m *mockPieceChecker) CheckPiece(_ []byte, _ int) bool {
-	return m.valid
+func (m *mockPicker) PeerHas(have *bitset.BitSet) {
+	for i, ok := have.NextSet(0); ok; i, ok = have.NextSet(i + 1) {
+		m.PeerHasPiece(i)
+	}
+}
+
+func (m *mockPicker) PeerHasPiece(index uint) {
+	if m.availability == nil {
+		m.availability = make(map[uint]int)
+	}
+	m.availability[index]++
+}
+
+func (m *mockPicker) PeerGone(have *bitset.BitSet) {
+	for i, ok := have.NextSet(0); ok; i, ok = have.NextSet(i + 1) {
+		m.availability[i]--
+	}
+}
+
+func (m *mockPicker) PieceSize(_ int) int {
+	if m.size == 0 {
+		return 4 * int(torrent.BlockLength)
+	}
+	return m.size
 }
 
 // --- isHandshakeValid --------------------------------------------------------
@@ -165,41 +193,55 @@ func TestCreateClientId(t *testing.T) {
 // --- handlePeerMessage -------------------------------------------------------
 
 func TestHandlePeerMessage_Keepalive(t *testing.T) {
-	p, _ := makePeer(t, &mockPiecesSource{}, &mockPieceChecker{valid: true})
+	p, _ := makePeer(t, &mockPicker{})
 	p.handlePeerMessage(util.KeepalivePeerMessage)
 	// no state change expected; just verify no panic
 }
 
 func TestHandlePeerMessage_Choke(t *testing.T) {
-	p, _ := makePeer(t, &mockPiecesSource{}, &mockPieceChecker{valid: true})
+	p, _ := makePeer(t, &mockPicker{})
 	p.ClientStatus.Choked = false
 	p.handlePeerMessage(util.NewPeerMessage([]byte{byte(util.ChokeMessageType)}))
 	assert.True(t, p.ClientStatus.Choked)
 }
 
+func TestHandlePeerMessage_Choke_RequeuesRequests(t *testing.T) {
+	p, _ := makePeer(t, &mockPicker{})
+	p.ClientStatus.Choked = false
+	p.requests.add(util.CreatePieceMessage(1, 0, uint32(torrent.BlockLength)), time.Now())
+
+	p.handlePeerMessage(util.NewPeerMessage([]byte{byte(util.ChokeMessageType)}))
+
+	assert.True(t, p.requests.empty())
+	msg := p.piecesQueue.FailedPieceMessage(bitset.New(8).Set(1))
+	require.NotNil(t, msg)
+	assert.Equal(t, uint32(1), msg.Index())
+}
+
 func TestHandlePeerMessage_Unchoke(t *testing.T) {
-	p, _ := makePeer(t, &mockPiecesSource{}, &mockPieceChecker{valid: true})
+	p, _ := makePeer(t, &mockPicker{})
 	p.ClientStatus.Choked = true
 	p.handlePeerMessage(util.NewPeerMessage([]byte{byte(util.UnchokeMessageType)}))
 	assert.False(t, p.ClientStatus.Choked)
 }
 
 func TestHandlePeerMessage_Interested(t *testing.T) {
-	p, _ := makePeer(t, &mockPiecesSource{}, &mockPieceChecker{valid: true})
+	p, _ := makePeer(t, &mockPicker{})
 	p.PeerStatus.Interested = false
 	p.handlePeerMessage(util.NewPeerMessage([]byte{byte(util.InterestedMessageType)}))
 	assert.True(t, p.PeerStatus.Interested)
 }
 
 func TestHandlePeerMessage_NotInterested(t *testing.T) {
-	p, _ := makePeer(t, &mockPiecesSource{}, &mockPieceChecker{valid: true})
+	p, _ := makePeer(t, &mockPicker{})
 	p.PeerStatus.Interested = true
 	p.handlePeerMessage(util.NewPeerMessage([]byte{byte(util.NotInterestedMessageType)}))
 	assert.False(t, p.PeerStatus.Interested)
 }
 
 func TestHandlePeerMessage_Have(t *testing.T) {
-	p, _ := makePeer(t, &mockPiecesSource{}, &mockPieceChecker{valid: true})
+	picker := &mockPicker{}
+	p, _ := makePeer(t, picker)
 	p.Bitset = bitset.New(256)
 
 	payload := make([]byte, 5)
@@ -208,10 +250,16 @@ func TestHandlePeerMessage_Have(t *testing.T) {
 
 	p.handlePeerMessage(util.NewPeerMessage(payload))
 	assert.True(t, p.Bitset.Test(7))
+
+	// repeated have is counted once
+	p.handlePeerMessage(util.NewPeerMessage(payload))
+	assert.Equal(t, map[uint]int{7: 1}, picker.availability)
 }
 
 func TestHandlePeerMessage_Bitfield(t *testing.T) {
-	p, _ := makePeer(t, &mockPiecesSource{}, &mockPieceChecker{valid: true})
+	picker := &mockPicker{}
+	p, _ := makePeer(t, picker)
+	p.setHave(2)
 
 	// build a bitfield message: type byte + 1 byte of bits (MSB set = piece 0)
 	payload := []byte{byte(util.BitfieldMessageType), 0b10000000}
@@ -221,33 +269,58 @@ func TestHandlePeerMessage_Bitfield(t *testing.T) {
 	// createBitset pads the byte to a uint64 in BigEndian order (0x8000000000000000),
 	// so the bitset library (LSB-first) places the set bit at position 63.
 	assert.True(t, p.Bitset.Test(63))
+	// pieces of replaced bitfield are no longer available
+	assert.Equal(t, map[uint]int{2: 0, 63: 1}, picker.availability)
 }
 
-func TestHandlePeerMessage_Piece_ValidHash_WritesToChannel(t *testing.T) {
-	checker := &mockPieceChecker{valid: true}
-	p, ch := makePeer(t, &mockPiecesSource{}, checker)
+func TestRelease_AbandonsPartialPiece(t *testing.T) {
+	picker := &mockPicker{idx: 4, found: true}
+	p, _ := makePeer(t, picker)
+	p.setHave(4)
 
-	// piece payload: index(4) + offset(4) + data
-	payload := make([]byte, 1+4+4+8)
-	payload[0] = byte(util.PieceMessageType)
-	binary.BigEndian.PutUint32(payload[1:5], 0) // index
-	binary.BigEndian.PutUint32(payload[5:9], 0) // offset
-	copy(payload[9:], []byte("testdata"))
+	msg := p.nextRequestMessage()
+	require.NotNil(t, msg)
+	p.requests.add(msg, time.Now())
+	p.release()
 
-	p.handlePeerMessage(util.NewPeerMessage(payload))
+	assert.Equal(t, []uint{4}, picker.abandoned)
+	assert.Equal(t, map[uint]int{4: 0}, picker.availability)
+	assert.Equal(t, msg, p.piecesQueue.FailedPieceMessage(bitset.New(8).Set(4)), "requested block is available to other peers")
+}
 
-	require.Len(t, ch, 1)
+func TestRelease_AllBlocksRequested(t *testing.T) {
+	picker := &mockPicker{idx: 4, found: true, size: int(torrent.BlockLength)}
+	p, _ := makePeer(t, picker)
+
+	require.NotNil(t, p.nextRequestMessage())
+	p.release()
+
+	assert.Empty(t, picker.abandoned)
 }
 
-func TestHandlePeerMessage_Piece_InvalidHash_DropsMessage(t *testing.T) {
-	checker := &mockPieceChecker{valid: false}
-	p, ch := makePeer(t, &mockPiecesSource{}, checker)
+func TestHandlePeerMessage_Piece_WritesBlockToChannel(t *testing.T) {
+	p, ch := makePeer(t, &mockPicker{})
+	p.AddrPort = netip.MustParseAddrPort("127.0.0.1:6881")
+	p.requests.add(util.CreatePieceMessage(3, 1024, 8), time.Now())
 
+	// piece payload: index(4) + offset(4) + data
 	payload := make([]byte, 1+4+4+8)
 	payload[0] = byte(util.PieceMessageType)
+	binary.BigEndian.PutUint32(payload[1:5], 3)    // index
+	binary.BigEndian.PutUint32(payload[5:9], 1024) // offset
+	copy(payload[9:], []byte("testdata"))
+
 	p.handlePeerMessage(util.NewPeerMessage(payload))
 
-	assert.Empty(t, ch)
+	require.Len(t, ch, 1)
+	assert.Equal(t, &torrent.Block{
+		Index:  3,
+		Offset: 1024,
+		Data:   []byte("testdata"),
+		Source: "127.0.0.1:6881",
+	}, <-ch)
+	assert.True(t, p.Bitset.Test(3))
+	assert.True(t, p.requests.empty())
 }
 
 // --- NewPeer -----------------------------------------------------------------
@@ -263,31 +336,32 @@ func TestNewPeer_Initialization(t *testing.T) {
 	const piecesNum = 17
 	pieceLength := int(torrent.BlockLength) * 4
 	tor := makeTestTorrent(pieceLength, piecesNum)
-	ch := make(chan *util.PeerMessage, 1)
+	ch := make(chan *torrent.Block, 1)
+
+	picker := torrent.NewRarestFirst(tor)
 
-	p := NewPeer(netip.MustParseAddrPort("127.0.0.1:6881"), tor, torrent.NewPiecesQueue(), ch, zap.NewNop())
+	p := NewPeer(netip.MustParseAddrPort("127.0.0.1:6881"), tor, picker, torrent.NewPiecesQueue(), ch, DefaultMaxRequests, zap.NewNop())
 
-	assert.NotNil(t, p.pieceChecker, "pieceChecker nil — will panic on every received piece")
-	assert.NotNil(t, p.piecesSource, "piecesSource nil — no pieces will ever be requested")
+	assert.NotNil(t, p.picker, "picker nil — no pieces will ever be requested")
 	assert.Equal(t, uint(0), p.blockIdx)
-	assert.Equal(t, uint(4), p.blockNum, "blockNum must equal PieceLength/BlockLength")
+	assert.Equal(t, uint(0), p.blockNum, "first piece must be claimed from picker")
 	assert.Equal(t, uint(piecesNum), p.Bitset.Len(), "Bitset must have one bit per piece, not per data byte")
 }
 
 // --- nextRequestMessage ------------------------------------------------------
 
 func TestNextRequestMessage_NoAvailablePiece(t *testing.T) {
-	src := &mockPiecesSource{found: false}
-	p, _ := makePeer(t, src, &mockPieceChecker{})
+	src := &mockPicker{found: false}
+	p, _ := makePeer(t, src)
 
 	msg := p.nextRequestMessage()
 	assert.Nil(t, msg)
 }
 
 func TestNextRequestMessage_FirstBlockOfNewPiece(t *testing.T) {
-	src := &mockPiecesSource{idx: 3, found: true}
-	p, _ := makePeer(t, src, &mockPieceChecker{})
-	// blockIdx=0, blockNum=0 → 0 >= 0 → triggers piecesSource.Next()
+	src := &mockPicker{idx: 3, found: true}
+	p, _ := makePeer(t, src)
+	// blockIdx=0, blockNum=0 → 0 >= 0 → triggers picker.Next()
 
 	msg := p.nextRequestMessage()
 
@@ -297,8 +371,8 @@ func TestNextRequestMessage_FirstBlockOfNewPiece(t *testing.T) {
 }
 
 func TestNextRequestMessage_AdvancesBlockIdx(t *testing.T) {
-	src := &mockPiecesSource{idx: 0, found: true}
-	p, _ := makePeer(t, src, &mockPieceChecker{})
+	src := &mockPicker{idx: 0, found: true}
+	p, _ := makePeer(t, src)
 	p.blockNum = 4
 
 	p.nextRequestMessage()
@@ -306,8 +380,8 @@ func TestNextRequestMessage_AdvancesBlockIdx(t *testing.T) {
 }
 
 func TestNextRequestMessage_SecondBlock_CorrectOffset(t *testing.T) {
-	src := &mockPiecesSource{idx: 1, found: true}
-	p, _ := makePeer(t, src, &mockPieceChecker{})
+	src := &mockPicker{idx: 1, found: true}
+	p, _ := makePeer(t, src)
 	p.blockNum = 4
 	p.pieceIdx = 1
 	p.blockIdx = 1 // already sent block 0
@@ -319,12 +393,13 @@ func TestNextRequestMessage_SecondBlock_CorrectOffset(t *testing.T) {
 }
 
 func TestNextRequestMessage_RetriesFailedPieceFirst(t *testing.T) {
-	src := &mockPiecesSource{idx: 5, found: true}
-	p, _ := makePeer(t, src, &mockPieceChecker{})
+	src := &mockPicker{idx: 5, found: true}
+	p, _ := makePeer(t, src)
 
 	// inject a failed request
 	failed := util.CreatePieceMessage(2, 0, uint32(torrent.BlockLength))
 	p.piecesQueue.RequestFailed(failed)
+	p.setHave(2)
 
 	// blockIdx >= blockNum so it goes into the retry path
 	msg := p.nextRequestMessage()
@@ -333,9 +408,23 @@ func TestNextRequestMessage_RetriesFailedPieceFirst(t *testing.T) {
 	assert.Equal(t, uint32(2), msg.Index(), "should retry the failed piece, not pick a new one")
 }
 
+func TestNextRequestMessage_SkipsFailedPieceNotHave(t *testing.T) {
+	src := &mockPicker{idx: 5, found: true}
+	p, _ := makePeer(t, src)
+
+	failed := util.CreatePieceMessage(2, 0, uint32(torrent.BlockLength))
+	p.piecesQueue.RequestFailed(failed)
+
+	msg := p.nextRequestMessage()
+
+	require.NotNil(t, msg)
+	assert.Equal(t, uint32(5), msg.Index(), "peer does not have the failed piece")
+	assert.Equal(t, failed, p.piecesQueue.FailedPieceMessage(bitset.New(8).Set(2)), "request is kept for other peers")
+}
+
 func TestNextRequestMessage_AllBlocksSent_FetchesNewPiece(t *testing.T) {
-	src := &mockPiecesSource{idx: 7, found: true}
-	p, _ := makePeer(t, src, &mockPieceChecker{})
+	src := &mockPicker{idx: 7, found: true}
+	p, _ := makePeer(t, src)
 	p.blockNum = 2
 	p.blockIdx = 2 // exhausted blocks for current piece
 
@@ -345,3 +434,19 @@ func TestNextRequestMessage_AllBlocksSent_FetchesNewPiece(t *testing.T) {
 	assert.Equal(t, uint32(7), msg.Index(), "should have fetched the next piece from source")
 	assert.Equal(t, uint(0), p.blockIdx-1, "blockIdx should have reset and incremented once")
 }
+
+func TestNextRequestMessage_LastPiece_ShorterLastBlock(t *testing.T) {
+	src := &mockPicker{idx: 9, found: true, size: int(torrent.BlockLength) + 100}
+	p, _ := makePeer(t, src)
+
+	first := p.nextRequestMessage()
+	require.NotNil(t, first)
+	assert.Equal(t, uint32(torrent.BlockLength), first.BlockLength())
+	assert.Equal(t, uint(2), p.blockNum)
+
+	last := p.nextRequestMessage()
+	require.NotNil(t, last)
+	assert.Equal(t, uint32(9), last.Index())
+	assert.Equal(t, uint32(torrent.BlockLength), last.Offset())
+	assert.Equal(t, uint32(100), last.BlockLength())
+}
@@ -0,0 +1,128 @@
+package peer
+
+import (
+	"math"
+	"slices"
+	"time"
+
+	"github.com/anivanovic/gotit/pkg/torrent"
+	"github.com/anivanovic/gotit/pkg/util"
+)
+
+const (
+	// DefaultMaxRequests is default limit of block requests outstanding
+	// to a single peer.
+	DefaultMaxRequests = 250
+	// minRequests are kept outstanding while peer rate is not known yet.
+	minRequests = 4
+	// requestQueueTime is time peer should need to send all outstanding
+	// blocks at its measured rate. It covers round trip to the peer, so
+	// peer always has requests to serve.
+	requestQueueTime = 3 * time.Second
+	// requestTimeout is time after which block not received from peer is
+	// requested again, from any peer.
+	requestTimeout = 30 * time.Second
+	// rateInterval is interval over which peer rate is measured.
+	rateInterval = time.Second
+)
+
+type blockRequest struct {
+	msg  *util.PeerMessage
+	sent time.Time
+}
+
+// requestQueue keeps block requests sent to peer, until blocks are
+// received. Queue size follows peer rate, so requests outstanding cover
+// bandwidth-delay product of the connection.
+type requestQueue struct {
+	maxRequests int
+	size        int
+	pending     []blockRequest
+
+	// rate is peer rate in bytes per second
+	rate      float64
+	received  int
+	rateStart time.Time
+}
+
+func newRequestQueue(maxRequests int) *requestQueue {
+	maxRequests = max(maxRequests, 1)
+	return &requestQueue{
+		maxRequests: maxRequests,
+		size:        min(minRequests, maxRequests),
+	}
+}
+
+// full reports whether enough requests are outstanding.
+func (q *requestQueue) full() bool {
+	return len(q.pending) >= q.size
+}
+
+func (q *requestQueue) empty() bool {
+	return len(q.pending) == 0
+}
+
+func (q *requestQueue) add(msg *util.PeerMessage, now time.Time) {
+	if q.empty() {
+		// time peer had nothing to send is not counted in its rate
+		q.received = 0
+		q.rateStart = now
+	}
+	q.pending = append(q.pending, blockRequest{msg: msg, sent: now})
+}
+
+// receive removes request of received block and updates peer rate.
+// It reports whether block was requested.
+func (q *requestQueue) receive(index, offset uint32, length int, now time.Time) bool {
+	q.measure(length, now)
+	for i, r := range q.pending {
+		if r.msg.Index() == index && r.msg.Offset() == offset {
+			q.pending = slices.Delete(q.pending, i, i+1)
+			return true
+		}
+	}
+	return false
+}
+
+func (q *requestQueue) measure(n int, now time.Time) {
+	q.received += n
+	elapsed := now.Sub(q.rateStart)
+	if elapsed < rateInterval {
+		return
+	}
+
+	sample := float64(q.received) / elapsed.Seconds()
+	if q.rate == 0 {
+		q.rate = sample
+	} else {
+		q.rate = 0.7*q.rate + 0.3*sample
+	}
+	q.received = 0
+	q.rateStart = now
+
+	blocks := int(math.Ceil(q.rate * requestQueueTime.Seconds() / float64(torrent.BlockLength)))
+	q.size = min(max(blocks, minRequests), q.maxRequests)
+}
+
+// expired removes and returns requests sent before requestTimeout.
+func (q *requestQueue) expired(now time.Time) []*util.PeerMessage {
+	var msgs []*util.PeerMessage
+	q.pending = slices.DeleteFunc(q.pending, func(r blockRequest) bool {
+		if now.Sub(r.sent) < requestTimeout {
+			return false
+		}
+		msgs = append(msgs, r.msg)
+		return true
+	})
+	return msgs
+}
+
+// drain removes and returns all outstanding requests.
+func (q *requestQueue) drain() []*util.PeerMessage {
+	msgs := make([]*util.PeerMessage, 0, len(q.pending))
+	for _, r := range q.pending {
+		msgs = append(msgs, r.msg)
+	}
+	q.pending = nil
+	return msgs
+}
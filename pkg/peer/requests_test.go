@@ -0,0 +1,84 @@
+package peer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anivanovic/gotit/pkg/torrent"
+	"github.com/anivanovic/gotit/pkg/util"
+)
+
+func blockRequestMsg(index, offset uint32) *util.PeerMessage {
+	return util.CreatePieceMessage(index, offset*uint32(torrent.BlockLength), uint32(torrent.BlockLength))
+}
+
+func TestRequestQueue_StartsWithMinRequests(t *testing.T) {
+	q := newRequestQueue(DefaultMaxRequests)
+	now := time.Now()
+	for i := 0; i < minRequests; i++ {
+		assert.False(t, q.full())
+		q.add(blockRequestMsg(0, uint32(i)), now)
+	}
+	assert.True(t, q.full())
+
+	assert.True(t, newRequestQueue(0).empty())
+	assert.Equal(t, 1, newRequestQueue(0).size)
+	assert.Equal(t, 2, newRequestQueue(2).size)
+}
+
+func TestRequestQueue_SizeFollowsRate(t *testing.T) {
+	q := newRequestQueue(DefaultMaxRequests)
+	start := time.Now()
+	q.add(blockRequestMsg(0, 0), start)
+
+	// 100 blocks per second keep 300 blocks outstanding, over the limit
+	assert.True(t, q.receive(0, 0, 100*int(torrent.BlockLength), start.Add(time.Second)))
+	assert.Equal(t, DefaultMaxRequests, q.size)
+
+	// rate drops to ten blocks per second, requests cover the queue time
+	now := start.Add(time.Second)
+	for i := 0; i < 20; i++ {
+		now = now.Add(time.Second)
+		q.receive(0, 0, 10*int(torrent.BlockLength), now)
+	}
+	assert.InDelta(t, 10*requestQueueTime.Seconds(), q.size, 1)
+
+	// slow peer keeps minimum of requests
+	for i := 0; i < 20; i++ {
+		now = now.Add(time.Second)
+		q.receive(0, 0, 0, now)
+	}
+	assert.Equal(t, minRequests, q.size)
+}
+
+func TestRequestQueue_Receive(t *testing.T) {
+	q := newRequestQueue(DefaultMaxRequests)
+	now := time.Now()
+	q.add(blockRequestMsg(1, 0), now)
+	q.add(blockRequestMsg(1, 1), now)
+
+	assert.False(t, q.receive(2, 0, 10, now), "block was not requested")
+	assert.True(t, q.receive(1, uint32(torrent.BlockLength), 10, now))
+	require.Len(t, q.pending, 1)
+	assert.Equal(t, uint32(0), q.pending[0].msg.Offset())
+}
+
+func TestRequestQueue_Expired(t *testing.T) {
+	q := newRequestQueue(DefaultMaxRequests)
+	now := time.Now()
+	q.add(blockRequestMsg(1, 0), now)
+	q.add(blockRequestMsg(1, 1), now.Add(time.Second))
+
+	assert.Empty(t, q.expired(now.Add(requestTimeout-time.Millisecond)))
+
+	expired := q.expired(now.Add(requestTimeout))
+	require.Len(t, expired, 1)
+	assert.Equal(t, uint32(0), expired[0].Offset())
+	assert.Len(t, q.pending, 1)
+
+	assert.Len(t, q.drain(), 1)
+	assert.True(t, q.empty())
+}
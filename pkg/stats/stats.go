@@ -31,6 +31,14 @@ func NewStats(torrentSize uint64) *Stats {
 	}
 }
 
+// Restore sets transfer totals of resumed download, with left bytes still
+// to be downloaded.
+func (ts *Stats) Restore(download, upload, left uint64) {
+	atomic.StoreUint64(&ts.download, download)
+	atomic.StoreUint64(&ts.upload, upload)
+	atomic.StoreUint64(&ts.left, left)
+}
+
 func (ts *Stats) AddDownload(size uint64) {
 	atomic.AddUint64(&ts.download, size)
 	atomic.AddUint64(&ts.left, -size)
@@ -82,9 +90,10 @@ func (ts *Stats) TrackerNum() uint64 {
 }
 
 func (ts *Stats) PercentCompleted() int {
-	if ts.torrentSize == 0 || ts.Download() == 0 {
+	if ts.torrentSize == 0 || ts.Left() >= ts.torrentSize {
 		return 0
 	}
 
-	return int(math.Round((float64(ts.Download()) / float64(ts.torrentSize)) * 100.0))
+	// left is used, as resumed download has data not downloaded in this run
+	return int(math.Round((float64(ts.torrentSize-ts.Left()) / float64(ts.torrentSize)) * 100.0))
 }
@@ -0,0 +1,159 @@
+package torrent
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/bits-and-blooms/bitset"
+	"go.uber.org/zap"
+
+	"github.com/anivanovic/gotit/pkg/stats"
+	"github.com/anivanovic/gotit/pkg/util"
+)
+
+// Block is part of piece data downloaded from a peer or web seed.
+type Block struct {
+	Index  int
+	Offset int
+	Data   []byte
+	// Source identifies peer or web seed block was downloaded from.
+	Source string
+}
+
+// pieceBuffer collects blocks of a piece, until whole piece can be
+// checked. Blocks start at BlockLength boundary and end at the next one,
+// or at the end of the piece. Longer blocks, as sent by web seeds, cover
+// multiple blocks.
+type pieceBuffer struct {
+	data     []byte
+	received *bitset.BitSet
+	// sources contributed blocks to the piece
+	sources util.StringSet
+}
+
+func newPieceBuffer(size int) *pieceBuffer {
+	blocks := (size + int(BlockLength) - 1) / int(BlockLength)
+	return &pieceBuffer{
+		data:     make([]byte, size),
+		received: bitset.New(uint(blocks)),
+		sources:  util.NewStringSet(),
+	}
+}
+
+func (b *pieceBuffer) add(block *Block) error {
+	end := block.Offset + len(block.Data)
+	switch {
+	case len(block.Data) == 0:
+		return errors.New("empty block")
+	case block.Offset < 0 || block.Offset%int(BlockLength) != 0:
+		return fmt.Errorf("block offset %d not aligned", block.Offset)
+	case end > len(b.data):
+		return fmt.Errorf("block ends at %d, beyond piece length %d", end, len(b.data))
+	case end != len(b.data) && end%int(BlockLength) != 0:
+		return fmt.Errorf("block length %d not aligned", len(block.Data))
+	}
+
+	copy(b.data[block.Offset:], block.Data)
+	for i := block.Offset / int(BlockLength); i*int(BlockLength) < end; i++ {
+		b.received.Set(uint(i))
+	}
+	b.sources.Add(block.Source)
+	return nil
+}
+
+func (b *pieceBuffer) complete() bool {
+	return b.received.All()
+}
+
+// WritePiece assembles blocks received from blocksCh into pieces. Once
+// all blocks of a piece are received, piece is checked and written to
+// storage. Piece failing the check is discarded and made available for
+// download again.
+func (t *Torrent) WritePiece(blocksCh <-chan *Block, stats *stats.Stats) {
+	pending := make(map[int]*pieceBuffer)
+	for block := range blocksCh {
+		stats.AddDownload(uint64(len(block.Data)))
+
+		if block.Index < 0 || block.Index >= t.PiecesNum {
+			t.logger.Warn("Discarding block of unknown piece",
+				zap.Int("index", block.Index),
+				zap.String("source", block.Source))
+			continue
+		}
+		if t.isDownloaded(block.Index) {
+			continue
+		}
+
+		buf := pending[block.Index]
+		if buf == nil {
+			buf = newPieceBuffer(t.PieceSize(block.Index))
+			pending[block.Index] = buf
+		}
+		if err := buf.add(block); err != nil {
+			t.logger.Warn("Discarding invalid block",
+				zap.Int("index", block.Index),
+				zap.Int("offset", block.Offset),
+				zap.String("source", block.Source),
+				zap.Error(err))
+			continue
+		}
+		if !buf.complete() {
+			continue
+		}
+
+		delete(pending, block.Index)
+		t.writeAssembled(block.Index, buf)
+	}
+
+	t.logger.Debug("Finished writing pieces")
+}
+
+// writeAssembled checks and writes whole piece.
+func (t *Torrent) writeAssembled(index int, buf *pieceBuffer) {
+	if !t.CheckPiece(buf.data, index) {
+		t.logger.Warn("Discarding corrupted piece. Hash check failed.",
+			zap.Int("index", index),
+			zap.Strings("sources", buf.sources.Values()))
+		t.recordCorrupt(buf.sources)
+		t.PieceFailed(uint(index))
+		return
+	}
+
+	if err := t.storage.WriteAt(index, buf.data, 0); err != nil {
+		t.logger.Error("Failed to write piece",
+			zap.Int("index", index),
+			zap.Error(err))
+		t.PieceFailed(uint(index))
+		return
+	}
+	t.SetDownloaded(uint(index))
+}
+
+// corruptPieces counts pieces failing hash check by sources contributing
+// to them.
+type corruptPieces struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (t *Torrent) recordCorrupt(sources util.StringSet) {
+	t.corrupt.mu.Lock()
+	defer t.corrupt.mu.Unlock()
+
+	if t.corrupt.counts == nil {
+		t.corrupt.counts = make(map[string]int)
+	}
+	for source := range sources {
+		t.corrupt.counts[source]++
+	}
+}
+
+// CorruptPieces returns number of pieces failing hash check, which had
+// blocks downloaded from source.
+func (t *Torrent) CorruptPieces(source string) int {
+	t.corrupt.mu.Lock()
+	defer t.corrupt.mu.Unlock()
+
+	return t.corrupt.counts[source]
+}
@@ -0,0 +1,136 @@
+package torrent
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/anivanovic/gotit/pkg/bencode"
+)
+
+// assemblyTorrent returns torrent of two whole pieces of two blocks and
+// the last piece with shorter last block, kept in memory.
+func assemblyTorrent(t *testing.T) (*Torrent, []byte) {
+	t.Helper()
+	pieceLength := 2 * int(BlockLength)
+	data := bytes.Repeat([]byte("assembly"), (2*pieceLength+int(BlockLength)+100)/8)
+
+	m := &bencode.Metainfo{Announce: "udp://tracker"}
+	m.Info.Name = "assembly"
+	m.Info.Length = int64(len(data))
+	m.Info.PieceLength = int64(pieceLength)
+	for begin := 0; begin < len(data); begin += pieceLength {
+		m.Info.Pieces += string(sha1Of(data[begin:min(begin+pieceLength, len(data))]))
+	}
+
+	tor, err := NewWithStorage(m, func(t *Torrent) (Storage, error) {
+		return NewMemoryStorage(t), nil
+	}, zap.NewNop())
+	require.NoError(t, err)
+	t.Cleanup(func() { tor.Close() })
+	return tor, data
+}
+
+// block returns block of piece index at offset, with data of the torrent.
+func block(tor *Torrent, data []byte, index, offset int, source string) *Block {
+	begin := index*tor.PieceLength + offset
+	end := min(begin+int(BlockLength), index*tor.PieceLength+tor.PieceSize(index))
+	return &Block{Index: index, Offset: offset, Data: data[begin:end], Source: source}
+}
+
+func TestWritePiece_AssemblesBlocks(t *testing.T) {
+	tor, data := assemblyTorrent(t)
+	bl := int(BlockLength)
+	require.Equal(t, 3, tor.PiecesNum)
+
+	writeBlocks(tor,
+		// last piece blocks in reverse order, last block is shorter
+		block(tor, data, 2, bl, "a"),
+		block(tor, data, 2, 0, "b"),
+		block(tor, data, 0, 0, "a"),
+		block(tor, data, 1, bl, "b"),
+		block(tor, data, 0, bl, "b"),
+	)
+
+	assert.True(t, tor.downloaded.Test(0))
+	assert.False(t, tor.downloaded.Test(1), "piece 1 is missing a block")
+	assert.True(t, tor.downloaded.Test(2))
+	assert.ErrorIs(t, tor.storage.ReadAt(1, make([]byte, bl), bl), errPieceMissing)
+
+	for _, i := range []int{0, 2} {
+		got := make([]byte, tor.PieceSize(i))
+		require.NoError(t, tor.storage.ReadAt(i, got, 0))
+		assert.Equal(t, data[i*tor.PieceLength:i*tor.PieceLength+len(got)], got, "piece %d", i)
+	}
+}
+
+func TestWritePiece_CorruptPiece(t *testing.T) {
+	tor, data := assemblyTorrent(t)
+	picker := NewRarestFirst(tor)
+	index, found := picker.Next(tor.EmptyBitset().Set(0))
+	require.True(t, found)
+	require.Equal(t, uint(0), index)
+
+	corrupt := block(tor, data, 0, int(BlockLength), "bad")
+	corrupt.Data = bytes.ToUpper(corrupt.Data)
+	writeBlocks(tor, block(tor, data, 0, 0, "good"), corrupt)
+
+	assert.False(t, tor.downloaded.Test(0))
+	assert.ErrorIs(t, tor.storage.ReadAt(0, make([]byte, 1), 0), errPieceMissing)
+	assert.Equal(t, 1, tor.CorruptPieces("bad"))
+	assert.Equal(t, 1, tor.CorruptPieces("good"))
+	assert.Zero(t, tor.CorruptPieces("other"))
+
+	// failed piece is available for download again
+	index, found = picker.Next(tor.EmptyBitset().Set(0))
+	require.True(t, found)
+	assert.Equal(t, uint(0), index)
+
+	// blocks received before failure are not kept
+	writeBlocks(tor, block(tor, data, 0, int(BlockLength), "other"))
+	assert.False(t, tor.downloaded.Test(0))
+	writeBlocks(tor, block(tor, data, 0, 0, "other"), block(tor, data, 0, int(BlockLength), "other"))
+	assert.True(t, tor.downloaded.Test(0))
+}
+
+func TestWritePiece_WholePiece(t *testing.T) {
+	tor, data := assemblyTorrent(t)
+
+	writeBlocks(tor, &Block{Index: 2, Data: data[2*tor.PieceLength:], Source: "http://seed"})
+
+	assert.True(t, tor.downloaded.Test(2))
+}
+
+func TestPieceBuffer_Add(t *testing.T) {
+	bl := int(BlockLength)
+	size := bl + 100
+
+	tests := []struct {
+		name   string
+		offset int
+		length int
+		valid  bool
+	}{
+		{name: "first block", offset: 0, length: bl, valid: true},
+		{name: "short last block", offset: bl, length: 100, valid: true},
+		{name: "whole piece", offset: 0, length: size, valid: true},
+		{name: "empty", offset: 0, length: 0},
+		{name: "unaligned offset", offset: 10, length: bl},
+		{name: "beyond piece", offset: bl, length: 101},
+		{name: "short block", offset: 0, length: bl - 1},
+		{name: "negative offset", offset: -bl, length: bl},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := newPieceBuffer(size).add(&Block{Offset: tt.offset, Data: make([]byte, tt.length)})
+			if tt.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,387 @@
+package torrent
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/bits-and-blooms/bitset"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+
+	"github.com/anivanovic/gotit/pkg/bencode"
+)
+
+var errFileMissing = errors.New("file missing")
+
+// FileStorage keeps torrent data in torrent files, as laid out in the
+// torrent. Single file torrent is stored in file named by the torrent,
+// while directory torrent files are stored in torrent directory.
+type FileStorage struct {
+	logger      *zap.Logger
+	pieceLength int
+	single      bool
+
+	// dir is torrent download directory, or file for single file torrent.
+	dir   string
+	files []bencode.TorrentFile
+	paths []string
+	// osFiles are open files matching files. Padding files, symlinks and
+	// missing files have nil file.
+	osFiles []*os.File
+
+	mu       sync.Mutex
+	complete *bitset.BitSet
+	// finalized marks files with attributes applied after completion
+	finalized *bitset.BitSet
+}
+
+// NewFileStorage creates files of torrent t in root directory. Data of
+// existing files is kept, so download can be resumed.
+func NewFileStorage(t *Torrent, root string) (*FileStorage, error) {
+	s, err := newFileStorage(t, root)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.create(root); err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// openFileStorage opens existing files of torrent t in root directory
+// for reading. Missing files are left out.
+func openFileStorage(t *Torrent, root string) (*FileStorage, error) {
+	s, err := newFileStorage(t, root)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, path := range s.paths {
+		if path == "" || s.files[i].IsSymlink() {
+			s.osFiles = append(s.osFiles, nil)
+			continue
+		}
+
+		f, err := os.Open(path)
+		if err != nil && !os.IsNotExist(err) {
+			_ = s.Close()
+			return nil, err
+		}
+		s.osFiles = append(s.osFiles, f)
+	}
+	return s, nil
+}
+
+// newFileStorage resolves paths of torrent files in root directory.
+// Padding files have no path.
+func newFileStorage(t *Torrent, root string) (*FileStorage, error) {
+	s := &FileStorage{
+		logger:      t.logger,
+		pieceLength: t.PieceLength,
+		single:      !t.IsDirectory,
+		dir:         filepath.Join(root, t.Name),
+		complete:    bitset.New(uint(t.PiecesNum)),
+	}
+	if s.single {
+		s.files = []bencode.TorrentFile{{Path: []string{t.Name}, Length: t.Length}}
+		s.paths = []string{s.dir}
+		return s, nil
+	}
+
+	s.files = t.TorrentFiles
+	s.paths = make([]string, len(s.files))
+	s.finalized = bitset.New(uint(len(s.files)))
+	seen := make(map[string]string, len(s.files))
+	for i, tf := range s.files {
+		if tf.IsPad() {
+			continue
+		}
+		filePath, err := sanitizePath(tf.Path)
+		if err != nil {
+			return nil, fmt.Errorf("file %s: %w", tf.FilePath(), err)
+		}
+		if tf.IsSymlink() {
+			// reject invalid targets before any file is created
+			if _, err := sanitizePath(tf.SymlinkPath); err != nil {
+				return nil, fmt.Errorf("symlink %s: %w", tf.FilePath(), err)
+			}
+		}
+		// different torrent paths can sanitize to the same file
+		if other, ok := seen[filePath]; ok {
+			return nil, fmt.Errorf("file %s: %w: same path as %s", tf.FilePath(), ErrInvalidPath, other)
+		}
+		seen[filePath] = tf.FilePath()
+		s.paths[i] = filepath.Join(s.dir, filePath)
+	}
+	return s, nil
+}
+
+func (s *FileStorage) create(root string) error {
+	if s.single {
+		if err := os.MkdirAll(root, os.ModePerm); err != nil {
+			return err
+		}
+		f, err := openDataFile(s.dir)
+		if err != nil {
+			return err
+		}
+		s.osFiles = append(s.osFiles, f)
+		return nil
+	}
+
+	for i, tf := range s.files {
+		// padding files are only counted for piece offsets, while
+		// symlinks are created when finalized
+		if tf.IsPad() || tf.IsSymlink() {
+			s.osFiles = append(s.osFiles, nil)
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(s.paths[i]), os.ModePerm); err != nil {
+			return err
+		}
+		f, err := openDataFile(s.paths[i])
+		if err != nil {
+			return err
+		}
+		s.osFiles = append(s.osFiles, f)
+	}
+
+	// empty files are complete from the start
+	for i, tf := range s.files {
+		if tf.Length != 0 || tf.IsPad() {
+			continue
+		}
+		s.finalized.Set(uint(i))
+		if err := s.finalizeFile(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openDataFile opens file for reading and writing, creating it if it does
+// not exist.
+func openDataFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o666)
+}
+
+func (s *FileStorage) ReadAt(index int, p []byte, off int) error {
+	return s.readAt(p, index*s.pieceLength+off)
+}
+
+func (s *FileStorage) WriteAt(index int, p []byte, off int) error {
+	return s.writeAt(p, index*s.pieceLength+off)
+}
+
+// MarkComplete applies attributes of files completed with the piece.
+func (s *FileStorage) MarkComplete(index int) error {
+	s.mu.Lock()
+	s.complete.Set(uint(index))
+	completed := s.completedFiles(uint(index))
+	s.mu.Unlock()
+
+	var err error
+	for _, i := range completed {
+		if ferr := s.finalizeFile(i); ferr != nil {
+			err = multierr.Append(err, fmt.Errorf("finalize %s: %w", s.files[i].FilePath(), ferr))
+		}
+	}
+	return err
+}
+
+// Close closes torrent files.
+func (s *FileStorage) Close() error {
+	var err error
+	for _, f := range s.osFiles {
+		if f == nil {
+			continue
+		}
+		err = multierr.Append(err, f.Close())
+	}
+	return err
+}
+
+// missing reports whether torrent file i should exist, but was not found.
+func (s *FileStorage) missing(i int) bool {
+	return !s.files[i].IsPad() && !s.files[i].IsSymlink() && s.osFiles[i] == nil
+}
+
+// writeAt writes data at the given absolute torrent byte position,
+// spanning across multiple files as needed.
+func (s *FileStorage) writeAt(data []byte, pos int) error {
+	if s.single {
+		_, err := s.osFiles[0].WriteAt(data, int64(pos))
+		return err
+	}
+
+	// Find the file where piece should be written to.
+	fileIdx := 0
+	for fileIdx < len(s.files) {
+		if s.files[fileIdx].Length > pos {
+			break
+		}
+		pos -= s.files[fileIdx].Length
+		fileIdx++
+	}
+	if fileIdx >= len(s.files) {
+		return errors.New("piece position beyond all torrent files")
+	}
+
+	// Write data, advancing to the next file whenever the current one is full.
+	for len(data) > 0 {
+		if fileIdx >= len(s.files) {
+			return errors.New("data extends beyond torrent files")
+		}
+
+		available := s.files[fileIdx].Length - pos
+		toWrite := min(len(data), available)
+
+		if s.osFiles[fileIdx] == nil {
+			// padding files and symlinks have no data on disk
+			data = data[toWrite:]
+			pos = 0
+			fileIdx++
+			continue
+		}
+
+		s.logger.Debug("Writing to file",
+			zap.String("file", s.files[fileIdx].FilePath()),
+			zap.Int("position", pos),
+			zap.Int("bytes", toWrite))
+
+		if _, err := s.osFiles[fileIdx].WriteAt(data[:toWrite], int64(pos)); err != nil {
+			return err
+		}
+
+		data = data[toWrite:]
+		pos = 0
+		fileIdx++
+	}
+
+	return nil
+}
+
+// readAt reads data at the given absolute torrent byte position,
+// spanning across multiple files as needed. Padding files read as zeros.
+func (s *FileStorage) readAt(data []byte, pos int) error {
+	if s.single {
+		return readFull(s.osFiles[0], data, pos)
+	}
+
+	offset := 0
+	for i, f := range s.files {
+		begin, end := offset, offset+f.Length
+		offset = end
+		if end <= pos || f.Length == 0 {
+			continue
+		}
+		if len(data) == 0 {
+			break
+		}
+
+		n := min(len(data), end-pos)
+		switch {
+		case f.IsPad():
+			clear(data[:n])
+		case s.osFiles[i] == nil:
+			return fmt.Errorf("%s: %w", f.FilePath(), errFileMissing)
+		default:
+			if err := readFull(s.osFiles[i], data[:n], pos-begin); err != nil {
+				return err
+			}
+		}
+		data = data[n:]
+		pos += n
+	}
+
+	if len(data) != 0 {
+		return errors.New("data extends beyond torrent files")
+	}
+	return nil
+}
+
+func readFull(f *os.File, data []byte, pos int) error {
+	if f == nil {
+		return errFileMissing
+	}
+	n, err := f.ReadAt(data, int64(pos))
+	if n == len(data) {
+		return nil
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("%s: %w", f.Name(), err)
+}
+
+// completedFiles returns files, not finalized before, which have all
+// pieces complete once piece is complete. Files are marked finalized.
+func (s *FileStorage) completedFiles(piece uint) []int {
+	if s.finalized == nil {
+		return nil
+	}
+
+	var files []int
+	pieceBegin := int(piece) * s.pieceLength
+	pieceEnd := pieceBegin + s.pieceLength
+	offset := 0
+	for i, tf := range s.files {
+		begin, end := offset, offset+tf.Length
+		offset = end
+		if tf.Length == 0 || tf.IsPad() || end <= pieceBegin || begin >= pieceEnd || s.finalized.Test(uint(i)) {
+			continue
+		}
+
+		complete := true
+		for p := begin / s.pieceLength; p <= (end-1)/s.pieceLength; p++ {
+			if !s.complete.Test(uint(p)) {
+				complete = false
+				break
+			}
+		}
+		if complete {
+			s.finalized.Set(uint(i))
+			files = append(files, i)
+		}
+	}
+	return files
+}
+
+// finalizeFile applies attributes of completed file (BEP 47). Symlinks
+// are created and executable files get execute permission wherever they
+// can be read.
+func (s *FileStorage) finalizeFile(i int) error {
+	tf := s.files[i]
+	path := s.paths[i]
+
+	switch {
+	case tf.IsSymlink():
+		target, err := sanitizePath(tf.SymlinkPath)
+		if err != nil {
+			return fmt.Errorf("symlink %s: %w", tf.FilePath(), err)
+		}
+		rel, err := filepath.Rel(filepath.Dir(path), filepath.Join(s.dir, target))
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return os.Symlink(rel, path)
+	case tf.IsExecutable():
+		stat, err := s.osFiles[i].Stat()
+		if err != nil {
+			return err
+		}
+		mode := stat.Mode().Perm()
+		return s.osFiles[i].Chmod(mode | (mode&0o444)>>2)
+	}
+
+	return nil
+}
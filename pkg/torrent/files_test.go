@@ -0,0 +1,76 @@
+package torrent
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anivanovic/gotit/pkg/bencode"
+)
+
+func TestNewFileStorage_SkipsPadFiles(t *testing.T) {
+	dir := t.TempDir()
+	tor := makeMultiFileTorrent(t, dir, []bencode.TorrentFile{
+		{Path: []string{"a.bin"}, Length: 3},
+		{Path: []string{".pad", "5"}, Length: 5, Attr: "p"},
+		{Path: []string{"b.bin"}, Length: 4},
+	}, 8)
+
+	assert.NoDirExists(t, filepath.Join(dir, "multi", ".pad"))
+	assert.Nil(t, osFile(t, tor, 1))
+
+	// piece 1 starts at b.bin, after padding
+	setContent(t, tor, []byte("AAA\x00\x00\x00\x00\x00BBBB"))
+	writeBlocks(tor, &Block{Index: 1, Data: []byte("BBBB")})
+	assert.Equal(t, []byte("BBBB"), readAt(t, osFile(t, tor, 2), 0, 4))
+}
+
+func TestSetDownloaded_AppliesExecutableBit(t *testing.T) {
+	dir := t.TempDir()
+	tor := makeMultiFileTorrent(t, dir, []bencode.TorrentFile{
+		{Path: []string{"run.sh"}, Length: 12, Attr: "x"},
+		{Path: []string{"data"}, Length: 4},
+	}, 8)
+
+	tor.SetDownloaded(0)
+	stat, err := os.Stat(filepath.Join(dir, "multi", "run.sh"))
+	require.NoError(t, err)
+	assert.Zero(t, stat.Mode()&0o100, "file is not complete yet")
+
+	tor.SetDownloaded(1)
+	stat, err = os.Stat(filepath.Join(dir, "multi", "run.sh"))
+	require.NoError(t, err)
+	assert.NotZero(t, stat.Mode()&0o100)
+
+	stat, err = os.Stat(filepath.Join(dir, "multi", "data"))
+	require.NoError(t, err)
+	assert.Zero(t, stat.Mode()&0o111)
+}
+
+func TestNewFileStorage_Symlink(t *testing.T) {
+	dir := t.TempDir()
+	makeMultiFileTorrent(t, dir, []bencode.TorrentFile{
+		{Path: []string{"dir", "target"}, Length: 4},
+		{Path: []string{"link"}, Length: 0, Attr: "l", SymlinkPath: []string{"dir", "target"}},
+	}, 8)
+
+	link := filepath.Join(dir, "multi", "link")
+	target, err := os.Readlink(link)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join("dir", "target"), target)
+}
+
+func TestNewFileStorage_SymlinkTraversal(t *testing.T) {
+	tor := &Torrent{
+		TorrentFiles: []bencode.TorrentFile{
+			{Path: []string{"link"}, Attr: "l", SymlinkPath: []string{"..", "..", "etc"}},
+		},
+		Name:        "torrent",
+		IsDirectory: true,
+	}
+	_, err := NewFileStorage(tor, t.TempDir())
+	assert.ErrorIs(t, err, ErrInvalidPath)
+}
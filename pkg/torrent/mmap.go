@@ -0,0 +1,112 @@
+//go:build unix
+
+package torrent
+
+import (
+	"errors"
+	"os"
+	"sync"
+
+	"go.uber.org/multierr"
+	"golang.org/x/sys/unix"
+)
+
+// MmapStorage keeps torrent data in single file mapped into memory.
+// Torrent files are stored one after another, padding files included,
+// so piece data starts at index*PieceLength.
+type MmapStorage struct {
+	pieceLength int
+	length      int
+	file        *os.File
+
+	mu   sync.RWMutex
+	data []byte
+}
+
+// NewMmapStorage opens or creates file at path, sized to torrent t
+// length, and maps it into memory. Existing file data is kept.
+func NewMmapStorage(t *Torrent, path string) (*MmapStorage, error) {
+	if t.Length <= 0 {
+		return nil, errors.New("torrent has no data to map")
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Truncate(int64(t.Length)); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	data, err := unix.Mmap(int(f.Fd()), 0, t.Length, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return &MmapStorage{
+		pieceLength: t.PieceLength,
+		length:      t.Length,
+		file:        f,
+		data:        data,
+	}, nil
+}
+
+func (s *MmapStorage) ReadAt(index int, p []byte, off int) error {
+	if err := checkRange(s.pieceLength, s.length, index, off, len(p)); err != nil {
+		return err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.data == nil {
+		return os.ErrClosed
+	}
+	copy(p, s.data[index*s.pieceLength+off:])
+	return nil
+}
+
+func (s *MmapStorage) WriteAt(index int, p []byte, off int) error {
+	if err := checkRange(s.pieceLength, s.length, index, off, len(p)); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data == nil {
+		return os.ErrClosed
+	}
+	copy(s.data[index*s.pieceLength+off:], p)
+	return nil
+}
+
+// MarkComplete flushes piece data to the file.
+func (s *MmapStorage) MarkComplete(index int) error {
+	if err := checkRange(s.pieceLength, s.length, index, 0, 0); err != nil {
+		return err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.data == nil {
+		return os.ErrClosed
+	}
+	// msync needs page aligned address
+	page := os.Getpagesize()
+	begin := index * s.pieceLength / page * page
+	end := min(s.length, (index+1)*s.pieceLength)
+	return unix.Msync(s.data[begin:end], unix.MS_SYNC)
+}
+
+// Close unmaps and closes the file.
+func (s *MmapStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data == nil {
+		return nil
+	}
+
+	err := unix.Munmap(s.data)
+	s.data = nil
+	return multierr.Append(err, s.file.Close())
+}
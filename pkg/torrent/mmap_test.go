@@ -0,0 +1,39 @@
+//go:build unix
+
+package torrent
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMmapStorage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.data")
+	s, err := NewMmapStorage(storageTorrent(t), path)
+	require.NoError(t, err)
+	testStorage(t, s)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("abcdefghijklmnopqrst"), data)
+
+	assert.ErrorIs(t, s.ReadAt(0, make([]byte, 1), 0), os.ErrClosed)
+	assert.NoError(t, s.Close())
+}
+
+func TestMmapStorage_KeepsData(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.data")
+	require.NoError(t, os.WriteFile(path, []byte("abcdefghijklmnopqrst"), 0o644))
+
+	s, err := NewMmapStorage(storageTorrent(t), path)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	got := make([]byte, 4)
+	require.NoError(t, s.ReadAt(2, got, 0))
+	assert.Equal(t, []byte("qrst"), got)
+}
@@ -0,0 +1,67 @@
+package torrent
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+var ErrInvalidPath = errors.New("invalid torrent path")
+
+// reservedNames can not be used as file names on Windows, with or
+// without extension.
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// sanitizeName makes torrent name or single path element safe to use as
+// file name. Path separators can not escape download directory, as they
+// are replaced together with characters invalid on any supported OS.
+func sanitizeName(name string) (string, error) {
+	name = strings.ToValidUTF8(name, "_")
+	if name == "" || name == "." || name == ".." {
+		return "", fmt.Errorf("%w: %q", ErrInvalidPath, name)
+	}
+
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+
+	// Windows drops trailing dots and spaces
+	if trimmed := strings.TrimRight(name, ". "); trimmed != name {
+		name = trimmed + "_"
+	}
+
+	base, _, _ := strings.Cut(name, ".")
+	if reservedNames[strings.ToUpper(base)] {
+		name = "_" + name
+	}
+
+	return name, nil
+}
+
+// sanitizePath returns file path relative to torrent directory built from
+// torrent file path elements.
+func sanitizePath(elements []string) (string, error) {
+	if len(elements) == 0 {
+		return "", fmt.Errorf("%w: empty path", ErrInvalidPath)
+	}
+
+	names := make([]string, len(elements))
+	for i, el := range elements {
+		name, err := sanitizeName(el)
+		if err != nil {
+			return "", err
+		}
+		names[i] = name
+	}
+	return filepath.Join(names...), nil
+}
@@ -0,0 +1,106 @@
+package torrent
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anivanovic/gotit/pkg/bencode"
+)
+
+func TestSanitizeName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "file.txt", want: "file.txt"},
+		{name: ".pad", want: ".pad"},
+		{name: "a/b", want: "a_b"},
+		{name: "/etc", want: "_etc"},
+		{name: `..\..\x`, want: ".._.._x"},
+		{name: "C:", want: "C_"},
+		{name: "what?*", want: "what__"},
+		{name: "tab\tname", want: "tab_name"},
+		{name: "bad\xffutf8", want: "bad_utf8"},
+		{name: "CON", want: "_CON"},
+		{name: "nul.txt", want: "_nul.txt"},
+		{name: "console", want: "console"},
+		{name: "dots...", want: "dots_"},
+		{name: "space ", want: "space_"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sanitizeName(tt.name)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestSanitizeName_Invalid(t *testing.T) {
+	for _, name := range []string{"", ".", ".."} {
+		_, err := sanitizeName(name)
+		assert.ErrorIs(t, err, ErrInvalidPath, "name %q", name)
+	}
+}
+
+func TestSanitizePath(t *testing.T) {
+	got, err := sanitizePath([]string{"dir", "sub", "file"})
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join("dir", "sub", "file"), got)
+
+	_, err = sanitizePath([]string{"dir", "..", "..", "file"})
+	assert.ErrorIs(t, err, ErrInvalidPath)
+
+	_, err = sanitizePath(nil)
+	assert.ErrorIs(t, err, ErrInvalidPath)
+}
+
+func TestNewFileStorage_NestedFiles(t *testing.T) {
+	dir := t.TempDir()
+	tor := &Torrent{
+		TorrentFiles: []bencode.TorrentFile{
+			{Path: []string{"a", "1.txt"}, Length: 1},
+			{Path: []string{"a", "b", "1.txt"}, Length: 1},
+			{Path: []string{"1.txt"}, Length: 1},
+		},
+		Name:        "torrent",
+		IsDirectory: true,
+	}
+	createFileStorage(t, tor, dir)
+
+	assert.FileExists(t, filepath.Join(dir, "torrent", "a", "1.txt"))
+	assert.FileExists(t, filepath.Join(dir, "torrent", "a", "b", "1.txt"))
+	assert.FileExists(t, filepath.Join(dir, "torrent", "1.txt"))
+	assert.Len(t, tor.storage.(*FileStorage).osFiles, 3)
+}
+
+func TestNewFileStorage_Traversal(t *testing.T) {
+	tor := &Torrent{
+		TorrentFiles: []bencode.TorrentFile{{Path: []string{"..", "escape"}, Length: 1}},
+		Name:         "torrent",
+		IsDirectory:  true,
+	}
+	_, err := NewFileStorage(tor, t.TempDir())
+	assert.ErrorIs(t, err, ErrInvalidPath)
+}
+
+func TestNewFileStorage_DuplicatePaths(t *testing.T) {
+	tor := &Torrent{
+		TorrentFiles: []bencode.TorrentFile{
+			{Path: []string{"a:b"}, Length: 1},
+			{Path: []string{"a?b"}, Length: 1},
+		},
+		Name:        "torrent",
+		IsDirectory: true,
+	}
+	_, err := newFileStorage(tor, t.TempDir())
+	assert.ErrorIs(t, err, ErrInvalidPath)
+	assert.ErrorContains(t, err, "same path as")
+
+	tor.TorrentFiles[1].Path = []string{"a", "b"}
+	_, err = newFileStorage(tor, t.TempDir())
+	assert.NoError(t, err)
+}
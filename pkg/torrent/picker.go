@@ -0,0 +1,126 @@
+package torrent
+
+import (
+	"math/rand/v2"
+	"sync"
+
+	"github.com/bits-and-blooms/bitset"
+)
+
+// RarestFirst picks pieces least available from connected peers first,
+// so rare pieces spread before peers having them leave. Pieces equally
+// available are picked at random, so peers download different pieces.
+// Pieces abandoned by peers are finished before new pieces are started.
+type RarestFirst struct {
+	t *Torrent
+
+	mu sync.Mutex
+	// availability counts peers having each piece
+	availability []int
+	// started are pieces with blocks downloaded, which were abandoned
+	started *bitset.BitSet
+}
+
+func NewRarestFirst(t *Torrent) *RarestFirst {
+	return &RarestFirst{
+		t:            t,
+		availability: make([]int, t.PiecesNum),
+		started:      bitset.New(uint(t.PiecesNum)),
+	}
+}
+
+// Next claims piece to download from peer having pieces have.
+func (r *RarestFirst) Next(have *bitset.BitSet) (uint, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.t.requestedMu.Lock()
+	defer r.t.requestedMu.Unlock()
+
+	index, found := r.rarest(have, r.started)
+	if !found {
+		index, found = r.rarest(have, nil)
+	}
+	if !found {
+		return 0, false
+	}
+
+	r.started.Clear(index)
+	r.t.requested.Set(index)
+	return index, true
+}
+
+// rarest returns least available piece of have, which is not requested.
+// Only pieces in among are considered, unless it is nil.
+func (r *RarestFirst) rarest(have, among *bitset.BitSet) (uint, bool) {
+	var (
+		index uint
+		found bool
+		// ties counts pieces as available as index
+		ties int
+	)
+	for i, ok := r.t.requested.NextClear(0); ok && i < uint(len(r.availability)); i, ok = r.t.requested.NextClear(i + 1) {
+		if !have.Test(i) || (among != nil && !among.Test(i)) {
+			continue
+		}
+
+		switch {
+		case !found || r.availability[i] < r.availability[index]:
+			index, found, ties = i, true, 1
+		case r.availability[i] == r.availability[index]:
+			// each of equally available pieces is picked with the same
+			// probability
+			ties++
+			if rand.IntN(ties) == 0 {
+				index = i
+			}
+		}
+	}
+	return index, found
+}
+
+// Abandon releases claimed piece, which peer stopped downloading before
+// all of its blocks were requested. Piece is picked again before other
+// pieces, so blocks already received are not kept waiting for long, but
+// all of its blocks are requested again. Blocks received twice replace
+// the same data of the piece.
+func (r *RarestFirst) Abandon(index uint) {
+	r.mu.Lock()
+	r.started.Set(index)
+	r.mu.Unlock()
+
+	r.t.PieceFailed(index)
+}
+
+// PeerHas adds pieces of a peer to pieces availability.
+func (r *RarestFirst) PeerHas(have *bitset.BitSet) {
+	r.update(have, 1)
+}
+
+// PeerHasPiece adds a piece peer announced it has to pieces availability.
+func (r *RarestFirst) PeerHasPiece(index uint) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if index < uint(len(r.availability)) {
+		r.availability[index]++
+	}
+}
+
+// PeerGone removes pieces of a peer from pieces availability.
+func (r *RarestFirst) PeerGone(have *bitset.BitSet) {
+	r.update(have, -1)
+}
+
+func (r *RarestFirst) update(have *bitset.BitSet, delta int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, ok := have.NextSet(0); ok && i < uint(len(r.availability)); i, ok = have.NextSet(i + 1) {
+		r.availability[i] += delta
+	}
+}
+
+// PieceSize returns length of the piece with given index.
+func (r *RarestFirst) PieceSize(index int) int {
+	return r.t.PieceSize(index)
+}
@@ -0,0 +1,146 @@
+package torrent
+
+import (
+	"testing"
+
+	"github.com/bits-and-blooms/bitset"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func peerHas(pieces ...uint) *bitset.BitSet {
+	have := bitset.New(8)
+	for _, i := range pieces {
+		have.Set(i)
+	}
+	return have
+}
+
+func TestRarestFirst_PicksRarest(t *testing.T) {
+	r := NewRarestFirst(makeTorrent(4))
+	r.PeerHas(peerHas(0, 1, 2, 3))
+	r.PeerHas(peerHas(0, 1, 3))
+	r.PeerHasPiece(1)
+
+	var picked []uint
+	for {
+		index, found := r.Next(peerHas(0, 1, 2, 3))
+		if !found {
+			break
+		}
+		picked = append(picked, index)
+	}
+	require.Len(t, picked, 4)
+	assert.Equal(t, uint(2), picked[0])
+	assert.ElementsMatch(t, []uint{0, 3}, picked[1:3], "pieces 0 and 3 are equally available")
+	assert.Equal(t, uint(1), picked[3])
+}
+
+func TestRarestFirst_OnlyPiecesPeerHas(t *testing.T) {
+	tor := makeTorrent(4)
+	r := NewRarestFirst(tor)
+	r.PeerHas(peerHas(1, 2))
+	r.PeerHas(peerHas(2))
+
+	index, found := r.Next(peerHas(2, 7))
+	require.True(t, found)
+	assert.Equal(t, uint(2), index)
+	assert.True(t, tor.requested.Test(2))
+
+	_, found = r.Next(peerHas(2, 7))
+	assert.False(t, found, "piece 2 is claimed and piece 7 does not exist")
+}
+
+func TestRarestFirst_SkipsRequested(t *testing.T) {
+	tor := makeTorrent(4)
+	tor.requested.Set(0)
+	r := NewRarestFirst(tor)
+
+	_, found := r.Next(peerHas())
+	assert.False(t, found, "peer has no pieces")
+
+	index, found := r.Next(peerHas(0, 1))
+	require.True(t, found)
+	assert.Equal(t, uint(1), index)
+
+	_, found = r.Next(peerHas(0, 1))
+	assert.False(t, found, "all pieces peer has are requested")
+}
+
+func TestRarestFirst_PeerGone(t *testing.T) {
+	r := NewRarestFirst(makeTorrent(2))
+	r.PeerHas(peerHas(0))
+	r.PeerHas(peerHas(0, 1))
+	r.PeerHas(peerHas(1))
+	r.PeerGone(peerHas(0))
+
+	index, found := r.Next(peerHas(0, 1))
+	require.True(t, found)
+	assert.Equal(t, uint(0), index)
+}
+
+func TestRarestFirst_RandomTies(t *testing.T) {
+	picked := make(map[uint]bool)
+	for i := 0; i < 100; i++ {
+		r := NewRarestFirst(makeTorrent(4))
+		index, found := r.Next(peerHas(0, 1, 2, 3))
+		require.True(t, found)
+		picked[index] = true
+	}
+	assert.Len(t, picked, 4)
+}
+
+func TestRarestFirst_FinishesStartedPieces(t *testing.T) {
+	tor := makeTorrent(4)
+	r := NewRarestFirst(tor)
+	r.PeerHas(peerHas(0, 1, 2))
+	r.PeerHas(peerHas(1, 2, 3))
+
+	index, found := r.Next(peerHas(1))
+	require.True(t, found)
+	require.Equal(t, uint(1), index)
+	r.Abandon(index)
+	assert.False(t, tor.requested.Test(1))
+
+	// abandoned piece is picked before rarer pieces
+	index, found = r.Next(peerHas(0, 1, 2, 3))
+	require.True(t, found)
+	assert.Equal(t, uint(1), index)
+
+	index, found = r.Next(peerHas(0, 1, 2, 3))
+	require.True(t, found)
+	assert.Contains(t, []uint{0, 3}, index)
+}
+
+func TestRarestFirst_AbandonedPieceDownloadedAgain(t *testing.T) {
+	tor, data := assemblyTorrent(t)
+	r := NewRarestFirst(tor)
+
+	index, found := r.Next(peerHas(0))
+	require.True(t, found)
+	require.Equal(t, uint(0), index)
+	// peer sent first block and left before requesting the second one
+	first := block(tor, data, 0, 0, "gone")
+	r.Abandon(index)
+
+	index, found = r.Next(peerHas(0, 1, 2))
+	require.True(t, found)
+	assert.Equal(t, uint(0), index, "abandoned piece is picked first")
+
+	// new peer requests all blocks, including the one already received
+	writeBlocks(tor, first,
+		block(tor, data, 0, 0, "next"),
+		block(tor, data, 0, int(BlockLength), "next"))
+	assert.True(t, tor.downloaded.Test(0))
+	assert.Zero(t, tor.CorruptPieces("next"))
+}
+
+func TestRarestFirst_SkipsResumedPieces(t *testing.T) {
+	tor := makeTorrent(2)
+	tor.setResumed(0)
+	r := NewRarestFirst(tor)
+
+	index, found := r.Next(peerHas(0, 1))
+	require.True(t, found)
+	assert.Equal(t, uint(1), index)
+}
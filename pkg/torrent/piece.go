@@ -2,14 +2,30 @@ package torrent
 
 import (
 	"bytes"
+	"crypto/sha1"
 	"encoding/base64"
 	"errors"
 	"fmt"
+
+	"github.com/anivanovic/gotit/pkg/bencode"
+	"github.com/anivanovic/gotit/pkg/merkle"
 )
 
+// ErrPieceLayersMissing is returned for v2 torrents without piece layers,
+// which are not part of info dictionary, like torrents from magnet links.
+var ErrPieceLayersMissing = errors.New("v2 piece layers missing")
+
 type Piece struct {
 	index int
 	sha1  []byte
+
+	// root is v2 merkle root of the piece, with leaves blocks as
+	// leaves of the tree.
+	root   []byte
+	leaves int
+	// dataLength is length of file data in v2 piece. Rest of the piece
+	// is padding aligning next file to piece boundary.
+	dataLength int
 }
 
 func newPiece(sha1 []byte, index int) Piece {
@@ -23,12 +39,47 @@ func (p Piece) CheckHash(sha1 []byte) bool {
 	return bytes.Compare(p.sha1, sha1) == 0
 }
 
+// Check reports whether piece data matches piece hashes. Hybrid torrent
+// pieces are checked against both SHA-1 hash and merkle root.
+func (p Piece) Check(data []byte) bool {
+	if p.sha1 == nil && p.root == nil {
+		return false
+	}
+
+	if p.sha1 != nil {
+		hash := sha1.Sum(data)
+		if !p.CheckHash(hash[:]) {
+			return false
+		}
+	}
+
+	if p.root != nil {
+		if len(data) < p.dataLength {
+			return false
+		}
+		if !bytes.Equal(merkle.PieceRoot(data[:p.dataLength], p.leaves), p.root) {
+			return false
+		}
+		for _, b := range data[p.dataLength:] {
+			if b != 0 {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
 func (p Piece) Index() int {
 	return p.index
 }
 
 func (p Piece) String() string {
-	return fmt.Sprintf("[%d:%s]", p.index, base64.URLEncoding.EncodeToString(p.sha1))
+	hash := p.sha1
+	if hash == nil {
+		hash = p.root
+	}
+	return fmt.Sprintf("[%d:%s]", p.index, base64.URLEncoding.EncodeToString(hash))
 }
 
 func NewPieces(pieces []byte) ([]Piece, error) {
@@ -48,6 +99,78 @@ func NewPieces(pieces []byte) ([]Piece, error) {
 	return result, nil
 }
 
+// NewPiecesV2 creates pieces of v2 torrent files, where each file starts
+// at piece boundary. Piece layers of files bigger than piece length are
+// checked against file pieces root.
+func NewPiecesV2(files bencode.FileTree, layers map[string][]byte, pieceLength int) ([]Piece, error) {
+	if pieceLength < merkle.BlockSize || pieceLength != merkle.NextPowerOfTwo(pieceLength) {
+		return nil, fmt.Errorf("invalid v2 piece length %d", pieceLength)
+	}
+
+	pieceLeaves := pieceLength / merkle.BlockSize
+	var result []Piece
+	for _, f := range files {
+		if f.Length == 0 {
+			continue
+		}
+		if len(f.PiecesRoot) != merkle.HashSize {
+			return nil, fmt.Errorf("file %s: invalid pieces root", f.FilePath())
+		}
+
+		length := int(f.Length)
+		if length <= pieceLength {
+			blocks := (length + merkle.BlockSize - 1) / merkle.BlockSize
+			result = append(result, Piece{
+				index:      len(result),
+				root:       f.PiecesRoot,
+				leaves:     merkle.NextPowerOfTwo(blocks),
+				dataLength: length,
+			})
+			continue
+		}
+
+		layer, ok := layers[string(f.PiecesRoot)]
+		if !ok {
+			return nil, fmt.Errorf("file %s: %w", f.FilePath(), ErrPieceLayersMissing)
+		}
+		num := (length + pieceLength - 1) / pieceLength
+		if len(layer) != num*merkle.HashSize {
+			return nil, fmt.Errorf("file %s: invalid piece layer length %d", f.FilePath(), len(layer))
+		}
+		hashes := make([][]byte, num)
+		for i := range hashes {
+			hashes[i] = layer[i*merkle.HashSize : (i+1)*merkle.HashSize]
+		}
+		if !bytes.Equal(merkle.LayerRoot(hashes, pieceLeaves), f.PiecesRoot) {
+			return nil, fmt.Errorf("file %s: piece layer does not match pieces root", f.FilePath())
+		}
+
+		for i, hash := range hashes {
+			result = append(result, Piece{
+				index:      len(result),
+				root:       hash,
+				leaves:     pieceLeaves,
+				dataLength: min(pieceLength, length-i*pieceLength),
+			})
+		}
+	}
+
+	return result, nil
+}
+
+// mergePieces adds SHA-1 hashes of hybrid torrent v1 pieces to its v2
+// pieces.
+func mergePieces(v1, v2 []Piece) ([]Piece, error) {
+	if len(v1) != len(v2) {
+		return nil, fmt.Errorf("hybrid torrent has %d v1 and %d v2 pieces", len(v1), len(v2))
+	}
+
+	for i := range v2 {
+		v2[i].sha1 = v1[i].sha1
+	}
+	return v2, nil
+}
+
 func checkPieceLen(pieces []byte) bool {
 	return len(pieces)%20 == 0
 }
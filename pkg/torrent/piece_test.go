@@ -0,0 +1,232 @@
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/anivanovic/gotit/pkg/bencode"
+	"github.com/anivanovic/gotit/pkg/magnet"
+	"github.com/anivanovic/gotit/pkg/merkle"
+)
+
+const v2PieceLength = 2 * merkle.BlockSize
+
+type v2File struct {
+	name string
+	data []byte
+}
+
+func testV2Files() []v2File {
+	return []v2File{
+		{name: "a", data: bytes.Repeat([]byte{1}, 40000)},
+		{name: "b", data: bytes.Repeat([]byte{2}, 100)},
+		{name: "empty"},
+	}
+}
+
+// v2Pieces returns torrent pieces data, where each file starts at piece
+// boundary.
+func v2Pieces(files []v2File) [][]byte {
+	var pieces [][]byte
+	for _, f := range files {
+		for begin := 0; begin < len(f.data); begin += v2PieceLength {
+			piece := make([]byte, v2PieceLength)
+			copy(piece, f.data[begin:])
+			pieces = append(pieces, piece)
+		}
+	}
+	return pieces
+}
+
+// v2Metainfo builds metainfo of v2 or hybrid torrent with given files.
+func v2Metainfo(t *testing.T, files []v2File, hybrid bool) *bencode.Metainfo {
+	t.Helper()
+
+	tree := bencode.FileTree{}
+	layers := map[string][]byte{}
+	var v1Files []map[string]interface{}
+	for i, f := range files {
+		root := merkle.FileRoot(f.data)
+		tree = append(tree, bencode.FileTreeFile{Path: []string{f.name}, Length: int64(len(f.data)), PiecesRoot: root})
+		if len(f.data) > v2PieceLength {
+			layers[string(root)] = bytes.Join(merkle.PieceLayer(f.data, v2PieceLength/merkle.BlockSize), nil)
+		}
+
+		v1Files = append(v1Files, map[string]interface{}{"path": []string{f.name}, "length": len(f.data)})
+		if pad := (v2PieceLength - len(f.data)%v2PieceLength) % v2PieceLength; pad != 0 && i != len(files)-1 {
+			v1Files = append(v1Files, map[string]interface{}{"path": []string{".pad", "x"}, "length": pad, "attr": "p"})
+		}
+	}
+
+	info := map[string]interface{}{
+		"name":         "multi",
+		"piece length": v2PieceLength,
+		"meta version": 2,
+		"file tree":    tree,
+	}
+	if hybrid {
+		var pieces []byte
+		for _, p := range v2Pieces(files) {
+			hash := sha1.Sum(p)
+			pieces = append(pieces, hash[:]...)
+		}
+		info["pieces"] = pieces
+		info["files"] = v1Files
+	}
+
+	data, err := bencode.Marshal(map[string]interface{}{
+		"announce":     "udp://tracker",
+		"info":         info,
+		"piece layers": layers,
+	})
+	require.NoError(t, err)
+
+	m := &bencode.Metainfo{}
+	require.NoError(t, bencode.Unmarshal(data, m))
+	return m
+}
+
+func TestNew_V2(t *testing.T) {
+	files := testV2Files()
+	m := v2Metainfo(t, files, false)
+	tor := newTestTorrent(t, m)
+
+	assert.Equal(t, m.HashV2()[:20], tor.Hash)
+	assert.True(t, tor.IsDirectory)
+	assert.Equal(t, 3, tor.PiecesNum)
+	assert.Len(t, tor.Pieces, 3)
+	assert.Equal(t, []bencode.TorrentFile{
+		{Path: []string{"a"}, Length: 40000},
+		{Path: []string{".pad", "25536"}, Length: 25536, Attr: "p"},
+		{Path: []string{"b"}, Length: 100},
+		{Path: []string{".pad", "32668"}, Length: 32668, Attr: "p"},
+		{Path: []string{"empty"}, Length: 0},
+	}, tor.TorrentFiles)
+
+	for i, data := range v2Pieces(files) {
+		assert.True(t, tor.CheckPiece(data, i), "piece %d", i)
+	}
+}
+
+func TestNew_V2SingleFile(t *testing.T) {
+	m := v2Metainfo(t, []v2File{{name: "multi", data: bytes.Repeat([]byte{3}, 1000)}}, false)
+	tor := newTestTorrent(t, m)
+
+	assert.False(t, tor.IsDirectory)
+	assert.Equal(t, 1000, tor.Length)
+	assert.Equal(t, 1, tor.PiecesNum)
+}
+
+func TestNew_Hybrid(t *testing.T) {
+	files := testV2Files()
+	m := v2Metainfo(t, files, true)
+	require.True(t, m.IsHybrid())
+	tor := newTestTorrent(t, m)
+
+	assert.Equal(t, m.Hash(), tor.Hash)
+	assert.NotEqual(t, m.HashV2()[:20], tor.Hash)
+	require.Len(t, tor.Pieces, 3)
+	for i, data := range v2Pieces(files) {
+		assert.NotNil(t, tor.Pieces[i].sha1)
+		assert.NotNil(t, tor.Pieces[i].root)
+		assert.True(t, tor.CheckPiece(data, i), "piece %d", i)
+	}
+}
+
+func TestNew_HybridFromMagnet(t *testing.T) {
+	files := testV2Files()
+	m := v2Metainfo(t, files, true)
+	link, err := magnet.Parse(magnet.FromMetainfo(m).String())
+	require.NoError(t, err)
+
+	// magnet link metadata has no piece layers
+	fetched, err := link.Metainfo(m.InfoDictRaw)
+	require.NoError(t, err)
+	require.Empty(t, fetched.PieceLayers)
+	tor := newTestTorrent(t, fetched)
+
+	assert.Equal(t, m.Hash(), tor.Hash)
+	require.Len(t, tor.Pieces, 3)
+	for i, data := range v2Pieces(files) {
+		assert.Nil(t, tor.Pieces[i].root, "merkle root is not known")
+		assert.True(t, tor.CheckPiece(data, i), "piece %d", i)
+	}
+	corrupt := v2Pieces(files)[0]
+	corrupt[0] ^= 0xFF
+	assert.False(t, tor.CheckPiece(corrupt, 0))
+}
+
+func TestNew_V2PieceLayersMissing(t *testing.T) {
+	m := v2Metainfo(t, testV2Files(), false)
+	m.PieceLayers = nil
+	_, err := New(m, t.TempDir(), zap.NewNop())
+	assert.ErrorIs(t, err, ErrPieceLayersMissing)
+}
+
+func TestNew_HybridPiecesMismatch(t *testing.T) {
+	m := v2Metainfo(t, testV2Files(), true)
+	m.Info.Pieces = m.Info.Pieces[:20]
+
+	_, err := New(m, t.TempDir(), zap.NewNop())
+	assert.ErrorContains(t, err, "hybrid torrent has 1 v1 and 3 v2 pieces")
+}
+
+func TestNew_V2InvalidPieceLayer(t *testing.T) {
+	m := v2Metainfo(t, testV2Files(), false)
+	for root, layer := range m.PieceLayers {
+		layer[0] ^= 0xFF
+		m.PieceLayers[root] = layer
+	}
+
+	_, err := New(m, t.TempDir(), zap.NewNop())
+	assert.ErrorContains(t, err, "piece layer does not match pieces root")
+}
+
+func TestCheckPiece_V2(t *testing.T) {
+	files := testV2Files()
+	m := v2Metainfo(t, files, false)
+	pieces, err := NewPiecesV2(m.Info.FileTree, m.PieceLayers, v2PieceLength)
+	require.NoError(t, err)
+	tor := &Torrent{Pieces: pieces}
+
+	data := v2Pieces(files)
+	corrupted := bytes.Clone(data[0])
+	corrupted[100] ^= 0xFF
+	assert.False(t, tor.CheckPiece(corrupted, 0))
+
+	// padding after file data must be zeros
+	padded := bytes.Clone(data[2])
+	padded[len(padded)-1] = 1
+	assert.False(t, tor.CheckPiece(padded, 2))
+
+	assert.False(t, tor.CheckPiece(data[1][:100], 1))
+}
+
+func TestNew_CreatedHybrid(t *testing.T) {
+	files := testV2Files()
+	dir := filepath.Join(t.TempDir(), "multi")
+	require.NoError(t, os.Mkdir(dir, 0o755))
+	for _, f := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, f.name), f.data, 0o644))
+	}
+
+	m, err := bencode.Create(dir, bencode.CreateOptions{
+		PieceLength:  v2PieceLength,
+		AnnounceList: [][]string{{"udp://tracker"}},
+		Version:      bencode.MetaHybrid,
+	})
+	require.NoError(t, err)
+	tor := newTestTorrent(t, m)
+	assert.Equal(t, m.Hash(), tor.Hash)
+
+	for i, data := range v2Pieces(files) {
+		assert.True(t, tor.CheckPiece(data, i), "piece %d", i)
+	}
+}
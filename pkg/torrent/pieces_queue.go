@@ -3,6 +3,8 @@ package torrent
 import (
 	"sync"
 
+	"github.com/bits-and-blooms/bitset"
+
 	"github.com/anivanovic/gotit/pkg/util"
 )
 
@@ -24,14 +26,20 @@ func (pq *PiecesQueue) RequestFailed(msg *util.PeerMessage) {
 	pq.failedMu.Unlock()
 }
 
-func (pq *PiecesQueue) FailedPieceMessage() *util.PeerMessage {
+// FailedPieceMessage returns failed block request for one of the pieces
+// in have, removing it from the queue. Nil is returned when there is no
+// such request.
+func (pq *PiecesQueue) FailedPieceMessage(have *bitset.BitSet) *util.PeerMessage {
 	pq.failedMu.Lock()
 	defer pq.failedMu.Unlock()
 
-	if len(pq.failedMessages) == 0 {
-		return nil
+	for i := len(pq.failedMessages) - 1; i >= 0; i-- {
+		req := pq.failedMessages[i]
+		if !have.Test(uint(req.Index())) {
+			continue
+		}
+		pq.failedMessages = append(pq.failedMessages[:i], pq.failedMessages[i+1:]...)
+		return req
 	}
-	req := pq.failedMessages[len(pq.failedMessages)-1]
-	pq.failedMessages = pq.failedMessages[:len(pq.failedMessages)-1]
-	return req
+	return nil
 }
@@ -0,0 +1,214 @@
+package torrent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/bits-and-blooms/bitset"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+
+	"github.com/anivanovic/gotit/pkg/bencode"
+)
+
+// resumeSuffix is appended to torrent data path to get resume file path.
+const resumeSuffix = ".resume"
+
+var errResumeMismatch = errors.New("resume file does not match torrent files")
+
+// Counters are transfer totals of the torrent, kept across restarts.
+type Counters struct {
+	Downloaded uint64
+	Uploaded   uint64
+}
+
+// resumeData is torrent download state saved in resume file.
+type resumeData struct {
+	InfoHash []byte `ben:"info hash"`
+	// Bitfield holds downloaded pieces, with high bit of the first byte
+	// for piece 0, as in peer bitfield message.
+	Bitfield   []byte       `ben:"bitfield"`
+	Files      []resumeFile `ben:"files"`
+	Downloaded int64        `ben:"downloaded"`
+	Uploaded   int64        `ben:"uploaded"`
+}
+
+// resumeFile is state of torrent file when resume file was saved.
+type resumeFile struct {
+	Size int64 `ben:"size"`
+	// Mtime is file modification time in nanoseconds since epoch.
+	Mtime int64 `ben:"mtime"`
+}
+
+// Resume restores downloaded pieces of torrent stored in files from its
+// resume file. Resume file is trusted only when sizes and modification
+// times of files match, otherwise downloaded pieces are found by
+// verifying data on disk. Torrents kept in other storage start empty.
+func (t *Torrent) Resume(ctx context.Context) (Counters, error) {
+	s, ok := t.storage.(*FileStorage)
+	if !ok {
+		return Counters{}, nil
+	}
+
+	data, err := t.readResume(s)
+	if err == nil {
+		t.logger.Info("resuming download from resume file", zap.String("path", s.resumePath()))
+		for i := uint(0); i < uint(t.PiecesNum); i++ {
+			if data.Bitfield[i/8]&(0x80>>(i%8)) != 0 {
+				t.setResumed(i)
+			}
+		}
+		return Counters{Downloaded: uint64(data.Downloaded), Uploaded: uint64(data.Uploaded)}, nil
+	}
+	if !os.IsNotExist(err) {
+		t.logger.Warn("ignoring resume file", zap.String("path", s.resumePath()), zap.Error(err))
+	}
+
+	if !s.hasData() {
+		return Counters{}, nil
+	}
+	t.logger.Info("verifying existing torrent data")
+	result, err := t.Verify(ctx)
+	if err != nil {
+		return Counters{}, err
+	}
+	for i, ok := result.Have.NextSet(0); ok; i, ok = result.Have.NextSet(i + 1) {
+		t.setResumed(i)
+	}
+	return Counters{}, nil
+}
+
+// setResumed marks piece found on disk as requested and downloaded.
+func (t *Torrent) setResumed(index uint) {
+	t.requestedMu.Lock()
+	t.requested.Set(index)
+	t.requestedMu.Unlock()
+	t.SetDownloaded(index)
+}
+
+func (t *Torrent) readResume(s *FileStorage) (*resumeData, error) {
+	raw, err := os.ReadFile(s.resumePath())
+	if err != nil {
+		return nil, err
+	}
+	data := &resumeData{}
+	if err := bencode.Unmarshal(raw, data); err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(data.InfoHash, t.Hash) || len(data.Bitfield) != (t.PiecesNum+7)/8 {
+		return nil, errResumeMismatch
+	}
+	files, err := s.fileStates()
+	if err != nil {
+		return nil, err
+	}
+	if len(files) != len(data.Files) {
+		return nil, errResumeMismatch
+	}
+	for i := range files {
+		if files[i] != data.Files[i] {
+			return nil, fmt.Errorf("%w: %s changed", errResumeMismatch, s.files[i].FilePath())
+		}
+	}
+	return data, nil
+}
+
+// SaveResume writes downloaded pieces and counters to resume file of
+// torrent stored in files. Files are synced first, so saved pieces are
+// on disk.
+func (t *Torrent) SaveResume(c Counters) error {
+	s, ok := t.storage.(*FileStorage)
+	if !ok {
+		return nil
+	}
+
+	t.downloadedMu.Lock()
+	downloaded := t.downloaded.Clone()
+	t.downloadedMu.Unlock()
+
+	if err := s.sync(); err != nil {
+		return err
+	}
+	files, err := s.fileStates()
+	if err != nil {
+		return err
+	}
+
+	raw, err := bencode.Marshal(resumeData{
+		InfoHash:   t.Hash,
+		Bitfield:   bitfield(downloaded, t.PiecesNum),
+		Files:      files,
+		Downloaded: int64(c.Downloaded),
+		Uploaded:   int64(c.Uploaded),
+	})
+	if err != nil {
+		return err
+	}
+
+	// resume file is replaced at once, so it is never partially written
+	tmp := s.resumePath() + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.resumePath())
+}
+
+// bitfield returns pieces set in b, with high bit of the first byte for
+// piece 0.
+func bitfield(b *bitset.BitSet, pieces int) []byte {
+	field := make([]byte, (pieces+7)/8)
+	for i, ok := b.NextSet(0); ok && i < uint(pieces); i, ok = b.NextSet(i + 1) {
+		field[i/8] |= 0x80 >> (i % 8)
+	}
+	return field
+}
+
+func (s *FileStorage) resumePath() string {
+	return s.dir + resumeSuffix
+}
+
+// fileStates returns size and modification time of torrent files.
+// Padding files, symlinks and missing files have zero state.
+func (s *FileStorage) fileStates() ([]resumeFile, error) {
+	files := make([]resumeFile, len(s.files))
+	for i, f := range s.osFiles {
+		if f == nil {
+			continue
+		}
+		stat, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		files[i] = resumeFile{Size: stat.Size(), Mtime: stat.ModTime().UnixNano()}
+	}
+	return files, nil
+}
+
+// hasData reports whether any torrent file is not empty.
+func (s *FileStorage) hasData() bool {
+	files, err := s.fileStates()
+	if err != nil {
+		return true
+	}
+	for _, f := range files {
+		if f.Size > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// sync commits written data of torrent files to disk.
+func (s *FileStorage) sync() error {
+	var err error
+	for _, f := range s.osFiles {
+		if f != nil {
+			err = multierr.Append(err, f.Sync())
+		}
+	}
+	return err
+}
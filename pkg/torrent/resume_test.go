@@ -0,0 +1,95 @@
+package torrent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bits-and-blooms/bitset"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/anivanovic/gotit/pkg/bencode"
+)
+
+func TestNew_KeepsExistingData(t *testing.T) {
+	root, m := createVerifyContent(t, bencode.MetaV1)
+	newTorrentIn(t, m, root)
+
+	data, err := os.ReadFile(filepath.Join(root, "content", "a"))
+	require.NoError(t, err)
+	assert.Len(t, data, 20000)
+}
+
+func TestResume_FromResumeFile(t *testing.T) {
+	root, m := createVerifyContent(t, bencode.MetaV1)
+	tor := newTorrentIn(t, m, root)
+	tor.SetDownloaded(0)
+	tor.SetDownloaded(3)
+	require.NoError(t, tor.SaveResume(Counters{Downloaded: 100, Uploaded: 50}))
+	require.NoError(t, tor.Close())
+	assert.FileExists(t, filepath.Join(root, "content.resume"))
+
+	// all pieces are on disk, but resume file is trusted
+	tor = newTorrentIn(t, m, root)
+	counters, err := tor.Resume(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, Counters{Downloaded: 100, Uploaded: 50}, counters)
+	assert.Equal(t, uint(2), tor.downloaded.Count())
+	assert.True(t, tor.downloaded.Test(0))
+	assert.True(t, tor.downloaded.Test(3))
+	assert.Equal(t, tor.Length-tor.PieceSize(0)-tor.PieceSize(3), tor.Left())
+
+	// resumed pieces are not requested from peers
+	_, found := NewRarestFirst(tor).Next(bitset.New(uint(tor.PiecesNum)).Set(0).Set(3))
+	assert.False(t, found)
+}
+
+func TestResume_ChangedFileVerifiesData(t *testing.T) {
+	root, m := createVerifyContent(t, bencode.MetaV1)
+	tor := newTorrentIn(t, m, root)
+	tor.SetDownloaded(0)
+	require.NoError(t, tor.SaveResume(Counters{Downloaded: 100}))
+	require.NoError(t, tor.Close())
+
+	later := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(root, "content", "d"), later, later))
+
+	tor = newTorrentIn(t, m, root)
+	counters, err := tor.Resume(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, Counters{}, counters)
+	assert.True(t, tor.Done())
+	assert.Zero(t, tor.Left())
+}
+
+func TestResume_OtherTorrent(t *testing.T) {
+	root, m := createVerifyContent(t, bencode.MetaV1)
+	tor := newTorrentIn(t, m, root)
+	require.NoError(t, tor.SaveResume(Counters{Downloaded: 100}))
+	require.NoError(t, tor.Close())
+
+	m2, err := bencode.Create(filepath.Join(root, "content"), bencode.CreateOptions{
+		PieceLength: 32 * 1024,
+		Version:     bencode.MetaV1,
+	})
+	require.NoError(t, err)
+	tor = newTorrentIn(t, m2, root)
+	counters, err := tor.Resume(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, Counters{}, counters)
+	assert.True(t, tor.Done())
+}
+
+func TestResume_NewDownload(t *testing.T) {
+	_, m := createVerifyContent(t, bencode.MetaV1)
+	tor := newTorrentIn(t, m, t.TempDir())
+
+	counters, err := tor.Resume(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, Counters{}, counters)
+	assert.Zero(t, tor.downloaded.Count())
+	assert.Equal(t, tor.Length, tor.Left())
+}
@@ -0,0 +1,98 @@
+package torrent
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var errPieceMissing = errors.New("piece not stored")
+
+// Storage keeps torrent data. Data is addressed by piece index and offset
+// in the piece, so storage decides how pieces are laid out in files,
+// memory or other backends. Storage must be safe for concurrent use.
+type Storage interface {
+	// ReadAt reads len(p) bytes of piece index starting at off. Error is
+	// returned when not all bytes are read.
+	ReadAt(index int, p []byte, off int) error
+	// WriteAt writes p to piece index starting at off.
+	WriteAt(index int, p []byte, off int) error
+	// MarkComplete is called once piece is downloaded and its data
+	// matches piece hash.
+	MarkComplete(index int) error
+	Close() error
+}
+
+// checkRange returns error when len bytes at off are not part of piece
+// index of torrent with given piece and total length.
+func checkRange(pieceLength, length, index, off, n int) error {
+	if index < 0 || index*pieceLength >= length {
+		return fmt.Errorf("invalid piece index %d", index)
+	}
+	size := min(pieceLength, length-index*pieceLength)
+	if off < 0 || off+n > size {
+		return fmt.Errorf("range %d-%d out of piece %d with length %d", off, off+n, index, size)
+	}
+	return nil
+}
+
+// MemoryStorage keeps torrent data in memory. Piece memory is allocated
+// when piece is first written.
+type MemoryStorage struct {
+	pieceLength int
+	length      int
+
+	mu     sync.RWMutex
+	pieces [][]byte
+}
+
+// NewMemoryStorage creates empty memory storage for torrent t.
+func NewMemoryStorage(t *Torrent) *MemoryStorage {
+	return &MemoryStorage{
+		pieceLength: t.PieceLength,
+		length:      t.Length,
+		pieces:      make([][]byte, t.PiecesNum),
+	}
+}
+
+func (s *MemoryStorage) ReadAt(index int, p []byte, off int) error {
+	if err := checkRange(s.pieceLength, s.length, index, off, len(p)); err != nil {
+		return err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.pieces[index] == nil {
+		return fmt.Errorf("piece %d: %w", index, errPieceMissing)
+	}
+	copy(p, s.pieces[index][off:])
+	return nil
+}
+
+func (s *MemoryStorage) WriteAt(index int, p []byte, off int) error {
+	if err := checkRange(s.pieceLength, s.length, index, off, len(p)); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pieces[index] == nil {
+		s.pieces[index] = make([]byte, min(s.pieceLength, s.length-index*s.pieceLength))
+	}
+	copy(s.pieces[index][off:], p)
+	return nil
+}
+
+// MarkComplete does nothing, as pieces are kept in memory until storage
+// is closed.
+func (s *MemoryStorage) MarkComplete(int) error {
+	return nil
+}
+
+// Close releases memory of all pieces.
+func (s *MemoryStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.pieces)
+	return nil
+}
@@ -0,0 +1,106 @@
+package torrent
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/anivanovic/gotit/pkg/bencode"
+)
+
+// storageTorrent returns torrent of 2.5 pieces with three files, one of
+// them spanning piece boundary.
+func storageTorrent(t *testing.T) *Torrent {
+	t.Helper()
+	m := &bencode.Metainfo{Announce: "udp://tracker"}
+	m.Info.Name = "storage"
+	m.Info.PieceLength = 8
+	m.Info.Pieces = strings.Repeat("x", 3*sha1.Size)
+	m.Info.Files = []bencode.TorrentFile{
+		{Path: []string{"a"}, Length: 6},
+		{Path: []string{"b"}, Length: 10},
+		{Path: []string{"c"}, Length: 4},
+	}
+	return newTorrentIn(t, m, t.TempDir())
+}
+
+// testStorage writes torrent data of storageTorrent to s in blocks and
+// checks it is read back.
+func testStorage(t *testing.T, s Storage) {
+	t.Helper()
+	data := []byte("abcdefghijklmnopqrst")
+
+	require.NoError(t, s.WriteAt(0, data[0:4], 0))
+	require.NoError(t, s.WriteAt(0, data[4:8], 4))
+	require.NoError(t, s.WriteAt(1, data[8:16], 0))
+	require.NoError(t, s.WriteAt(2, data[16:20], 0))
+	for i := 0; i < 3; i++ {
+		require.NoError(t, s.MarkComplete(i))
+	}
+
+	got := make([]byte, 8)
+	require.NoError(t, s.ReadAt(0, got, 0))
+	assert.Equal(t, data[0:8], got)
+	require.NoError(t, s.ReadAt(1, got[:5], 3))
+	assert.Equal(t, data[11:16], got[:5])
+	require.NoError(t, s.ReadAt(2, got[:4], 0))
+	assert.Equal(t, data[16:20], got[:4])
+
+	assert.NoError(t, s.Close())
+}
+
+func TestMemoryStorage(t *testing.T) {
+	testStorage(t, NewMemoryStorage(storageTorrent(t)))
+}
+
+func TestMemoryStorage_InvalidRange(t *testing.T) {
+	s := NewMemoryStorage(storageTorrent(t))
+
+	assert.Error(t, s.WriteAt(3, []byte{1}, 0))
+	assert.Error(t, s.WriteAt(-1, []byte{1}, 0))
+	assert.Error(t, s.WriteAt(2, []byte("12345"), 0), "last piece is 4 bytes long")
+	assert.Error(t, s.WriteAt(0, []byte{1}, 8))
+	assert.ErrorIs(t, s.ReadAt(1, make([]byte, 1), 0), errPieceMissing)
+}
+
+func TestFileStorage(t *testing.T) {
+	tor := storageTorrent(t)
+	s, err := NewFileStorage(tor, t.TempDir())
+	require.NoError(t, err)
+	testStorage(t, s)
+}
+
+func TestNewWithStorage_Memory(t *testing.T) {
+	data := bytes.Repeat([]byte("memory storage "), 3000)
+	m := &bencode.Metainfo{Announce: "udp://tracker"}
+	m.Info.Name = "memory"
+	m.Info.Length = int64(len(data))
+	m.Info.PieceLength = 16 * 1024
+	for begin := 0; begin < len(data); begin += int(m.Info.PieceLength) {
+		m.Info.Pieces += string(sha1Of(data[begin:min(begin+int(m.Info.PieceLength), len(data))]))
+	}
+
+	tor, err := NewWithStorage(m, func(t *Torrent) (Storage, error) {
+		return NewMemoryStorage(t), nil
+	}, zap.NewNop())
+	require.NoError(t, err)
+	t.Cleanup(func() { tor.Close() })
+
+	var blocks []*Block
+	for i := 0; i < tor.PiecesNum; i++ {
+		begin := i * tor.PieceLength
+		blocks = append(blocks, &Block{Index: i, Data: data[begin : begin+tor.PieceSize(i)]})
+	}
+	writeBlocks(tor, blocks...)
+
+	result, err := tor.Verify(context.Background())
+	require.NoError(t, err)
+	assert.True(t, result.Complete())
+	assert.Equal(t, []FileStatus{{Path: "memory", Length: len(data), Pieces: 3, Verified: 3}}, result.Files)
+}
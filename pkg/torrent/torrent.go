@@ -1,46 +1,49 @@
 package torrent
 
 import (
-	"bytes"
 	"crypto/sha1"
 	"errors"
+	"fmt"
 	"math"
-	"os"
-	"path/filepath"
+	"strconv"
 	"sync"
 
-	"github.com/anivanovic/gotit/pkg/stats"
-	"github.com/tevino/abool/v2"
-
 	"github.com/bits-and-blooms/bitset"
-	"go.uber.org/multierr"
 	"go.uber.org/zap"
 
 	"github.com/anivanovic/gotit/pkg/bencode"
 	"github.com/anivanovic/gotit/pkg/util"
 )
 
-const BlockLength uint = 128 * 1024
+// BlockLength is length of block requested from peers. Most clients
+// reject requests for more than 16 KiB.
+const BlockLength uint = 16 * 1024
 
 type Torrent struct {
-	logger       *zap.Logger
-	Trackers     util.StringSet
+	logger   *zap.Logger
+	Trackers util.StringSet
+	// WebSeeds are HTTP servers hosting torrent files (BEP 19).
+	WebSeeds []string
+	// HttpSeeds are HTTP servers serving pieces by index (BEP 17).
+	HttpSeeds    []string
 	Hash         []byte
 	Length       int
 	PieceLength  int
 	Pieces       []Piece
 	PiecesNum    int
 	TorrentFiles []bencode.TorrentFile
-	OsFiles      []*os.File
 	Name         string
 	CreationDate int64
 	CreatedBy    string
 	Comment      string
 	IsDirectory  bool
+	// Private torrents (BEP 27) must get peers only from Trackers, without
+	// DHT, peer exchange or local discovery.
+	Private bool
 
 	Metadata *bencode.Metainfo
 
-	numOfBlocks int
+	storage Storage
 
 	requested   *bitset.BitSet
 	requestedMu *sync.Mutex
@@ -48,11 +51,34 @@ type Torrent struct {
 	downloaded   *bitset.BitSet
 	downloadedMu *sync.Mutex
 
-	done   *abool.AtomicBool
-	doneCh chan struct{}
+	corrupt corruptPieces
 }
 
+// New creates torrent from metainfo and creates its files in downloadDir.
 func New(metainfo *bencode.Metainfo, downloadDir string, logger *zap.Logger) (*Torrent, error) {
+	return NewWithStorage(metainfo, func(t *Torrent) (Storage, error) {
+		return NewFileStorage(t, downloadDir)
+	}, logger)
+}
+
+// NewWithStorage creates torrent from metainfo, keeping its data in
+// storage returned by open.
+func NewWithStorage(metainfo *bencode.Metainfo, open func(t *Torrent) (Storage, error), logger *zap.Logger) (*Torrent, error) {
+	t, err := newTorrent(metainfo, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	storage, err := open(t)
+	if err != nil {
+		return nil, err
+	}
+	t.storage = storage
+
+	return t, nil
+}
+
+func newTorrent(metainfo *bencode.Metainfo, logger *zap.Logger) (*Torrent, error) {
 	t := &Torrent{
 		logger:       logger,
 		requestedMu:  &sync.Mutex{},
@@ -60,201 +86,190 @@ func New(metainfo *bencode.Metainfo, downloadDir string, logger *zap.Logger) (*T
 		Metadata:     metainfo,
 	}
 	t.logger.Debug("Created client id")
-	t.Name = metainfo.Info.Name
+	name, err := sanitizeName(metainfo.Info.Name)
+	if err != nil {
+		return nil, fmt.Errorf("torrent name: %w", err)
+	}
+	t.Name = name
 	t.CreatedBy = metainfo.CreatedBy
 	t.CreationDate = metainfo.CreationDate
 	t.Comment = metainfo.Comment
 	t.PieceLength = int(metainfo.Info.PieceLength)
-	pieces, err := NewPieces([]byte(metainfo.Info.Pieces))
+	pieces, err := newTorrentPieces(metainfo)
 	if err != nil {
 		return nil, err
 	}
 	t.Pieces = pieces
-	t.numOfBlocks = t.PieceLength / int(BlockLength)
 	t.Hash = metainfo.Hash()
+	if metainfo.IsV2() && !metainfo.IsHybrid() {
+		// v2 peers identify torrents by truncated v2 info-hash (BEP 52),
+		// hybrid torrents keep v1 hash so v1 peers can join the swarm
+		t.Hash = metainfo.HashV2()[:sha1.Size]
+	}
 
-	announce := metainfo.Announce
 	announceSet := util.NewStringSet()
-	announceSet.Add(announce)
+	if metainfo.Announce != "" {
+		announceSet.Add(metainfo.Announce)
+	}
 	for _, el := range metainfo.AnnounceList {
 		for _, e := range el {
 			announceSet.Add(e)
 		}
 	}
-	for _, el := range metainfo.UrlList {
-		announceSet.Add(el)
-	}
 	t.Trackers = announceSet
-	t.IsDirectory = metainfo.Info.Length == 0
+	t.WebSeeds = metainfo.UrlList
+	t.HttpSeeds = metainfo.HttpSeeds
+	t.Private = metainfo.IsPrivate()
+	if t.Private && len(t.Trackers) == 0 {
+		return nil, errors.New("private torrent has no trackers")
+	}
 
-	if !t.IsDirectory {
-		t.Length = int(metainfo.Info.Length)
-	} else {
-		files := metainfo.Info.Files
+	switch {
+	case metainfo.IsV2() && !metainfo.IsHybrid():
+		tree := metainfo.Info.FileTree
+		t.IsDirectory = len(tree) != 1 || len(tree[0].Path) != 1 || tree[0].Path[0] != metainfo.Info.Name
+		if t.IsDirectory {
+			t.TorrentFiles = alignFiles(tree, t.PieceLength)
+		} else {
+			t.Length = int(tree[0].Length)
+		}
+	default:
+		t.IsDirectory = metainfo.Info.Length == 0
+		if t.IsDirectory {
+			t.TorrentFiles = metainfo.Info.Files
+		} else {
+			t.Length = int(metainfo.Info.Length)
+		}
+	}
 
+	if t.IsDirectory {
 		var completeLength = 0
-		for _, file := range files {
+		for _, file := range t.TorrentFiles {
 			completeLength += file.Length
 		}
-		t.TorrentFiles = metainfo.Info.Files
 		t.Length = completeLength
 	}
 	t.PiecesNum = int(math.Ceil(float64(t.Length) / float64(t.PieceLength)))
+	if metainfo.IsV2() && len(t.Pieces) != t.PiecesNum {
+		return nil, fmt.Errorf("torrent has %d pieces, expected %d", len(t.Pieces), t.PiecesNum)
+	}
 	t.requested = bitset.New(uint(t.PiecesNum))
 	t.downloaded = bitset.New(uint(t.PiecesNum))
 
-	if err := t.initDownloadDir(downloadDir); err != nil {
-		return nil, err
-	}
-
 	return t, nil
 }
 
-func (t *Torrent) SetDownloaded(pieceIndx uint) {
-	t.downloadedMu.Lock()
-	defer t.downloadedMu.Unlock()
+// newTorrentPieces creates pieces of v1, v2 or hybrid torrent. Hybrid
+// torrent without piece layers, as fetched from magnet link, is checked
+// with v1 piece hashes only.
+func newTorrentPieces(metainfo *bencode.Metainfo) ([]Piece, error) {
+	if !metainfo.IsV2() {
+		return NewPieces([]byte(metainfo.Info.Pieces))
+	}
 
-	t.downloaded.Set(pieceIndx)
-}
+	pieces, err := NewPiecesV2(metainfo.Info.FileTree, metainfo.PieceLayers, int(metainfo.Info.PieceLength))
+	if errors.Is(err, ErrPieceLayersMissing) && metainfo.IsHybrid() {
+		return NewPieces([]byte(metainfo.Info.Pieces))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !metainfo.IsHybrid() {
+		return pieces, nil
+	}
 
-func (t *Torrent) Next(have *bitset.BitSet) (uint, bool) {
-	t.requestedMu.Lock()
-	defer t.requestedMu.Unlock()
+	v1, err := NewPieces([]byte(metainfo.Info.Pieces))
+	if err != nil {
+		return nil, err
+	}
+	return mergePieces(v1, pieces)
+}
 
-	for i, exists := t.requested.NextClear(0); exists; i, exists = t.requested.NextClear(i + 1) {
-		if !have.Test(i) {
-			continue
+// alignFiles returns files of v2 torrent with pad files added, so each
+// file starts at piece boundary as in hybrid torrents (BEP 47).
+func alignFiles(tree bencode.FileTree, pieceLength int) []bencode.TorrentFile {
+	files := make([]bencode.TorrentFile, 0, len(tree))
+	for i, f := range tree {
+		files = append(files, bencode.TorrentFile{
+			Path:        f.Path,
+			Length:      int(f.Length),
+			Attr:        f.Attr,
+			SymlinkPath: f.SymlinkPath,
+		})
+
+		pad := (pieceLength - int(f.Length)%pieceLength) % pieceLength
+		if pad != 0 && i != len(tree)-1 {
+			files = append(files, bencode.TorrentFile{
+				Path:   []string{".pad", strconv.Itoa(pad)},
+				Length: pad,
+				Attr:   string(bencode.AttrPad),
+			})
 		}
-
-		t.requested.Set(i)
-		return i, true
 	}
-
-	return 0, false
+	return files
 }
 
-func (t *Torrent) Done() bool {
+func (t *Torrent) SetDownloaded(pieceIndx uint) {
 	t.downloadedMu.Lock()
-	defer t.downloadedMu.Unlock()
-	return t.downloaded.All()
-}
-
-func (t *Torrent) initDownloadDir(root string) error {
-	path := filepath.Join(root, t.Name)
-	var filePaths []string
-	if t.IsDirectory {
-		if err := os.Mkdir(path, os.ModePerm); err != nil && os.IsNotExist(err) {
-			return err
-		}
-
-		for _, tf := range t.TorrentFiles {
-			filePaths = append(filePaths, filepath.Join(path, tf.Path[0]))
-		}
-	} else {
-		filePaths = append(filePaths, path)
-	}
+	t.downloaded.Set(pieceIndx)
+	t.downloadedMu.Unlock()
 
-	for _, path := range filePaths {
-		f, err := os.Create(path)
-		if err != nil {
-			return err
-		}
-		t.OsFiles = append(t.OsFiles, f)
+	if err := t.storage.MarkComplete(int(pieceIndx)); err != nil {
+		t.logger.Warn("Failed to mark piece complete",
+			zap.Uint("index", pieceIndx),
+			zap.Error(err))
 	}
-
-	return nil
 }
 
-func (t *Torrent) CheckPiece(data []byte, index int) bool {
-	hasher := sha1.New()
-	hasher.Write(data)
-	hash := hasher.Sum(nil)
+// PieceFailed makes requested piece available to piece pickers again,
+// after its download failed.
+func (t *Torrent) PieceFailed(index uint) {
+	t.requestedMu.Lock()
+	defer t.requestedMu.Unlock()
 
-	return bytes.Equal(t.Pieces[index].sha1, hash)
+	t.requested.Clear(index)
 }
 
-func (t *Torrent) WritePiece(piecesCh <-chan *util.PeerMessage, stats *stats.Stats) {
-	for msg := range piecesCh {
-		piecePoss := int(msg.Index())*t.PieceLength + int(msg.Offset())
-		stats.AddDownload(uint64(len(msg.Data())))
-
-		if err := t.writePieceData(msg.Data(), piecePoss); err != nil {
-			t.logger.Error("Failed to write piece",
-				zap.Int("index", int(msg.Index())),
-				zap.Int("offset", int(msg.Offset())),
-				zap.Error(err))
-			continue
-		}
-
-		if int(msg.Offset())+len(msg.Data()) == t.PieceLength {
-			t.SetDownloaded(uint(msg.Index()))
-		}
-	}
-
-	t.logger.Debug("Finished writing pieces")
+// PieceSize returns length of the piece with given index. Last piece can
+// be shorter than PieceLength.
+func (t *Torrent) PieceSize(index int) int {
+	return min(t.PieceLength, t.Length-index*t.PieceLength)
 }
 
-// writePieceData writes data at the given absolute torrent byte position,
-// spanning across multiple files as needed.
-func (t *Torrent) writePieceData(data []byte, piecePoss int) error {
-	if !t.IsDirectory {
-		_, err := t.OsFiles[0].WriteAt(data, int64(piecePoss))
-		return err
-	}
+// Left returns number of bytes in pieces not downloaded yet.
+func (t *Torrent) Left() int {
+	t.downloadedMu.Lock()
+	defer t.downloadedMu.Unlock()
 
-	// Find the file where piece should be written to.
-	fileIdx := 0
-	for fileIdx < len(t.TorrentFiles) {
-		if t.TorrentFiles[fileIdx].Length > piecePoss {
-			break
-		}
-		piecePoss -= t.TorrentFiles[fileIdx].Length
-		fileIdx++
-	}
-	if fileIdx >= len(t.TorrentFiles) {
-		return errors.New("piece position beyond all torrent files")
+	left := t.Length
+	for i, ok := t.downloaded.NextSet(0); ok; i, ok = t.downloaded.NextSet(i + 1) {
+		left -= t.PieceSize(int(i))
 	}
+	return left
+}
 
-	// Write data, advancing to the next file whenever the current one is full.
-	for len(data) > 0 {
-		if fileIdx >= len(t.TorrentFiles) {
-			return errors.New("data extends beyond torrent files")
-		}
-
-		available := t.TorrentFiles[fileIdx].Length - piecePoss
-		toWrite := len(data)
-		if toWrite > available {
-			toWrite = available
-		}
-
-		t.logger.Debug("Writing to file",
-			zap.String("file", t.TorrentFiles[fileIdx].Path[0]),
-			zap.Int("position", piecePoss),
-			zap.Int("bytes", toWrite))
-
-		if _, err := t.OsFiles[fileIdx].WriteAt(data[:toWrite], int64(piecePoss)); err != nil {
-			return err
-		}
-
-		data = data[toWrite:]
-		piecePoss = 0
-		fileIdx++
-	}
+func (t *Torrent) isDownloaded(index int) bool {
+	t.downloadedMu.Lock()
+	defer t.downloadedMu.Unlock()
+	return t.downloaded.Test(uint(index))
+}
 
-	return nil
+func (t *Torrent) Done() bool {
+	t.downloadedMu.Lock()
+	defer t.downloadedMu.Unlock()
+	return t.downloaded.All()
 }
 
-func (t *Torrent) BlockNum() int {
-	return t.numOfBlocks
+func (t *Torrent) CheckPiece(data []byte, index int) bool {
+	return t.Pieces[index].Check(data)
 }
 
-// Close torrent os files
+// Close torrent storage
 func (t *Torrent) Close() error {
-	var err error
-	for _, f := range t.OsFiles {
-		err = multierr.Append(err, f.Close())
+	if t.storage == nil {
+		return nil
 	}
-	return err
+	return t.storage.Close()
 }
 
 func (t *Torrent) EmptyBitset() *bitset.BitSet {
@@ -2,7 +2,6 @@ package torrent
 
 import (
 	"crypto/sha1"
-	"encoding/binary"
 	"os"
 	"path/filepath"
 	"sync"
@@ -15,9 +14,22 @@ import (
 
 	"github.com/anivanovic/gotit/pkg/bencode"
 	"github.com/anivanovic/gotit/pkg/stats"
-	"github.com/anivanovic/gotit/pkg/util"
 )
 
+// newTorrentIn creates torrent from m with its files in root directory.
+func newTorrentIn(t *testing.T, m *bencode.Metainfo, root string) *Torrent {
+	t.Helper()
+	tor, err := New(m, root, zap.NewNop())
+	require.NoError(t, err)
+	t.Cleanup(func() { tor.Close() })
+	return tor
+}
+
+func newTestTorrent(t *testing.T, m *bencode.Metainfo) *Torrent {
+	t.Helper()
+	return newTorrentIn(t, m, t.TempDir())
+}
+
 func TestTorrent_createTorrentFiles(t *testing.T) {
 	dir, err := os.MkdirTemp(".", "torrent-*")
 	if err != nil {
@@ -41,9 +53,11 @@ func TestTorrent_createTorrentFiles(t *testing.T) {
 		Name:        "torrent",
 		IsDirectory: true,
 	}
-	if err := torrent.initDownloadDir(dir); err != nil {
+	storage, err := NewFileStorage(&torrent, dir)
+	if err != nil {
 		t.Fatal("error creating torrent files", err)
 	}
+	storage.Close()
 
 	firstF := filepath.Join(dir, "torrent", "1.txt")
 	secondf := filepath.Join(dir, "torrent", "2.txt")
@@ -52,7 +66,9 @@ func TestTorrent_createTorrentFiles(t *testing.T) {
 
 	torrent.IsDirectory = false
 	torrent.Name = "test.txt"
-	torrent.initDownloadDir(dir)
+	storage, err = NewFileStorage(&torrent, dir)
+	require.NoError(t, err)
+	storage.Close()
 	file := filepath.Join(dir, torrent.Name)
 	assert.FileExists(t, file)
 }
@@ -61,14 +77,17 @@ func TestTorrent_createTorrentFiles(t *testing.T) {
 
 // makeTorrent builds a minimal Torrent with correctly-sized bitsets for unit tests.
 func makeTorrent(piecesNum int) *Torrent {
-	return &Torrent{
+	tor := &Torrent{
 		PiecesNum:    piecesNum,
 		PieceLength:  int(BlockLength),
+		Length:       piecesNum * int(BlockLength),
 		requested:    bitset.New(uint(piecesNum)),
 		downloaded:   bitset.New(uint(piecesNum)),
 		requestedMu:  &sync.Mutex{},
 		downloadedMu: &sync.Mutex{},
 	}
+	tor.storage = NewMemoryStorage(tor)
+	return tor
 }
 
 // sha1Of returns the raw 20-byte SHA1 of data, ready for use with NewPieces.
@@ -77,14 +96,31 @@ func sha1Of(data []byte) []byte {
 	return h[:]
 }
 
-// makePieceMsg builds a PeerMessage of PieceMessageType carrying the given data.
-func makePieceMsg(index, offset uint32, data []byte) *util.PeerMessage {
-	payload := make([]byte, 1+4+4+len(data))
-	payload[0] = byte(util.PieceMessageType)
-	binary.BigEndian.PutUint32(payload[1:5], index)
-	binary.BigEndian.PutUint32(payload[5:9], offset)
-	copy(payload[9:], data)
-	return util.NewPeerMessage(payload)
+// setContent sets length and piece hashes of tor, so content is its data.
+func setContent(t *testing.T, tor *Torrent, content []byte) {
+	t.Helper()
+	var hashes []byte
+	for begin := 0; begin < len(content); begin += tor.PieceLength {
+		hashes = append(hashes, sha1Of(content[begin:min(begin+tor.PieceLength, len(content))])...)
+	}
+	pieces, err := NewPieces(hashes)
+	require.NoError(t, err)
+
+	tor.Length = len(content)
+	tor.Pieces = pieces
+	tor.PiecesNum = len(pieces)
+	tor.requested = bitset.New(uint(tor.PiecesNum))
+	tor.downloaded = bitset.New(uint(tor.PiecesNum))
+}
+
+// writeBlocks passes blocks to WritePiece of tor.
+func writeBlocks(tor *Torrent, blocks ...*Block) {
+	ch := make(chan *Block, len(blocks))
+	for _, b := range blocks {
+		ch <- b
+	}
+	close(ch)
+	tor.WritePiece(ch, stats.NewStats(0))
 }
 
 // makeMultiFileTorrent creates a directory torrent with real temp files.
@@ -101,11 +137,27 @@ func makeMultiFileTorrent(t *testing.T, dir string, files []bencode.TorrentFile,
 		downloadedMu: &sync.Mutex{},
 		logger:       zap.NewNop(),
 	}
-	require.NoError(t, tor.initDownloadDir(dir))
-	t.Cleanup(func() { tor.Close() })
+	createFileStorage(t, tor, dir)
 	return tor
 }
 
+// createFileStorage creates files of tor in dir and keeps its data in them.
+func createFileStorage(t *testing.T, tor *Torrent, dir string) {
+	t.Helper()
+	storage, err := NewFileStorage(tor, dir)
+	require.NoError(t, err)
+	tor.storage = storage
+	t.Cleanup(func() { tor.Close() })
+}
+
+// osFile returns open file i of torrent file storage.
+func osFile(t *testing.T, tor *Torrent, i int) *os.File {
+	t.Helper()
+	s, ok := tor.storage.(*FileStorage)
+	require.True(t, ok, "torrent data is not stored in files")
+	return s.osFiles[i]
+}
+
 // readAt reads n bytes from f at the given offset.
 func readAt(t *testing.T, f *os.File, offset int64, n int) []byte {
 	t.Helper()
@@ -193,88 +245,6 @@ func TestSetDownloaded_AllPiecesLeadsToDone(t *testing.T) {
 	assert.True(t, tor.Done())
 }
 
-// --- Next --------------------------------------------------------------------
-
-func TestNext_ReturnsNotFoundWhenPeerHasNothing(t *testing.T) {
-	tor := makeTorrent(4)
-	emptyHave := bitset.New(4)
-	_, found := tor.Next(emptyHave)
-	assert.False(t, found)
-}
-
-func TestNext_ReturnsPieceAvailableInPeerBitset(t *testing.T) {
-	tor := makeTorrent(4)
-	have := bitset.New(4)
-	have.Set(2)
-
-	idx, found := tor.Next(have)
-	require.True(t, found)
-	assert.Equal(t, uint(2), idx)
-}
-
-func TestNext_MarksPieceAsRequested(t *testing.T) {
-	tor := makeTorrent(4)
-	have := bitset.New(4)
-	have.Set(1)
-
-	tor.Next(have)
-	assert.True(t, tor.requested.Test(1))
-}
-
-func TestNext_SkipsAlreadyRequestedPiece(t *testing.T) {
-	tor := makeTorrent(4)
-	have := bitset.New(4)
-	have.Set(0)
-	have.Set(1)
-	tor.requested.Set(0) // already in flight
-
-	idx, found := tor.Next(have)
-	require.True(t, found)
-	assert.Equal(t, uint(1), idx)
-}
-
-func TestNext_ReturnsNotFoundWhenAllRequested(t *testing.T) {
-	const n = uint(3)
-	tor := makeTorrent(int(n))
-	have := bitset.New(n)
-	for i := uint(0); i < n; i++ {
-		have.Set(i)
-		tor.requested.Set(i)
-	}
-	_, found := tor.Next(have)
-	assert.False(t, found)
-}
-
-func TestNext_IteratesPastMultiplePiecesNotInPeerBitset(t *testing.T) {
-	// Peer has only the last piece. The loop must walk through pieces 0–3
-	// before finding piece 4. This validates the i+1 advancement in the loop.
-	tor := makeTorrent(5)
-	have := bitset.New(5)
-	have.Set(4)
-
-	idx, found := tor.Next(have)
-	require.True(t, found)
-	assert.Equal(t, uint(4), idx)
-	assert.True(t, tor.requested.Test(4))
-}
-
-func TestNext_ZeroBitsetNeverFindsAPiece(t *testing.T) {
-	// Documents the ordering bug: when PiecesNum=0 at bitset init time,
-	// the requested bitset has size 0, NextClear finds nothing, and Next()
-	// always returns false — no pieces are ever requested.
-	tor := &Torrent{
-		requested:   bitset.New(0),
-		requestedMu: &sync.Mutex{},
-	}
-	have := bitset.New(4)
-	have.Set(0)
-	have.Set(1)
-
-	_, found := tor.Next(have)
-	assert.False(t, found,
-		"zero-size requested bitset means Next() never returns a piece — symptom of the ordering bug")
-}
-
 // --- CheckPiece --------------------------------------------------------------
 
 func TestCheckPiece_ValidData(t *testing.T) {
@@ -314,43 +284,35 @@ func TestWritePiece_SingleFile_WritesDataAtOffset(t *testing.T) {
 		IsDirectory:  false,
 		Name:         "single.bin",
 		PieceLength:  100,
-		requested:    bitset.New(1),
-		downloaded:   bitset.New(1),
 		requestedMu:  &sync.Mutex{},
 		downloadedMu: &sync.Mutex{},
 		logger:       zap.NewNop(),
 	}
-	require.NoError(t, tor.initDownloadDir(dir))
-	t.Cleanup(func() { tor.Close() })
-
-	ch := make(chan *util.PeerMessage, 1)
-	ch <- makePieceMsg(0, 0, data)
-	close(ch)
+	setContent(t, tor, data)
+	createFileStorage(t, tor, dir)
 
-	tor.WritePiece(ch, stats.NewStats(0))
+	writeBlocks(tor, &Block{Index: 0, Data: data})
 
-	got := readAt(t, tor.OsFiles[0], 0, len(data))
+	got := readAt(t, osFile(t, tor, 0), 0, len(data))
 	assert.Equal(t, data, got)
+	assert.True(t, tor.downloaded.Test(0))
 }
 
 func TestWritePiece_MultiFile_PieceFitsInFirstFile(t *testing.T) {
 	dir := t.TempDir()
-	data := []byte("fits")
+	content := []byte("fits in a.second b")
 
 	tor := makeMultiFileTorrent(t, dir, []bencode.TorrentFile{
 		{Path: []string{"a.bin"}, Length: 10},
-		{Path: []string{"b.bin"}, Length: 10},
+		{Path: []string{"b.bin"}, Length: 8},
 	}, 10)
+	setContent(t, tor, content)
 
-	ch := make(chan *util.PeerMessage, 1)
-	ch <- makePieceMsg(0, 0, data) // piecePoss = 0; file a has 10 bytes free
-	close(ch)
+	writeBlocks(tor, &Block{Index: 0, Data: content[:10]}) // file a has 10 bytes free
 
-	tor.WritePiece(ch, stats.NewStats(0))
-
-	assert.Equal(t, data, readAt(t, tor.OsFiles[0], 0, len(data)))
+	assert.Equal(t, content[:10], readAt(t, osFile(t, tor, 0), 0, 10))
 	// second file untouched — verify it's still empty
-	info, err := tor.OsFiles[1].Stat()
+	info, err := osFile(t, tor, 1).Stat()
 	require.NoError(t, err)
 	assert.Equal(t, int64(0), info.Size())
 }
@@ -360,20 +322,17 @@ func TestWritePiece_MultiFile_PieceInSecondFile(t *testing.T) {
 	// Loop: file a (length 5) is NOT > 5 → subtract → piecePoss = 0.
 	// Loop: file b (length 10) IS > 0 → break, write to b at offset 0.
 	dir := t.TempDir()
-	data := []byte("bbb")
+	content := []byte("aaaaabbbbbccccc")
 
 	tor := makeMultiFileTorrent(t, dir, []bencode.TorrentFile{
 		{Path: []string{"a.bin"}, Length: 5},
 		{Path: []string{"b.bin"}, Length: 10},
 	}, 5)
+	setContent(t, tor, content)
 
-	ch := make(chan *util.PeerMessage, 1)
-	ch <- makePieceMsg(1, 0, data)
-	close(ch)
-
-	tor.WritePiece(ch, stats.NewStats(0))
+	writeBlocks(tor, &Block{Index: 1, Data: content[5:10]})
 
-	assert.Equal(t, data, readAt(t, tor.OsFiles[1], 0, len(data)))
+	assert.Equal(t, content[5:10], readAt(t, osFile(t, tor, 1), 0, 5))
 }
 
 func TestWritePiece_MultiFile_PieceSpansThreeFiles(t *testing.T) {
@@ -386,16 +345,13 @@ func TestWritePiece_MultiFile_PieceSpansThreeFiles(t *testing.T) {
 		{Path: []string{"b.bin"}, Length: 3},
 		{Path: []string{"c.bin"}, Length: 3},
 	}, 9)
+	setContent(t, tor, data)
 
-	ch := make(chan *util.PeerMessage, 1)
-	ch <- makePieceMsg(0, 0, data)
-	close(ch)
-
-	tor.WritePiece(ch, stats.NewStats(0))
+	writeBlocks(tor, &Block{Index: 0, Data: data})
 
-	assert.Equal(t, data[0:3], readAt(t, tor.OsFiles[0], 0, 3))
-	assert.Equal(t, data[3:6], readAt(t, tor.OsFiles[1], 0, 3))
-	assert.Equal(t, data[6:9], readAt(t, tor.OsFiles[2], 0, 3))
+	assert.Equal(t, data[0:3], readAt(t, osFile(t, tor, 0), 0, 3))
+	assert.Equal(t, data[3:6], readAt(t, osFile(t, tor, 1), 0, 3))
+	assert.Equal(t, data[6:9], readAt(t, osFile(t, tor, 2), 0, 3))
 }
 
 func TestWritePiece_MultiFile_PieceSpansTwoFiles(t *testing.T) {
@@ -406,45 +362,34 @@ func TestWritePiece_MultiFile_PieceSpansTwoFiles(t *testing.T) {
 
 	tor := makeMultiFileTorrent(t, dir, []bencode.TorrentFile{
 		{Path: []string{"a.bin"}, Length: 5},
-		{Path: []string{"b.bin"}, Length: 10},
+		{Path: []string{"b.bin"}, Length: 3},
 	}, 15)
+	setContent(t, tor, data)
 
-	ch := make(chan *util.PeerMessage, 1)
-	ch <- makePieceMsg(0, 0, data)
-	close(ch)
-
-	tor.WritePiece(ch, stats.NewStats(0))
+	writeBlocks(tor, &Block{Index: 0, Data: data})
 
-	assert.Equal(t, data[:5], readAt(t, tor.OsFiles[0], 0, 5), "first 5 bytes in file a")
-	assert.Equal(t, data[5:], readAt(t, tor.OsFiles[1], 0, 3), "remaining 3 bytes in file b")
+	assert.Equal(t, data[:5], readAt(t, osFile(t, tor, 0), 0, 5), "first 5 bytes in file a")
+	assert.Equal(t, data[5:], readAt(t, osFile(t, tor, 1), 0, 3), "remaining 3 bytes in file b")
 }
 
-func TestWritePiece_MarksDownloadedOnLastBlock(t *testing.T) {
-	// The last piece of a torrent has a smaller final block.
-	// SetDownloaded must fire when offset+len(data)==PieceLength, not offset+BlockLength.
-	dir := t.TempDir()
-	pieceLength := 10
-	lastBlockData := []byte("end") // 3 bytes; offset 7 + 3 = pieceLength (10)
+// --- New ---------------------------------------------------------------------
 
-	tor := &Torrent{
-		IsDirectory:  false,
-		Name:         "last.bin",
-		PieceLength:  pieceLength,
-		PiecesNum:    1,
-		requested:    bitset.New(1),
-		downloaded:   bitset.New(1),
-		requestedMu:  &sync.Mutex{},
-		downloadedMu: &sync.Mutex{},
-		logger:       zap.NewNop(),
-	}
-	require.NoError(t, tor.initDownloadDir(dir))
-	t.Cleanup(func() { tor.Close() })
+func TestNew_Private(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(path, []byte("private data"), 0o644))
 
-	ch := make(chan *util.PeerMessage, 1)
-	ch <- makePieceMsg(0, uint32(pieceLength-len(lastBlockData)), lastBlockData)
-	close(ch)
+	m, err := bencode.Create(path, bencode.CreateOptions{
+		AnnounceList: [][]string{{"udp://tracker"}},
+		Private:      true,
+	})
+	require.NoError(t, err)
 
-	tor.WritePiece(ch, stats.NewStats(0))
+	tor, err := New(m, t.TempDir(), zap.NewNop())
+	require.NoError(t, err)
+	t.Cleanup(func() { tor.Close() })
+	assert.True(t, tor.Private)
 
-	assert.True(t, tor.downloaded.Test(0), "piece 0 should be marked downloaded after last block")
+	m.Announce = ""
+	_, err = New(m, t.TempDir(), zap.NewNop())
+	assert.ErrorContains(t, err, "private torrent has no trackers")
 }
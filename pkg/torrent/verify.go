@@ -0,0 +1,147 @@
+package torrent
+
+import (
+	"context"
+	"runtime"
+	"sync"
+
+	"github.com/bits-and-blooms/bitset"
+	"go.uber.org/zap"
+
+	"github.com/anivanovic/gotit/pkg/bencode"
+)
+
+// Load creates torrent from metainfo with existing files in downloadDir,
+// opened for reading. Missing files are left out, so their pieces fail
+// verification.
+func Load(metainfo *bencode.Metainfo, downloadDir string, logger *zap.Logger) (*Torrent, error) {
+	t, err := newTorrent(metainfo, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	storage, err := openFileStorage(t, downloadDir)
+	if err != nil {
+		return nil, err
+	}
+	t.storage = storage
+
+	return t, nil
+}
+
+// FileStatus is verification result of single torrent file.
+type FileStatus struct {
+	Path   string
+	Length int
+	// Pieces is number of pieces holding file data, while Verified is
+	// number of those pieces matching piece hashes.
+	Pieces   int
+	Verified int
+	Missing  bool
+}
+
+// Complete reports whether all file data is verified.
+func (f FileStatus) Complete() bool {
+	return !f.Missing && f.Verified == f.Pieces
+}
+
+// VerifyResult holds pieces matching their hashes and completion of
+// torrent files.
+type VerifyResult struct {
+	Have  *bitset.BitSet
+	Files []FileStatus
+}
+
+// Complete reports whether all torrent pieces are verified.
+func (r *VerifyResult) Complete() bool {
+	return r.Have.All()
+}
+
+// Verify reads torrent files and checks every piece against its hash,
+// with number of CPUs workers. Torrent download state is not changed.
+func (t *Torrent) Verify(ctx context.Context) (*VerifyResult, error) {
+	have := bitset.New(uint(t.PiecesNum))
+	haveMu := &sync.Mutex{}
+
+	jobs := make(chan int)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, t.PieceLength)
+			for index := range jobs {
+				data := buf[:t.PieceSize(index)]
+				if err := t.storage.ReadAt(index, data, 0); err != nil {
+					t.logger.Debug("reading piece failed", zap.Int("index", index), zap.Error(err))
+					continue
+				}
+				if !t.CheckPiece(data, index) {
+					continue
+				}
+
+				haveMu.Lock()
+				have.Set(uint(index))
+				haveMu.Unlock()
+			}
+		}()
+	}
+
+	var err error
+feed:
+	for index := 0; index < t.PiecesNum; index++ {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		select {
+		case jobs <- index:
+		case <-ctx.Done():
+			err = ctx.Err()
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if err != nil {
+		return nil, err
+	}
+
+	return &VerifyResult{Have: have, Files: t.fileStatus(have)}, nil
+}
+
+// fileStatus returns completion of torrent files given verified pieces.
+func (t *Torrent) fileStatus(have *bitset.BitSet) []FileStatus {
+	files := t.TorrentFiles
+	if !t.IsDirectory {
+		files = []bencode.TorrentFile{{Path: []string{t.Name}, Length: t.Length}}
+	}
+
+	var status []FileStatus
+	offset := 0
+	for i, f := range files {
+		begin, end := offset, offset+f.Length
+		offset = end
+		if f.IsPad() {
+			continue
+		}
+
+		s := FileStatus{
+			Path:   f.FilePath(),
+			Length: f.Length,
+		}
+		if fs, ok := t.storage.(*FileStorage); ok {
+			// only files can be missing from storage
+			s.Missing = fs.missing(i)
+		}
+		if f.Length > 0 {
+			for p := begin / t.PieceLength; p <= (end-1)/t.PieceLength; p++ {
+				s.Pieces++
+				if have.Test(uint(p)) {
+					s.Verified++
+				}
+			}
+		}
+		status = append(status, s)
+	}
+	return status
+}
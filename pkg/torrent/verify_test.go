@@ -0,0 +1,108 @@
+package torrent
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/anivanovic/gotit/pkg/bencode"
+)
+
+// createVerifyContent writes files a (20000 bytes), b/c (30000 bytes) and
+// d (100 bytes) to content directory and creates torrent for them.
+func createVerifyContent(t *testing.T, version bencode.MetaVersion) (string, *bencode.Metainfo) {
+	t.Helper()
+	root := t.TempDir()
+	dir := filepath.Join(root, "content")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "b"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a"), bytes.Repeat([]byte{1}, 20000), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "b", "c"), bytes.Repeat([]byte{2}, 30000), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "d"), bytes.Repeat([]byte{3}, 100), 0o644))
+
+	m, err := bencode.Create(dir, bencode.CreateOptions{
+		PieceLength:  16 * 1024,
+		AnnounceList: [][]string{{"udp://tracker"}},
+		Version:      version,
+	})
+	require.NoError(t, err)
+	return root, m
+}
+
+func TestVerify_Complete(t *testing.T) {
+	for _, version := range []bencode.MetaVersion{bencode.MetaV1, bencode.MetaV2, bencode.MetaHybrid} {
+		root, m := createVerifyContent(t, version)
+		tor := newTorrentIn(t, m, root)
+
+		result, err := tor.Verify(context.Background())
+		require.NoError(t, err)
+		assert.True(t, result.Complete(), "version %d", version)
+		require.Len(t, result.Files, 3)
+		for _, f := range result.Files {
+			assert.True(t, f.Complete(), "file %s", f.Path)
+		}
+	}
+}
+
+func TestVerify_CorruptedAndMissing(t *testing.T) {
+	root, m := createVerifyContent(t, bencode.MetaV1)
+
+	// second piece spans a and b/c
+	f, err := os.OpenFile(filepath.Join(root, "content", "a"), os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte{0xFF}, 19999)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.NoError(t, os.Remove(filepath.Join(root, "content", "d")))
+
+	tor, err := Load(m, root, zap.NewNop())
+	require.NoError(t, err)
+	defer tor.Close()
+	result, err := tor.Verify(context.Background())
+	require.NoError(t, err)
+
+	assert.False(t, result.Complete())
+	assert.True(t, result.Have.Test(0))
+	assert.False(t, result.Have.Test(1))
+	assert.True(t, result.Have.Test(2))
+	// last piece holds end of b/c and missing d
+	assert.False(t, result.Have.Test(3))
+
+	assert.Equal(t, []FileStatus{
+		{Path: "a", Length: 20000, Pieces: 2, Verified: 1},
+		{Path: "b/c", Length: 30000, Pieces: 3, Verified: 1},
+		{Path: "d", Length: 100, Pieces: 1, Verified: 0, Missing: true},
+	}, result.Files)
+}
+
+func TestVerify_SingleFileTruncated(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(path, bytes.Repeat([]byte("x"), 40000), 0o644))
+	m, err := bencode.Create(path, bencode.CreateOptions{PieceLength: 16 * 1024})
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(path, 30000))
+
+	tor, err := Load(m, dir, zap.NewNop())
+	require.NoError(t, err)
+	defer tor.Close()
+	result, err := tor.Verify(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, uint(1), result.Have.Count())
+	assert.Equal(t, []FileStatus{{Path: "file", Length: 40000, Pieces: 3, Verified: 1}}, result.Files)
+}
+
+func TestVerify_Canceled(t *testing.T) {
+	root, m := createVerifyContent(t, bencode.MetaV1)
+	tor := newTorrentIn(t, m, root)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := tor.Verify(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -21,6 +21,9 @@ const (
 	PieceMessageType
 	CancelMessageType
 
+	// ExtendedMessageType is used by extension protocol (BEP 10).
+	ExtendedMessageType MessageType = 20
+
 	// KeepaliveMessageType has only length without type.
 	// This is fake type which is never actually used.
 	KeepaliveMessageType MessageType = 99
@@ -114,6 +117,26 @@ func (m PeerMessage) Data() []byte {
 	return m.payload[8:]
 }
 
+// ExtendedId returns extension message id, which is 0 for extension
+// handshake.
+func (m PeerMessage) ExtendedId() uint8 {
+	if m.Type != ExtendedMessageType || len(m.payload) == 0 {
+		return 0
+	}
+
+	return m.payload[0]
+}
+
+// ExtendedPayload returns payload of extension message following the
+// extension message id.
+func (m PeerMessage) ExtendedPayload() []byte {
+	if m.Type != ExtendedMessageType || len(m.payload) == 0 {
+		return nil
+	}
+
+	return m.payload[1:]
+}
+
 func (m PeerMessage) Bitfield() *bitset.BitSet {
 	if m.Type != BitfieldMessageType {
 		return nil
@@ -219,6 +242,20 @@ func CreatePieceMessage(index, offset, blockLength uint32) *PeerMessage {
 	return &msg
 }
 
+// CreateExtendedMessage creates extension protocol message with given
+// extension message id and bencoded payload.
+func CreateExtendedMessage(id uint8, payload []byte) *PeerMessage {
+	data := make([]byte, 0, len(payload)+1)
+	data = append(data, id)
+	data = append(data, payload...)
+
+	return &PeerMessage{
+		len:     uint32(len(data) + 1),
+		Type:    ExtendedMessageType,
+		payload: data,
+	}
+}
+
 func writeBigEndian(dest io.Writer, data any) {
 	_ = binary.Write(dest, binary.BigEndian, data)
 }
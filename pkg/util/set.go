@@ -1,5 +1,10 @@
 package util
 
+import (
+	"maps"
+	"slices"
+)
+
 type StringSet map[string]struct{}
 
 func NewStringSet() StringSet {
@@ -25,3 +30,8 @@ func (s StringSet) Contains(obj string) bool {
 	_, ok := s[obj]
 	return ok
 }
+
+// Values returns set elements in sorted order.
+func (s StringSet) Values() []string {
+	return slices.Sorted(maps.Keys(s))
+}
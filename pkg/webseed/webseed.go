@@ -0,0 +1,323 @@
+// Package webseed downloads torrent pieces from HTTP servers, using web
+// seeds from url-list (BEP 19) and HTTP seeds from httpseeds (BEP 17).
+package webseed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bits-and-blooms/bitset"
+	"github.com/jpillora/backoff"
+	"go.uber.org/zap"
+
+	"github.com/anivanovic/gotit/pkg/peer"
+	"github.com/anivanovic/gotit/pkg/torrent"
+)
+
+// idleDelay is wait before asking for pieces again, when all pieces are
+// requested by seed or peers.
+const idleDelay = 2 * time.Second
+
+var ErrUnsupportedScheme = errors.New("unsupported web seed scheme")
+
+// BusyError is returned by HTTP seeds asking client to retry later.
+type BusyError struct {
+	RetryAfter time.Duration
+}
+
+func (e *BusyError) Error() string {
+	return fmt.Sprintf("http seed busy, retry after %s", e.RetryAfter)
+}
+
+// Seed downloads torrent pieces from single web seed.
+type Seed struct {
+	url *url.URL
+	// httpSeed is set for BEP 17 seeds, which serve whole pieces by
+	// index instead of files.
+	httpSeed bool
+
+	torrent *torrent.Torrent
+	picker  peer.PiecePicker
+	client  *http.Client
+	writeCh chan<- *torrent.Block
+	logger  *zap.Logger
+}
+
+// New creates web seed (BEP 19) serving torrent files under rawURL.
+// Pieces to download are claimed from picker, shared with peers.
+func New(rawURL string, t *torrent.Torrent, picker peer.PiecePicker, writeCh chan<- *torrent.Block, logger *zap.Logger) (*Seed, error) {
+	return newSeed(rawURL, false, t, picker, writeCh, logger)
+}
+
+// NewHttpSeed creates HTTP seed (BEP 17) serving torrent pieces at rawURL.
+func NewHttpSeed(rawURL string, t *torrent.Torrent, picker peer.PiecePicker, writeCh chan<- *torrent.Block, logger *zap.Logger) (*Seed, error) {
+	return newSeed(rawURL, true, t, picker, writeCh, logger)
+}
+
+func newSeed(rawURL string, httpSeed bool, t *torrent.Torrent, picker peer.PiecePicker, writeCh chan<- *torrent.Block, logger *zap.Logger) (*Seed, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("%w %s", ErrUnsupportedScheme, u.Scheme)
+	}
+
+	return &Seed{
+		url:      u,
+		httpSeed: httpSeed,
+		torrent:  t,
+		picker:   picker,
+		client:   http.DefaultClient,
+		writeCh:  writeCh,
+		logger:   logger.With(zap.String("webSeed", rawURL)),
+	}, nil
+}
+
+// Url returns web seed url.
+func (s *Seed) Url() string {
+	return s.url.String()
+}
+
+// Run downloads pieces from the seed, competing for them with peers,
+// until torrent is done or ctx is canceled. Failed pieces are released
+// for other peers and seeds. Downloaded pieces are checked when written,
+// with the seed url recorded as the source of corrupt pieces.
+func (s *Seed) Run(ctx context.Context) {
+	have := bitset.New(uint(s.torrent.PiecesNum))
+	have.FlipRange(0, uint(s.torrent.PiecesNum))
+	b := &backoff.Backoff{
+		Min:    time.Second,
+		Max:    5 * time.Minute,
+		Factor: 2,
+		Jitter: true,
+	}
+
+	for ctx.Err() == nil && !s.torrent.Done() {
+		index, found := s.picker.Next(have)
+		if !found {
+			if wait(ctx, idleDelay) != nil {
+				return
+			}
+			continue
+		}
+
+		data, err := s.FetchPiece(ctx, int(index))
+		if err != nil {
+			s.torrent.PieceFailed(index)
+			if ctx.Err() != nil {
+				return
+			}
+
+			d := b.Duration()
+			var busy *BusyError
+			if errors.As(err, &busy) {
+				d = busy.RetryAfter
+			}
+			s.logger.Warn("web seed piece download failed",
+				zap.Uint("index", index),
+				zap.Duration("backoff", d),
+				zap.Error(err))
+			if wait(ctx, d) != nil {
+				return
+			}
+			continue
+		}
+		b.Reset()
+
+		select {
+		case s.writeCh <- &torrent.Block{Index: int(index), Data: data, Source: s.Url()}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// FetchPiece downloads piece with given index. Piece data is not checked.
+func (s *Seed) FetchPiece(ctx context.Context, index int) ([]byte, error) {
+	if index < 0 || index >= s.torrent.PiecesNum {
+		return nil, fmt.Errorf("invalid piece index %d", index)
+	}
+
+	if s.httpSeed {
+		return s.fetchHttpSeedPiece(ctx, index)
+	}
+
+	data := make([]byte, s.torrent.PieceSize(index))
+	pos := 0
+	for _, sp := range s.spans(index) {
+		buf := data[pos : pos+sp.length]
+		pos += sp.length
+		if sp.pad {
+			// padding files hold zeros and are not served
+			continue
+		}
+		if err := s.fetchRange(ctx, s.fileURL(sp.path), sp.offset, buf); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// span is part of a piece stored in single file.
+type span struct {
+	path   []string
+	offset int
+	length int
+	pad    bool
+}
+
+// spans returns parts of the piece stored in torrent files.
+func (s *Seed) spans(index int) []span {
+	begin := index * s.torrent.PieceLength
+	end := begin + s.torrent.PieceSize(index)
+	if !s.torrent.IsDirectory {
+		return []span{{offset: begin, length: end - begin}}
+	}
+
+	var spans []span
+	pos := 0
+	for _, f := range s.torrent.TorrentFiles {
+		fileBegin, fileEnd := pos, pos+f.Length
+		pos = fileEnd
+		if fileEnd <= begin || f.Length == 0 {
+			continue
+		}
+		if fileBegin >= end {
+			break
+		}
+
+		from, to := max(begin, fileBegin), min(end, fileEnd)
+		spans = append(spans, span{
+			path:   f.Path,
+			offset: from - fileBegin,
+			length: to - from,
+			pad:    f.IsPad(),
+		})
+	}
+	return spans
+}
+
+// fileURL returns url of torrent file. Url of single file torrent can
+// point to the file itself, otherwise torrent name and file path are
+// appended to it.
+func (s *Seed) fileURL(path []string) string {
+	base := s.url.String()
+	if !s.torrent.IsDirectory && !strings.HasSuffix(base, "/") {
+		return base
+	}
+
+	elements := append([]string{s.torrentName()}, path...)
+	for i, el := range elements {
+		elements[i] = url.PathEscape(el)
+	}
+	return strings.TrimSuffix(base, "/") + "/" + strings.Join(elements, "/")
+}
+
+// torrentName returns torrent name as in metainfo, as torrent Name can
+// be changed to be a valid file name.
+func (s *Seed) torrentName() string {
+	if s.torrent.Metadata != nil {
+		return s.torrent.Metadata.Info.Name
+	}
+	return s.torrent.Name
+}
+
+// fetchRange reads len(buf) bytes of file at url starting at offset.
+func (s *Seed) fetchRange(ctx context.Context, url string, offset int, buf []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+len(buf)-1))
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// server does not support ranges and sends whole file
+		if _, err := io.CopyN(io.Discard, res.Body, int64(offset)); err != nil {
+			return fmt.Errorf("read %s: %w", url, err)
+		}
+	default:
+		return fmt.Errorf("web seed response %s for %s", res.Status, url)
+	}
+
+	if _, err := io.ReadFull(res.Body, buf); err != nil {
+		return fmt.Errorf("read %s: %w", url, err)
+	}
+	return nil
+}
+
+// fetchHttpSeedPiece requests piece by torrent info-hash and piece index.
+func (s *Seed) fetchHttpSeedPiece(ctx context.Context, index int) ([]byte, error) {
+	hash := s.torrent.Hash
+	if s.torrent.Metadata != nil {
+		// HTTP seeds know only v1 info-hash
+		hash = s.torrent.Metadata.Hash()
+	}
+	q := url.Values{}
+	q.Set("info_hash", string(hash))
+	q.Set("piece", strconv.Itoa(index))
+
+	u := *s.url
+	if u.RawQuery != "" {
+		u.RawQuery += "&"
+	}
+	u.RawQuery += q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	size := s.torrent.PieceSize(index)
+	body, err := io.ReadAll(io.LimitReader(res.Body, int64(size)+1))
+	if err != nil {
+		return nil, err
+	}
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusServiceUnavailable:
+		// body holds number of seconds to wait
+		seconds, err := strconv.Atoi(strings.TrimSpace(string(body)))
+		if err != nil {
+			seconds = 10
+		}
+		return nil, &BusyError{RetryAfter: time.Duration(seconds) * time.Second}
+	default:
+		return nil, fmt.Errorf("http seed response %s", res.Status)
+	}
+
+	if len(body) != size {
+		return nil, fmt.Errorf("http seed sent piece %d of invalid length", index)
+	}
+	return body, nil
+}
+
+func wait(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
@@ -0,0 +1,232 @@
+package webseed
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/anivanovic/gotit/pkg/bencode"
+	"github.com/anivanovic/gotit/pkg/stats"
+	"github.com/anivanovic/gotit/pkg/torrent"
+)
+
+const testPieceLength = 16 * 1024
+
+// testContent writes torrent files under root/name, including names which
+// must be escaped in urls.
+func testContent(t *testing.T, root, name string) {
+	t.Helper()
+	dir := filepath.Join(root, name)
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub dir"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.bin"), bytes.Repeat([]byte{1}, 20000), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub dir", "b?.bin"), bytes.Repeat([]byte{2}, 30000), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub dir", "c.bin"), bytes.Repeat([]byte{3}, 100), 0o644))
+}
+
+func newTestTorrent(t *testing.T, path string, version bencode.MetaVersion) *torrent.Torrent {
+	t.Helper()
+	m, err := bencode.Create(path, bencode.CreateOptions{
+		PieceLength:  testPieceLength,
+		AnnounceList: [][]string{{"udp://tracker"}},
+		Version:      version,
+	})
+	require.NoError(t, err)
+
+	tor, err := torrent.New(m, t.TempDir(), zap.NewNop())
+	require.NoError(t, err)
+	t.Cleanup(func() { tor.Close() })
+	return tor
+}
+
+// runSeed runs seed until it sends all torrent pieces.
+func runSeed(t *testing.T, s *Seed, tor *torrent.Torrent) map[int]*torrent.Block {
+	t.Helper()
+	writeCh := make(chan *torrent.Block)
+	s.writeCh = writeCh
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+
+	pieces := make(map[int]*torrent.Block)
+	for len(pieces) < tor.PiecesNum {
+		select {
+		case block := <-writeCh:
+			pieces[block.Index] = block
+		case <-ctx.Done():
+			t.Fatalf("received %d of %d pieces", len(pieces), tor.PiecesNum)
+		}
+	}
+	cancel()
+	<-done
+	return pieces
+}
+
+func TestSeed_MultiFile(t *testing.T) {
+	for _, version := range []bencode.MetaVersion{bencode.MetaV1, bencode.MetaHybrid} {
+		root := t.TempDir()
+		testContent(t, root, "content")
+		tor := newTestTorrent(t, filepath.Join(root, "content"), version)
+
+		srv := httptest.NewServer(http.FileServer(http.Dir(root)))
+		defer srv.Close()
+
+		s, err := New(srv.URL, tor, torrent.NewRarestFirst(tor), nil, zap.NewNop())
+		require.NoError(t, err)
+
+		pieces := runSeed(t, s, tor)
+		for index, block := range pieces {
+			assert.Equal(t, 0, block.Offset)
+			assert.Equal(t, s.Url(), block.Source)
+			assert.True(t, tor.CheckPiece(block.Data, index), "piece %d", index)
+		}
+	}
+}
+
+func TestSeed_SingleFile(t *testing.T) {
+	root := t.TempDir()
+	data := bytes.Repeat([]byte("web seed"), 5000)
+	require.NoError(t, os.WriteFile(filepath.Join(root, "file.bin"), data, 0o644))
+	tor := newTestTorrent(t, filepath.Join(root, "file.bin"), bencode.MetaV1)
+
+	srv := httptest.NewServer(http.FileServer(http.Dir(root)))
+	defer srv.Close()
+
+	for _, url := range []string{srv.URL + "/", srv.URL + "/file.bin"} {
+		s, err := New(url, tor, torrent.NewRarestFirst(tor), nil, zap.NewNop())
+		require.NoError(t, err)
+
+		got, err := s.FetchPiece(context.Background(), tor.PiecesNum-1)
+		require.NoError(t, err)
+		assert.Equal(t, data[(tor.PiecesNum-1)*testPieceLength:], got)
+	}
+}
+
+func TestSeed_ReleasesFailedPiece(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "file.bin"), []byte("data"), 0o644))
+	tor := newTestTorrent(t, filepath.Join(root, "file.bin"), bencode.MetaV1)
+
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	picker := torrent.NewRarestFirst(tor)
+	s, err := New(srv.URL+"/", tor, picker, make(chan *torrent.Block), zap.NewNop())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+
+	// piece is requested by the seed and released after failure
+	require.Eventually(t, func() bool {
+		index, found := picker.Next(tor.EmptyBitset().Set(0))
+		if found {
+			tor.PieceFailed(index)
+		}
+		return found
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+}
+
+func TestSeed_CorruptPiece(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, "file.bin")
+	require.NoError(t, os.WriteFile(path, []byte("data"), 0o644))
+	tor := newTestTorrent(t, path, bencode.MetaV1)
+	require.NoError(t, os.WriteFile(path, []byte("bad!"), 0o644))
+
+	srv := httptest.NewServer(http.FileServer(http.Dir(root)))
+	defer srv.Close()
+
+	writeCh := make(chan *torrent.Block)
+	s, err := New(srv.URL+"/", tor, torrent.NewRarestFirst(tor), writeCh, zap.NewNop())
+	require.NoError(t, err)
+
+	written := make(chan struct{})
+	go func() {
+		tor.WritePiece(writeCh, stats.NewStats(0))
+		close(written)
+	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+
+	// corrupt piece is sent for writing and blamed on the seed
+	require.Eventually(t, func() bool {
+		return tor.CorruptPieces(s.Url()) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+	close(writeCh)
+	<-written
+	assert.False(t, tor.Done())
+}
+
+func TestSeed_UnsupportedScheme(t *testing.T) {
+	_, err := New("ftp://mirror/file", nil, nil, nil, zap.NewNop())
+	assert.ErrorIs(t, err, ErrUnsupportedScheme)
+}
+
+func TestHttpSeed(t *testing.T) {
+	root := t.TempDir()
+	data := bytes.Repeat([]byte("http seed"), 5000)
+	require.NoError(t, os.WriteFile(filepath.Join(root, "file.bin"), data, 0o644))
+	tor := newTestTorrent(t, filepath.Join(root, "file.bin"), bencode.MetaV1)
+
+	busy := &atomic.Bool{}
+	busy.Store(true)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !assert.Equal(t, string(tor.Metadata.Hash()), r.URL.Query().Get("info_hash")) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if busy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte("30"))
+			return
+		}
+
+		var index int
+		_, _ = fmt.Sscan(r.URL.Query().Get("piece"), &index)
+		begin := index * testPieceLength
+		_, _ = w.Write(data[begin:min(begin+testPieceLength, len(data))])
+	}))
+	defer srv.Close()
+
+	s, err := NewHttpSeed(srv.URL+"/seed", tor, torrent.NewRarestFirst(tor), nil, zap.NewNop())
+	require.NoError(t, err)
+
+	_, err = s.FetchPiece(context.Background(), 0)
+	var busyErr *BusyError
+	require.ErrorAs(t, err, &busyErr)
+	assert.Equal(t, 30*time.Second, busyErr.RetryAfter)
+
+	busy.Store(false)
+	pieces := runSeed(t, s, tor)
+	for index, block := range pieces {
+		assert.True(t, tor.CheckPiece(block.Data, index), "piece %d", index)
+	}
+}